@@ -25,6 +25,22 @@ try {
   webkit = pw.webkit;
 }
 
+// `--list-browsers` prints which Playwright engines have a browser binary
+// installed, as a JSON object keyed by engine name, and exits.
+if (process.argv[2] === "--list-browsers") {
+  const engines = { chromium, firefox, webkit };
+  const out = {};
+  for (const [name, type] of Object.entries(engines)) {
+    try {
+      out[name] = fs.existsSync(type.executablePath());
+    } catch {
+      out[name] = false;
+    }
+  }
+  console.log(JSON.stringify(out));
+  process.exit(0);
+}
+
 const urlsFile = process.argv[2];
 
 if (!urlsFile) {
@@ -49,6 +65,11 @@ const INCLUDE_CONSOLE_CSP = String(process.env.CSP_INCLUDE_CONSOLE_CSP || "1") =
 const HEADLESS = String(process.env.CSP_HEADLESS || "1") !== "0";
 const STEALTH = String(process.env.CSP_STEALTH || "1") === "1";
 const BROWSER = String(process.env.CSP_BROWSER || "chromium").toLowerCase();
+// Device emulation, set by the server per device profile.
+const DEVICE = process.env.CSP_DEVICE || "";
+const VIEWPORT_WIDTH = Number(process.env.CSP_VIEWPORT_WIDTH || 1280);
+const VIEWPORT_HEIGHT = Number(process.env.CSP_VIEWPORT_HEIGHT || 720);
+const MOBILE = process.env.CSP_MOBILE === "1";
 
 const USER_AGENT =
   process.env.CSP_USER_AGENT ||
@@ -56,6 +77,42 @@ const USER_AGENT =
 
 const ACCEPT_LANGUAGE = process.env.CSP_ACCEPT_LANGUAGE || "en-US,en;q=0.9";
 
+// Optional HTTP basic auth for staging sites. The password is never logged
+// or written to the JSON report.
+const BASIC_AUTH_USER = process.env.CSP_BASIC_AUTH_USER || "";
+const BASIC_AUTH_PASS = process.env.CSP_BASIC_AUTH_PASS || "";
+
+// Load pages with JavaScript disabled to see violations caused by markup alone.
+const DISABLE_JS = String(process.env.CSP_DISABLE_JS || "0") === "1";
+const IGNORE_TLS = String(process.env.CSP_IGNORE_TLS || "0") === "1";
+
+// Store the main document's response headers per page, with cookie and auth
+// headers masked.
+const CAPTURE_HEADERS = String(process.env.CSP_CAPTURE_HEADERS || "0") === "1";
+const MASKED_HEADERS = new Set([
+  "cookie",
+  "set-cookie",
+  "authorization",
+  "proxy-authorization",
+  "www-authenticate",
+  "proxy-authenticate",
+]);
+
+function maskHeaders(headers) {
+  const out = {};
+  for (const [name, value] of Object.entries(headers || {})) {
+    const key = name.toLowerCase();
+    out[key] = MASKED_HEADERS.has(key) ? "[masked]" : value;
+  }
+  return out;
+}
+
+// Optional pre-navigation steps (goto/fill/click), run once before the checks,
+// e.g. to submit a login form. Fill values are never logged or reported.
+const PRE_ACTIONS = process.env.CSP_PRE_ACTIONS
+  ? JSON.parse(process.env.CSP_PRE_ACTIONS)
+  : [];
+
 function sleep(ms) {
   return new Promise((r) => setTimeout(r, ms));
 }
@@ -232,6 +289,11 @@ async function checkUrl(context, url) {
   let status = null;
   let ok = false;
   let error = null;
+  // settled is false when navigation timed out before reaching WAIT_UNTIL,
+  // so late violations may not have been captured.
+  let settled = true;
+  let headers = null;
+  let hasCsp = null;
 
   try {
     const resp = await page.goto(url, {
@@ -239,6 +301,20 @@ async function checkUrl(context, url) {
       timeout: NAV_TIMEOUT_MS,
     });
     status = resp ? resp.status() : null;
+    if (resp) {
+      const all = await resp.allHeaders();
+      if (CAPTURE_HEADERS) headers = maskHeaders(all);
+      hasCsp = Boolean(
+        all["content-security-policy"] || all["content-security-policy-report-only"]
+      );
+      if (!hasCsp) {
+        const metas = await page
+          .locator('meta[http-equiv="content-security-policy" i]')
+          .count()
+          .catch(() => 0);
+        hasCsp = metas > 0;
+      }
+    }
     ok = true;
 
     if (WAIT_AFTER_LOAD_MS > 0) {
@@ -246,6 +322,7 @@ async function checkUrl(context, url) {
     }
   } catch (e) {
     error = String(e && e.message ? e.message : e);
+    if (e && e.name === "TimeoutError") settled = false;
   } finally {
     await page.close();
   }
@@ -267,6 +344,9 @@ async function checkUrl(context, url) {
     status,
     ok,
     error,
+    settled,
+    headers: headers || undefined,
+    hasCsp: hasCsp === null ? undefined : hasCsp,
     durationMs: Date.now() - start,
     violations: [...uniq.values()],
   };
@@ -411,6 +491,12 @@ console.error(`[csp] settle wait: ${WAIT_AFTER_LOAD_MS}ms`);
 console.error(`[csp] concurrency: ${CONCURRENCY}`);
 console.error(`[csp] between-url delay: ${BETWEEN_URL_MS}ms`);
 console.error(`[csp] UA: ${USER_AGENT}`);
+if (DEVICE) {
+  console.error(`[csp] device: ${DEVICE} (${VIEWPORT_WIDTH}x${VIEWPORT_HEIGHT}${MOBILE ? ", mobile" : ""})`);
+}
+if (BASIC_AUTH_USER) {
+  console.error(`[csp] basic auth: ${BASIC_AUTH_USER} (password hidden)`);
+}
 console.error(`[csp] verbose: ${VERBOSE ? "on" : "off"} (details printed at end)`);
 
 const browserType = BROWSER === "firefox" ? firefox : BROWSER === "webkit" ? webkit : chromium;
@@ -429,11 +515,20 @@ const browser = await browserType.launch({
   args: launchArgs,
 });
 
-async function createContext() {
+async function createContext(storageState) {
   return await browser.newContext({
+    storageState,
+    httpCredentials: BASIC_AUTH_USER
+      ? { username: BASIC_AUTH_USER, password: BASIC_AUTH_PASS }
+      : undefined,
+    javaScriptEnabled: !DISABLE_JS,
+    ignoreHTTPSErrors: IGNORE_TLS,
     userAgent: USER_AGENT,
     locale: "en-US",
-    viewport: { width: 1280, height: 720 },
+    viewport: { width: VIEWPORT_WIDTH, height: VIEWPORT_HEIGHT },
+    // Firefox does not support mobile emulation, only the viewport and UA.
+    isMobile: MOBILE && BROWSER !== "firefox" ? true : undefined,
+    hasTouch: MOBILE && BROWSER !== "firefox" ? true : undefined,
     timezoneId: "UTC",
     extraHTTPHeaders: {
       "Accept-Language": ACCEPT_LANGUAGE,
@@ -443,6 +538,34 @@ async function createContext() {
   });
 }
 
+// Runs PRE_ACTIONS in a throwaway context and returns its cookies and storage
+// for the page checks, or undefined when there are no actions.
+async function runPreActions() {
+  if (!PRE_ACTIONS.length) return undefined;
+  const ctx = await createContext();
+  const page = await ctx.newPage();
+  try {
+    for (const [i, a] of PRE_ACTIONS.entries()) {
+      console.error(`[csp] pre-action ${i + 1}/${PRE_ACTIONS.length}: ${a.action}${a.selector ? ` ${a.selector}` : ""}`);
+      if (a.action === "goto") {
+        await page.goto(a.url, { waitUntil: WAIT_UNTIL, timeout: NAV_TIMEOUT_MS });
+      } else if (a.action === "fill") {
+        await page.fill(a.selector, a.value || "", { timeout: NAV_TIMEOUT_MS });
+      } else if (a.action === "click") {
+        await page.click(a.selector, { timeout: NAV_TIMEOUT_MS });
+        await page.waitForLoadState(WAIT_UNTIL, { timeout: NAV_TIMEOUT_MS }).catch(() => {});
+      } else {
+        throw new Error(`unknown pre-action ${a.action}`);
+      }
+    }
+    return await ctx.storageState();
+  } finally {
+    await ctx.close();
+  }
+}
+
+const preActionState = await runPreActions();
+
 const results = await runQueue(targets, CONCURRENCY, async (u, i) => {
   console.error(`[csp] (${i + 1}/${targets.length}) ${u}`);
 
@@ -450,7 +573,7 @@ const results = await runQueue(targets, CONCURRENCY, async (u, i) => {
     await sleep(BETWEEN_URL_MS);
   }
 
-  const ctx = await createContext();
+  const ctx = await createContext(preActionState);
   const r = await checkUrl(ctx, u);
   await ctx.close();
   const note = r.ok ? `HTTP ${r.status ?? "?"}` : "FAILED";
@@ -466,6 +589,7 @@ await browser.close();
 
 if (OUTPUT_JSON) {
   const out = {
+    schemaVersion: 2,
     baseUrl: null,
     generatedAt: new Date().toISOString(),
     config: {
@@ -476,11 +600,18 @@ if (OUTPUT_JSON) {
       betweenUrlMs: BETWEEN_URL_MS,
       userAgent: USER_AGENT,
       acceptLanguage: ACCEPT_LANGUAGE,
+      basicAuthUser: BASIC_AUTH_USER || null,
+      disableJs: DISABLE_JS,
+      ignoreTlsErrors: IGNORE_TLS,
+      captureHeaders: CAPTURE_HEADERS,
+      preActions: PRE_ACTIONS.length,
       browser: BROWSER,
+      device: DEVICE || undefined,
       verbose: VERBOSE,
       showPolicy: SHOW_POLICY,
       policyMaxLen: POLICY_MAXLEN,
     },
+    settled: results.every((r) => r.settled),
     totals: {
       pages: targets.length,
       violations: results.reduce((acc, r) => acc + r.violations.length, 0),
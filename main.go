@@ -1,26 +1,43 @@
 package main
 
 import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"embed"
+	"encoding/csv"
+	"encoding/gob"
 	"io/fs"
 	"html/template"
 	"io"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"os/exec"
 	"path/filepath"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
 type Profile struct {
@@ -28,6 +45,65 @@ type Profile struct {
 	Name       string
 	ConfigJSON string
 	CreatedAt  string
+	// Archived profiles keep their runs but are left out of run forms.
+	Archived bool
+}
+
+// URLList is a saved, named set of URLs that can prefill the run form.
+type URLList struct {
+	ID        int64
+	Name      string
+	URLsText  string
+	CreatedAt string
+}
+
+// Schedule triggers a run of URLsText with a profile every IntervalMinutes.
+// NextRunAt is stored so schedules resume after a restart.
+type Schedule struct {
+	ID              int64
+	ProfileID       sql.NullInt64
+	URLsText        string
+	IntervalMinutes int
+	NextRunAt       string
+	LastRunID       sql.NullInt64
+	CreatedAt       string
+}
+
+type BrowserInfo struct {
+	Name string `json:"name"`
+	// Available is nil when installation could not be determined.
+	Available *bool `json:"available"`
+}
+
+type ProfileHistoryEntry struct {
+	ID         int64
+	ProfileID  int64
+	ConfigJSON string
+	ChangedAt  string
+}
+
+type ConfigChange struct {
+	Field string
+	From  string
+	To    string
+}
+
+type ProfileChangeView struct {
+	ChangedAt string
+	Changes   []ConfigChange
+}
+
+// ViolationMatrix shows which violation groups appeared in each of a
+// profile's recent runs. Runs are oldest first; each row's Counts line up
+// with Runs.
+type ViolationMatrix struct {
+	Runs []Run
+	Rows []MatrixRow
+}
+
+type MatrixRow struct {
+	Key    string
+	Counts []int
 }
 
 type Run struct {
@@ -39,14 +115,30 @@ type Run struct {
 	ResultsJSON string
 	ExitCode    int
 	ElapsedMs   int64
+	// Label is an optional free-text name for the run, e.g. "before deploy".
+	Label string
+	// Pinned runs are never removed by rotateProfileRuns.
+	Pinned bool
+	// spillFile holds ResultsJSON, not yet read, for runs whose results
+	// were spilled out of the database; see loadSpilledResults.
+	spillFile string
 }
 
 type Report struct {
-	GeneratedAt string                 `json:"generatedAt"`
-	Config      map[string]any         `json:"config"`
-	Totals      ReportTotals           `json:"totals"`
-	Results     []ReportPageResult     `json:"results"`
-	BaseURL     string                 `json:"baseUrl"`
+	// SchemaVersion is the report format written by csp-check.mjs. Older
+	// shapes are upgraded by migrateReport before decoding.
+	SchemaVersion int                `json:"schemaVersion,omitempty"`
+	GeneratedAt   string             `json:"generatedAt"`
+	Config        map[string]any     `json:"config"`
+	Totals        ReportTotals       `json:"totals"`
+	Results       []ReportPageResult `json:"results"`
+	BaseURL       string             `json:"baseUrl"`
+	// Settled is false when at least one page timed out before reaching
+	// waitUntil. Reports from older scripts omit it.
+	Settled *bool `json:"settled,omitempty"`
+	// Error is set when this browser's report could not be used, e.g. a
+	// truncated file; the other browsers' results are kept.
+	Error string `json:"error,omitempty"`
 }
 
 type ReportTotals struct {
@@ -55,36 +147,112 @@ type ReportTotals struct {
 }
 
 type ReportPageResult struct {
-	URL        string       `json:"url"`
-	Status     *int         `json:"status"`
-	OK         bool         `json:"ok"`
-	Error      string       `json:"error"`
-	DurationMs int64        `json:"durationMs"`
-	Violations []Violation  `json:"violations"`
+	URL     string `json:"url"`
+	Status  *int   `json:"status"`
+	OK      bool   `json:"ok"`
+	Error   string `json:"error"`
+	Settled *bool  `json:"settled,omitempty"`
+	// Headers are the document's response headers, when the profile
+	// captures them. Cookie and auth headers are masked.
+	Headers map[string]string `json:"headers,omitempty"`
+	// HasCSP reports whether the document set a policy, by header or meta
+	// tag. Nil when the script predates the check or navigation failed.
+	HasCSP *bool `json:"hasCsp,omitempty"`
+	// Device is the deviceProfiles name the page was checked under, empty
+	// when the profile emulates no devices.
+	Device     string      `json:"device,omitempty"`
+	DurationMs int64       `json:"durationMs"`
+	Violations []Violation `json:"violations"`
+	// OverThreshold marks a page with more violations than the profile's
+	// MaxViolationsPerPage.
+	OverThreshold bool `json:"overThreshold,omitempty"`
+}
+
+// TimedOut reports whether navigation for this page timed out before settling.
+func (r ReportPageResult) TimedOut() bool {
+	return r.Settled != nil && !*r.Settled
 }
 
 type Violation struct {
-	DocumentURI       string `json:"documentURI"`
-	Referrer          string `json:"referrer"`
-	BlockedURI        string `json:"blockedURI"`
-	BlockedOrigin     string `json:"blockedOrigin"`
+	DocumentURI        string `json:"documentURI"`
+	Referrer           string `json:"referrer"`
+	BlockedURI         string `json:"blockedURI"`
+	BlockedOrigin      string `json:"blockedOrigin"`
 	EffectiveDirective string `json:"effectiveDirective"`
-	ViolatedDirective string `json:"violatedDirective"`
-	OriginalPolicy    string `json:"originalPolicy"`
-	Disposition       string `json:"disposition"`
-	StatusCode        *int   `json:"statusCode"`
-	SourceFile        string `json:"sourceFile"`
-	LineNumber        *int   `json:"lineNumber"`
-	ColumnNumber      *int   `json:"columnNumber"`
-	Sample            string `json:"sample"`
+	ViolatedDirective  string `json:"violatedDirective"`
+	OriginalPolicy     string `json:"originalPolicy"`
+	Disposition        string `json:"disposition"`
+	StatusCode         *int   `json:"statusCode"`
+	SourceFile         string `json:"sourceFile"`
+	LineNumber         *int   `json:"lineNumber"`
+	ColumnNumber       *int   `json:"columnNumber"`
+	Sample             string `json:"sample"`
+	// SampleTruncated is set when Sample was shortened before storage.
+	SampleTruncated bool `json:"sampleTruncated,omitempty"`
 }
 
 type GroupedViolation struct {
-	Key               string
+	Key                string
 	EffectiveDirective string
-	BlockedOrigin     string
-	Count             int
-	Pages             map[string][]Violation
+	BlockedOrigin      string
+	Count              int
+	// Enforce and ReportOnly split Count by disposition.
+	Enforce    int
+	ReportOnly int
+	Pages      map[string][]Violation
+	// PageDispositions records per page whether the group was "enforce",
+	// "report-only" or "both" there.
+	PageDispositions map[string]string
+	// Meta describes EffectiveDirective for display; see directiveMeta.
+	Meta DirectiveMeta
+	// Devices lists, sorted, the devices the group was seen on; empty when
+	// the run emulated no devices.
+	Devices []string
+	// Schemes counts the group's violations by blocked-origin scheme when
+	// schemes were normalized away from the key; nil otherwise.
+	Schemes map[string]int
+}
+
+// SchemeMix describes the group's scheme split, e.g. "2 http, 3 https".
+func (g GroupedViolation) SchemeMix() string {
+	names := make([]string, 0, len(g.Schemes))
+	for name := range g.Schemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%d %s", g.Schemes[name], name))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// DispositionMix describes the group's disposition split, e.g.
+// "3 enforce, 2 report-only".
+func (g GroupedViolation) DispositionMix() string {
+	var parts []string
+	if g.Enforce > 0 {
+		parts = append(parts, fmt.Sprintf("%d enforce", g.Enforce))
+	}
+	if g.ReportOnly > 0 {
+		parts = append(parts, fmt.Sprintf("%d report-only", g.ReportOnly))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// MixedEnforcement reports whether the group was enforced on some pages but
+// only reported on others, e.g. when pages send different policies.
+func (g GroupedViolation) MixedEnforcement() bool {
+	enforced, reportedOnly := false, false
+	for _, d := range g.PageDispositions {
+		switch d {
+		case "report-only":
+			reportedOnly = true
+		default:
+			enforced = true
+		}
+	}
+	return enforced && reportedOnly
 }
 
 type MergedGroup struct {
@@ -93,45 +261,243 @@ type MergedGroup struct {
 }
 
 type Server struct {
-	db   *sql.DB
-	tmpl *template.Template
+	db       *sql.DB
+	dbPath   string
+	tmpl     *template.Template
+	authUser string
+	authPass string
+	// apiToken, when set, is the bearer token /api/* requests must carry
+	// instead of basic auth credentials (CSP_API_TOKEN).
+	apiToken string
+	// profileOrder sorts the run form profile dropdown: "created" or "name".
+	profileOrder string
+	// instanceName and faviconURL brand every page so separate deployments
+	// (dev, prod) are easy to tell apart.
+	instanceName string
+	faviconURL   string
+
+	// browserProbe reports which browsers are installed. It runs at most once
+	// per process; see availableBrowsers.
+	browserProbe     func() (map[string]bool, error)
+	browserOnce      sync.Once
+	browserInstalled map[string]bool
+
+	// urlListClient fetches remote URL lists; nil means newGuardedClient.
+	urlListClient *http.Client
+	// maxSampleLen caps stored violation samples, in characters; 0 disables.
+	maxSampleLen int
+	// compressResults gzips results_json for new runs.
+	compressResults bool
+	// maxResultsBytes caps what results_json holds; see storedResults. 0
+	// means no cap.
+	maxResultsBytes int
+	// spillDir holds results too large for the database.
+	spillDir string
+	// webhook, when set, is notified after every stored run.
+	webhook *webhookNotifier
+	// webhookRetry holds the client and retry settings, without a URL, for
+	// profiles' NotifyWebhook targets.
+	webhookRetry webhookNotifier
+	// postRunHook, when set, is run after every stored run.
+	postRunHook *postRunHook
+	// maxProfiles caps profiles other than the default; 0 means no cap.
+	maxProfiles int
+	// maxConcurrency caps each browser's parallel pages
+	// (CSP_MAX_CONCURRENCY) so one profile cannot exhaust a shared host's
+	// memory; 0 means no cap. See capConcurrency.
+	maxConcurrency int
+	// workerPool is LocalChecker's Pool (CSP_WORKER_POOL).
+	workerPool int
+	// runsPerProfile is how many of each profile's newest runs are kept;
+	// 0 keeps them all. See rotateProfileRuns.
+	runsPerProfile int
+	// maxRenderBytes caps each rendered HTML page; 0 means no cap.
+	maxRenderBytes int64
+	// badgeMu guards badgeCache, regression badges memoized by run and
+	// baseline; stored runs never change, so entries stay valid.
+	badgeMu    sync.Mutex
+	badgeCache map[badgeKey]RegressionBadge
+	// originsMu guards originsCache, the /api/all-origins answer for the
+	// default window, reused for allOriginsCacheTTL.
+	originsMu    sync.Mutex
+	originsCache cachedOrigins
+	// activeRuns counts runs currently executing, reported as queue depth.
+	activeRuns atomic.Int64
+	// schedulerPaused stops the scheduler from starting runs; due schedules
+	// stay due and run once it is resumed.
+	schedulerPaused atomic.Bool
+	// checker runs the browsers for a run; nil means LocalChecker.
+	checker Checker
+	// maxURLsBytes caps the "urls" field of submitted forms; 0 means no cap.
+	maxURLsBytes int
+	// jobsMu guards rerunJobs, the history reruns started since startup,
+	// by job ID. Finished jobs are dropped after rerunJobTTL.
+	jobsMu    sync.Mutex
+	rerunJobs map[int64]*rerunJob
+	lastJobID int64
+	// audit records mutations in audit_log (CSP_AUDIT_LOG).
+	audit bool
+	// readOnly rejects the requests in readOnlyMutations (CSP_READONLY), for
+	// public demos.
+	readOnly bool
+	// dbMu is held shared by regular writes and exclusively by maintenance
+	// such as VACUUM, so the two never overlap.
+	dbMu sync.RWMutex
 }
 
 type CSPConfig struct {
-	WaitUntil      string `json:"waitUntil"`
-	NavTimeoutMs   int    `json:"navTimeoutMs"`
-	SettleWaitMs   int    `json:"settleWaitMs"`
-	Concurrency    int    `json:"concurrency"`
-	BetweenURLMs   int    `json:"betweenUrlMs"`
-	UserAgent      string `json:"userAgent"`
-	AcceptLanguage string `json:"acceptLanguage"`
-	Browser        string `json:"browser"`
+	WaitUntil    string `json:"waitUntil"`
+	NavTimeoutMs int    `json:"navTimeoutMs"`
+	SettleWaitMs int    `json:"settleWaitMs"`
+	// TimeoutMultiplier scales NavTimeoutMs and SettleWaitMs when the
+	// profile runs, for environments slower than the one they were tuned
+	// on. Zero means 1; otherwise it must be within 0.5-10.
+	TimeoutMultiplier float64 `json:"timeoutMultiplier,omitempty"`
+	Concurrency       int     `json:"concurrency"`
+	BetweenURLMs      int     `json:"betweenUrlMs"`
+	UserAgent         string  `json:"userAgent"`
+	AcceptLanguage    string  `json:"acceptLanguage"`
+	Browser           string  `json:"browser"`
+	BasicAuthUser     string  `json:"basicAuthUser,omitempty"`
+	BasicAuthPass     string  `json:"basicAuthPass,omitempty"`
+	// FailOnReportOnly makes report-only violations fail a run, not just
+	// enforced ones.
+	FailOnReportOnly bool `json:"failOnReportOnly,omitempty"`
+	// MaxViolationsPerPage fails a run as soon as any page reports more
+	// violations than this; browsers not yet started are skipped. Zero
+	// means no limit.
+	MaxViolationsPerPage int `json:"maxViolationsPerPage,omitempty"`
+	// DisableJS loads pages with JavaScript off, to see which violations
+	// the static markup alone triggers.
+	DisableJS bool `json:"disableJs,omitempty"`
+	// IgnoreTLSErrors accepts invalid certificates, for staging hosts with
+	// self-signed ones. Off unless a profile turns it on explicitly.
+	IgnoreTLSErrors bool `json:"ignoreTlsErrors,omitempty"`
+	// PreActions run once before the checks, e.g. to log in; the resulting
+	// cookies and storage are reused for every page.
+	PreActions []PageAction `json:"preActions,omitempty"`
+	// ExtraArgs are appended to the node script's argv, for forks of
+	// csp-check.mjs that take their own flags.
+	ExtraArgs []string `json:"extraArgs,omitempty"`
+	// CaptureHeaders stores each page's response headers with the results.
+	CaptureHeaders bool `json:"captureHeaders,omitempty"`
+	// ExcludePatterns hide matching pages from grouped issues; they are still
+	// checked and kept in the raw results. See matchesExclude.
+	ExcludePatterns []string `json:"excludePatterns,omitempty"`
+	// DefaultURLs prefills the run form when this profile is selected.
+	DefaultURLs string `json:"defaultUrls,omitempty"`
+	// Devices checks every URL once per listed deviceProfiles entry, in
+	// every browser. Empty means one unemulated pass.
+	Devices []string `json:"devices,omitempty"`
+	// DeFlake runs the whole check twice and keeps only violations seen in
+	// both passes; see intersectReports. Runs take twice as long.
+	DeFlake bool `json:"deFlake,omitempty"`
+	// NotifyWebhook receives the webhook payload after a run of this
+	// profile with more than NotifyThreshold violations, in addition to
+	// CSP_WEBHOOK_URL. It may hold a token, so redacted shortens it.
+	NotifyWebhook   string `json:"notifyWebhook,omitempty"`
+	NotifyThreshold int    `json:"notifyThreshold,omitempty"`
+}
+
+// PageAction is one pre-navigation step. Only a fixed set of actions is
+// supported so profiles cannot run arbitrary scripts.
+type PageAction struct {
+	Action   string `json:"action"`
+	URL      string `json:"url,omitempty"`
+	Selector string `json:"selector,omitempty"`
+	Value    string `json:"value,omitempty"`
+}
+
+// maxPreActions bounds the pre-navigation action list.
+const maxPreActions = 20
+
+// maxExtraArgs bounds ExtraArgs; each argument is also limited in length.
+const (
+	maxExtraArgs   = 16
+	maxExtraArgLen = 256
+)
+
+// redacted returns a copy of cfg that is safe to display or log.
+func (cfg CSPConfig) redacted() CSPConfig {
+	cfg.BasicAuthPass = ""
+	cfg.NotifyWebhook = redactURL(cfg.NotifyWebhook)
+	return cfg
+}
+
+// redactURL keeps only the scheme and host of raw, which is enough to tell
+// webhooks apart without showing tokens in their path or query.
+func redactURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "(hidden)"
+	}
+	return u.Scheme + "://" + u.Host + "/…"
 }
 
 type BrowserReport struct {
-	Name   string
-	Report Report
-	Groups []GroupedViolation
-	Warns  []GroupedViolation
+	Name    string
+	Report  Report
+	Groups  []GroupedViolation
+	Warns   []GroupedViolation
+	Settled bool
+	// Analyzed holds the results left after the run's exclude patterns, when
+	// it had any; Report keeps every page.
+	Analyzed []ReportPageResult
+	Excluded int
+}
+
+// analyzed returns the page results that grouped analysis should use.
+func (b BrowserReport) analyzed() []ReportPageResult {
+	if b.Analyzed != nil {
+		return b.Analyzed
+	}
+	return b.Report.Results
 }
 
 type ProfileView struct {
 	ID        int64
 	Name      string
 	CreatedAt string
+	Archived  bool
 	Config    CSPConfig
+	// DuplicateOf names the other profiles with the same settings.
+	DuplicateOf []string
+	// ConfigError is why the stored config does not parse or validate;
+	// runs under the profile are refused until it is fixed.
+	ConfigError string
 }
 
 type MultiReport struct {
-	GeneratedAt string             `json:"generatedAt"`
-	Config      map[string]any     `json:"config"`
-	Browsers    map[string]Report  `json:"browsers"`
+	GeneratedAt string            `json:"generatedAt"`
+	Config      map[string]any    `json:"config"`
+	Browsers    map[string]Report `json:"browsers"`
+	// Flaky holds, for de-flaked runs, the violations only one of the two
+	// passes reported. They are not part of Browsers.
+	Flaky []Violation `json:"flaky,omitempty"`
+	// ThresholdExceeded is set when a page went over MaxViolationsPerPage,
+	// which fails the run whatever the dispositions.
+	ThresholdExceeded bool `json:"thresholdExceeded,omitempty"`
 }
 
 type RunSummary struct {
-	Pages      int                    `json:"pages"`
-	Violations int                    `json:"violations"`
+	Pages      int                     `json:"pages"`
+	Violations int                     `json:"violations"`
 	Browsers   map[string]ReportTotals `json:"browsers"`
+	// PagesWithoutCSP counts pages that set no policy at all; their zero
+	// violations are a coverage gap, not a pass.
+	PagesWithoutCSP int `json:"pagesWithoutCsp,omitempty"`
+	// BlockedOrigins counts the distinct origins blocked across all pages
+	// and browsers.
+	BlockedOrigins int `json:"blockedOrigins,omitempty"`
+	// Score is the 0-100 health score from computeScore. Nil for runs
+	// stored before scoring existed.
+	Score *int `json:"score,omitempty"`
+	// Error is set on runs recorded by createFailedRun: the check itself
+	// failed and there are no results.
+	Error string `json:"error,omitempty"`
 }
 
 var version = "dev"
@@ -142,7 +508,7 @@ func main() {
 	addr := envDefault("CSP_WEB_ADDR", "127.0.0.1:8080")
 	dbPath := envDefault("CSP_WEB_DB", "data.db")
 
-	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=foreign_keys(1)", dbPath))
+	db, err := openDB(dbPath)
 	if err != nil {
 		log.Fatalf("db open: %v", err)
 	}
@@ -151,53 +517,400 @@ func main() {
 	if err := initDB(db); err != nil {
 		log.Fatalf("db init: %v", err)
 	}
+	if path := os.Getenv("CSP_DEFAULTS_FILE"); path != "" {
+		loadConfigDefaults(path)
+	}
 	if err := ensureDefaultProfile(db); err != nil {
 		log.Fatalf("default profile: %v", err)
 	}
 
-	tmpl, err := template.New("").Funcs(template.FuncMap{
-		"jsonPages":      jsonPages,
-		"jsonViolations": jsonViolations,
-		"groupPolicy":    groupPolicy,
-		"groupDirective": groupDirective,
-		"jsonPretty":     jsonPretty,
-		"toJSON":         toJSON,
-		"groupSource":     groupSource,
-		"groupHint":       groupHint,
-		"formatDirective": formatDirective,
-		"groupSourceLink": groupSourceLink,
-		"groupSnippetLink": groupSnippetLink,
-		"groupSourceNote": groupSourceNote,
-		"groupSourceURL":  groupSourceURL,
-		"groupSourceLine": groupSourceLine,
-		"queryEscape":     queryEscape,
-		"joinList":        joinList,
-	}).ParseFS(templateFS, "web/templates/*.html")
+	tmpl, err := parseTemplates()
 	if err != nil {
 		log.Fatalf("templates: %v", err)
 	}
+	maxConcurrency := envInt("CSP_MAX_CONCURRENCY", defaultMaxConcurrency)
+	if maxConcurrency < 0 {
+		log.Fatalf("CSP_MAX_CONCURRENCY must not be negative, got %d", maxConcurrency)
+	}
+
+	s := &Server{
+		db:       db,
+		dbPath:   dbPath,
+		tmpl:     tmpl,
+		authUser: envDefault("CSP_WEB_USER", ""),
+		authPass: envDefault("CSP_WEB_PASSWORD", ""),
+		apiToken: envDefault("CSP_API_TOKEN", ""),
+
+		profileOrder: envDefault("CSP_PROFILE_ORDER", "created"),
+		instanceName: envDefault("CSP_INSTANCE_NAME", ""),
+		faviconURL:   envDefault("CSP_FAVICON_URL", ""),
+		browserProbe: probeBrowsers,
+		maxSampleLen: envInt("CSP_MAX_SAMPLE_LEN", 256),
+
+		compressResults: envDefault("CSP_COMPRESS_RESULTS", "0") == "1",
+		maxResultsBytes: envInt("CSP_MAX_RESULTS_BYTES", 16<<20),
+		spillDir:        envDefault("CSP_RESULTS_SPILL_DIR", filepath.Join(filepath.Dir(dbPath), "results-spill")),
+		maxProfiles:     envInt("CSP_MAX_PROFILES", 0),
+		maxConcurrency:  maxConcurrency,
+		workerPool:      envInt("CSP_WORKER_POOL", 1),
+		runsPerProfile:  envInt("CSP_RUNS_PER_PROFILE", 0),
+		maxRenderBytes:  int64(envInt("CSP_MAX_RENDER_BYTES", 64<<20)),
+		maxURLsBytes:    envInt("CSP_MAX_URLS_BYTES", maxURLListBytes),
+		audit:           envDefault("CSP_AUDIT_LOG", "0") == "1",
+		readOnly:        envDefault("CSP_READONLY", "0") == "1",
+	}
+	if s.readOnly {
+		log.Printf("read-only mode: runs, profiles, URL lists and schedules cannot be changed")
+	}
+	if checkURL := envDefault("CSP_CHECK_URL", ""); checkURL != "" {
+		s.checker = &HTTPChecker{URL: checkURL, Client: &http.Client{}}
+		log.Printf("checks run on %s", checkURL)
+	} else if err := validateNodeCommand(envDefault("CSP_NODE_BIN", "node"), envDefault("CSP_SCRIPT_PATH", "./csp-check.mjs")); err != nil {
+		log.Fatalf("local checks: %v", err)
+	}
+	s.webhookRetry = webhookNotifier{
+		client:    &http.Client{Timeout: 10 * time.Second},
+		attempts:  envInt("CSP_WEBHOOK_ATTEMPTS", 4),
+		baseDelay: time.Duration(envInt("CSP_WEBHOOK_BASE_DELAY_MS", 1000)) * time.Millisecond,
+		deadline:  time.Duration(envInt("CSP_WEBHOOK_DEADLINE_MS", 60000)) * time.Millisecond,
+	}
+	if hook := envDefault("CSP_WEBHOOK_URL", ""); hook != "" {
+		n := s.webhookRetry
+		n.url = hook
+		s.webhook = &n
+	}
+	if command := envDefault("CSP_POST_RUN_CMD", ""); command != "" {
+		s.postRunHook = &postRunHook{
+			command: command,
+			timeout: time.Duration(envInt("CSP_POST_RUN_TIMEOUT_MS", 30000)) * time.Millisecond,
+		}
+	}
+	// Warm the browser availability cache without delaying startup.
+	go s.availableBrowsers()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sched := newScheduler(s, time.Now)
+	sched.Start(ctx)
+
+	srv := &http.Server{Addr: addr, Handler: s.routes()}
+	go func() {
+		<-ctx.Done()
+		log.Printf("shutting down")
+		sched.Stop()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("csp-web %s listening on %s", version, addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("server: %v", err)
+	}
+}
+
+func openDB(path string) (*sql.DB, error) {
+	return sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=foreign_keys(1)", path))
+}
+
+// parseTemplates parses the embedded templates, then any *.html files in
+// CSP_TEMPLATE_DIR on top of them. An override replaces the embedded file of
+// the same name (and the blocks it defines); anything not overridden keeps
+// the built-in version.
+func parseTemplates() (*template.Template, error) {
+	tmpl, err := parseEmbeddedTemplates()
+	if err != nil {
+		return nil, err
+	}
+	dir := envDefault("CSP_TEMPLATE_DIR", "")
+	if dir == "" {
+		return tmpl, nil
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("CSP_TEMPLATE_DIR %q is not a directory", dir)
+	}
+	diskFS := os.DirFS(dir)
+	overrides, err := fs.Glob(diskFS, "*.html")
+	if err != nil {
+		return nil, err
+	}
+	if len(overrides) == 0 {
+		log.Printf("CSP_TEMPLATE_DIR %s has no .html files, using built-in templates", dir)
+		return tmpl, nil
+	}
+	log.Printf("template overrides from %s: %s", dir, strings.Join(overrides, ", "))
+	return tmpl.ParseFS(diskFS, overrides...)
+}
 
-	s := &Server{db: db, tmpl: tmpl}
+func parseEmbeddedTemplates() (*template.Template, error) {
+	return template.New("").Funcs(template.FuncMap{
+		"jsonPages":           jsonPages,
+		"jsonViolations":      jsonViolations,
+		"jsonPagesWithoutCSP": jsonPagesWithoutCSP,
+		"jsonBlockedOrigins":  jsonBlockedOrigins,
+		"jsonScore":           jsonScore,
+		"runError":            runError,
+		"groupPolicy":         groupPolicy,
+		"groupDirective":      groupDirective,
+		"jsonPretty":          jsonPretty,
+		"toJSON":              toJSON,
+		"groupSource":         groupSource,
+		"groupHint":           groupHint,
+		"formatDirective":     formatDirective,
+		"groupSourceLink":     groupSourceLink,
+		"groupSnippetLink":    groupSnippetLink,
+		"groupSourceNote":     groupSourceNote,
+		"groupSourceURL":      groupSourceURL,
+		"groupSourceLine":     groupSourceLine,
+		"queryEscape":         queryEscape,
+		"joinList":            joinList,
+		"mergedPolicyForPage": mergedPolicyForPage,
+		"pageWeaknesses":      pageWeaknesses,
+		"durationOutliers":    durationOutliers,
+		"containsString":      containsString,
+		"violationSignature":  violationSignature,
+		"sortedHeaders":       sortedHeaders,
+		"dataURLs":            dataURLs,
+	}).ParseFS(templateFS, "web/templates/*.html")
+}
 
+func (s *Server) routes() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", s.handleIndex)
 	mux.HandleFunc("/runs", s.handleRuns)
 	mux.HandleFunc("/runs/", s.handleRunDetail)
 	mux.HandleFunc("/runs/rerun", s.handleRunRerun)
+	mux.HandleFunc("/runs/recheck-timeouts", s.handleRecheckTimeouts)
+	mux.HandleFunc("/runs/from-url", s.handleRunFromURL)
+	mux.HandleFunc("/runs/all-profiles", s.handleRunAllProfiles)
+	mux.HandleFunc("/runs/csv", s.handleRunCSV)
 	mux.HandleFunc("/runs/export", s.handleRunExport)
+	mux.HandleFunc("/runs/diff", s.handleRunDiff)
 	mux.HandleFunc("/runs/copy", s.handleRunCopy)
+	mux.HandleFunc("/runs/label", s.handleRunLabel)
+	mux.HandleFunc("/runs/pin", s.handleRunPin)
+	mux.HandleFunc("/runs/mark-fp", s.handleMarkFalsePositive)
+	mux.HandleFunc("/runs/baseline", s.handleSetBaseline)
 	mux.HandleFunc("/runs/snippet", s.handleRunSnippet)
+	mux.HandleFunc("/runs/logs/stream", s.handleRunLogStream)
+	mux.HandleFunc("/schedules", s.handleSchedules)
+	mux.HandleFunc("/schedules/update", s.handleScheduleUpdate)
+	mux.HandleFunc("/schedules/delete", s.handleScheduleDelete)
+	mux.HandleFunc("/url-lists", s.handleURLLists)
+	mux.HandleFunc("/url-lists/update", s.handleURLListUpdate)
+	mux.HandleFunc("/url-lists/delete", s.handleURLListDelete)
 	mux.HandleFunc("/profiles", s.handleProfiles)
 	mux.HandleFunc("/profiles/update", s.handleProfileUpdate)
+	mux.HandleFunc("/profiles/run-last", s.handleProfileRunLast)
+	mux.HandleFunc("/profiles/rerun-history", s.handleProfileRerunHistory)
+	mux.HandleFunc("/profiles/delete", s.handleProfileDelete)
+	mux.HandleFunc("/profiles/archive", s.handleProfileArchive)
+	mux.HandleFunc("/profiles/", s.handleProfileSubpage)
+	mux.HandleFunc("/rerun-jobs/", s.handleRerunJob)
+	mux.HandleFunc("/rerun-jobs/cancel", s.handleRerunJobCancel)
 	mux.HandleFunc("/docs", s.handleDocs)
+	mux.HandleFunc("/admin/vacuum", s.handleAdminVacuum)
+	mux.HandleFunc("/admin/scheduler/pause", s.handleSchedulerPause)
+	mux.HandleFunc("/admin/scheduler/resume", s.handleSchedulerPause)
+	mux.HandleFunc("/admin/audit", s.handleAdminAudit)
+	mux.HandleFunc("/admin/export-all.zip", s.handleAdminExportAll)
+	mux.HandleFunc("/admin/import-all.zip", s.handleAdminImportAll)
+	mux.HandleFunc("/admin/import-run.gob", s.handleAdminImportGob)
+	mux.HandleFunc("/api/browsers", s.handleAPIBrowsers)
+	mux.HandleFunc("/api/runs", s.handleAPIRuns)
+	mux.HandleFunc("/api/runs/", s.handleAPIRun)
+	mux.HandleFunc("/api/status", s.handleAPIStatus)
+	mux.HandleFunc("/api/url-history", s.handleAPIURLHistory)
+	mux.HandleFunc("/api/latest-for-url", s.handleAPILatestForURL)
+	mux.HandleFunc("/api/all-origins", s.handleAPIAllOrigins)
+	mux.HandleFunc("/api/quick-check", s.handleAPIQuickCheck)
+	mux.HandleFunc("/api/openapi.json", s.handleOpenAPI)
 	if staticFS, err := fs.Sub(embeddedFS, "web/static"); err == nil {
 		mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(staticFS))))
 	}
+	return s.withAuth(s.withReadOnly(mux))
+}
 
-	log.Printf("csp-web %s listening on %s", version, addr)
-	if err := http.ListenAndServe(addr, mux); err != nil {
-		log.Fatalf("server: %v", err)
+// AuditEntry is one row of the audit log.
+type AuditEntry struct {
+	ID       int64  `json:"id"`
+	At       string `json:"at"`
+	Action   string `json:"action"`
+	TargetID string `json:"targetId"`
+	User     string `json:"user"`
+}
+
+// auditLog appends a mutation to audit_log when auditing is on. A failed
+// write is logged; it never fails the action being recorded.
+func (s *Server) auditLog(ctx context.Context, action, targetID, user string) {
+	if !s.audit {
+		return
+	}
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	err := withRetry(ctx, func() error {
+		_, err := s.db.ExecContext(context.WithoutCancel(ctx),
+			`INSERT INTO audit_log (at, action, target_id, user) VALUES (?, ?, ?, ?)`,
+			time.Now().UTC().Format(time.RFC3339), action, targetID, user)
+		return err
+	})
+	if err != nil {
+		log.Printf("audit %s %s: %v", action, targetID, err)
+	}
+}
+
+// auditUser is who made the request, as far as authentication tells:
+// the basic auth user, "api-token" for a bearer token, or "" when the
+// service runs without auth.
+func (s *Server) auditUser(r *http.Request) string {
+	if s.apiToken != "" && strings.HasPrefix(r.URL.Path, "/api/") {
+		return "api-token"
+	}
+	if s.authUser != "" && s.authPass != "" {
+		if user, _, ok := r.BasicAuth(); ok {
+			return user
+		}
+	}
+	return ""
+}
+
+// listAudit returns the newest limit audit entries, newest first.
+func (s *Server) listAudit(ctx context.Context, limit int) ([]AuditEntry, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, at, action, target_id, user FROM audit_log ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.At, &e.Action, &e.TargetID, &e.User); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// handleAdminAudit shows the audit log, newest first, as a page or as JSON
+// when the Accept header prefers it.
+func (s *Server) handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := 200
+	if v := parseIntForm(r.URL.Query().Get("limit")); v > 0 {
+		limit = v
+	}
+	if limit > 1000 {
+		limit = 1000
+	}
+	entries, err := s.listAudit(r.Context(), limit)
+	if err != nil {
+		http.Error(w, "audit log load failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Vary", "Accept")
+	if prefersJSON(r.Header.Get("Accept")) {
+		writeJSON(w, http.StatusOK, entries)
+		return
 	}
+	s.render(w, "audit.html", map[string]any{
+		"Entries": entries,
+		"Enabled": s.audit,
+		"Limit":   limit,
+	})
+}
+
+// readOnlyMutations lists, by path, the methods that change stored data or
+// start a check. Read-only mode rejects them; everything else, including
+// exports and the read API, stays available.
+var readOnlyMutations = map[string][]string{
+	"/runs":                   {http.MethodPost},
+	"/runs/rerun":             {http.MethodPost},
+	"/runs/recheck-timeouts":  {http.MethodPost},
+	"/runs/from-url":          {http.MethodPost},
+	"/runs/all-profiles":      {http.MethodPost},
+	"/runs/csv":               {http.MethodPost},
+	"/runs/copy":              {http.MethodPost},
+	"/runs/label":             {http.MethodPost},
+	"/runs/pin":               {http.MethodPost},
+	"/runs/mark-fp":           {http.MethodPost},
+	"/runs/baseline":          {http.MethodPost},
+	"/schedules":              {http.MethodPost},
+	"/schedules/update":       {http.MethodPost},
+	"/schedules/delete":       {http.MethodPost},
+	"/url-lists":              {http.MethodPost},
+	"/url-lists/update":       {http.MethodPost},
+	"/url-lists/delete":       {http.MethodPost},
+	"/profiles":               {http.MethodPost},
+	"/profiles/update":        {http.MethodPost},
+	"/profiles/run-last":      {http.MethodPost},
+	"/profiles/rerun-history": {http.MethodPost},
+	"/rerun-jobs/cancel":      {http.MethodPost},
+	"/profiles/delete":        {http.MethodPost},
+	"/profiles/archive":       {http.MethodPost},
+	"/admin/vacuum":           {http.MethodPost},
+	"/admin/scheduler/pause":  {http.MethodPost},
+	"/admin/scheduler/resume": {http.MethodPost},
+	"/admin/import-all.zip":   {http.MethodPost},
+	"/admin/import-run.gob":   {http.MethodPost},
+	// Quick checks store nothing but still launch browsers at any URL.
+	"/api/quick-check": {http.MethodGet, http.MethodPost},
+}
+
+// withReadOnly answers 403 to the requests in readOnlyMutations when the
+// server runs in read-only mode.
+func (s *Server) withReadOnly(next http.Handler) http.Handler {
+	if !s.readOnly {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if containsString(readOnlyMutations[r.URL.Path], r.Method) {
+			http.Error(w, "read-only mode", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withAuth requires HTTP basic auth on every request when CSP_WEB_USER and
+// CSP_WEB_PASSWORD are both set. Without them the service stays open, which
+// matches the default loopback-only listener. When CSP_API_TOKEN is set,
+// /api/* requests need that bearer token instead.
+func (s *Server) withAuth(next http.Handler) http.Handler {
+	basic := s.authUser != "" && s.authPass != ""
+	if !basic && s.apiToken == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.apiToken != "" && strings.HasPrefix(r.URL.Path, "/api/") {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(s.apiToken)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="csp-web"`)
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or invalid API token"})
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !basic {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user, pass, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(user), []byte(s.authUser)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(s.authPass)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="csp-web"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 func initDB(db *sql.DB) error {
@@ -221,13 +934,185 @@ func initDB(db *sql.DB) error {
 		);`,
 		`CREATE INDEX IF NOT EXISTS idx_runs_created_at ON runs(created_at);`,
 		`CREATE INDEX IF NOT EXISTS idx_runs_profile_id ON runs(profile_id);`,
+		`CREATE TABLE IF NOT EXISTS profile_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			profile_id INTEGER NOT NULL,
+			config_json TEXT NOT NULL,
+			changed_at TEXT NOT NULL,
+			FOREIGN KEY(profile_id) REFERENCES profiles(id) ON DELETE CASCADE
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_profile_history_profile_id ON profile_history(profile_id);`,
+		`CREATE TABLE IF NOT EXISTS schedules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			profile_id INTEGER,
+			urls_text TEXT NOT NULL,
+			interval_minutes INTEGER NOT NULL,
+			next_run_at TEXT NOT NULL,
+			last_run_id INTEGER,
+			created_at TEXT NOT NULL,
+			FOREIGN KEY(profile_id) REFERENCES profiles(id) ON DELETE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS false_positives (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			profile_id INTEGER,
+			signature TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			created_at TEXT NOT NULL,
+			FOREIGN KEY(profile_id) REFERENCES profiles(id) ON DELETE CASCADE
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_false_positives_profile_id ON false_positives(profile_id);`,
+		`CREATE TABLE IF NOT EXISTS baselines (
+			profile_id INTEGER PRIMARY KEY,
+			run_id INTEGER NOT NULL,
+			set_at TEXT NOT NULL,
+			FOREIGN KEY(profile_id) REFERENCES profiles(id) ON DELETE CASCADE,
+			FOREIGN KEY(run_id) REFERENCES runs(id) ON DELETE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS url_lists (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			urls_text TEXT NOT NULL,
+			created_at TEXT NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS violation_tracking (
+			profile_id INTEGER NOT NULL,
+			group_key TEXT NOT NULL,
+			first_seen TEXT NOT NULL,
+			last_seen TEXT NOT NULL,
+			last_run_id INTEGER NOT NULL,
+			resolved_at TEXT,
+			PRIMARY KEY (profile_id, group_key),
+			FOREIGN KEY(profile_id) REFERENCES profiles(id) ON DELETE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			at TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target_id TEXT NOT NULL,
+			user TEXT NOT NULL
+		);`,
+		// The audit log is append-only: rows are never changed or removed.
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+		BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END;`,
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+		BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END;`,
 	}
 	for _, stmt := range stmts {
 		if _, err := db.Exec(stmt); err != nil {
 			return err
 		}
 	}
-	return nil
+	if err := addColumnIfMissing(db, "runs", "label", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "runs", "results_path", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "runs", "pinned", `INTEGER NOT NULL DEFAULT 0`); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "profiles", "archived", `INTEGER NOT NULL DEFAULT 0`); err != nil {
+		return err
+	}
+	// pages lists, one "browser url" per line, where an open group was
+	// last seen; see trackViolations.
+	if err := addColumnIfMissing(db, "violation_tracking", "pages", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+	return migrateSyntheticOriginKeys(db)
+}
+
+// migrateSyntheticOriginKeys renames violation_tracking keys written before
+// data: and blob: violations were grouped under syntheticOrigins, such as
+// "img-src -> data", so the next run does not show them resolved and then
+// new again. Rows that end up on the same key are merged. Keys whose origin
+// was reported empty or as "null" cannot be told apart from other
+// violations and are left alone.
+func migrateSyntheticOriginKeys(db *sql.DB) error {
+	type track struct {
+		profileID, lastRunID            int64
+		key, firstSeen, lastSeen, label string
+		resolvedAt                      sql.NullString
+	}
+	rows, err := db.Query(
+		`SELECT profile_id, group_key, first_seen, last_seen, last_run_id, resolved_at
+		 FROM violation_tracking WHERE group_key LIKE '% -> data%' OR group_key LIKE '% -> blob%'`)
+	if err != nil {
+		return err
+	}
+	var tracks []track
+	for rows.Next() {
+		var t track
+		if err := rows.Scan(&t.profileID, &t.key, &t.firstSeen, &t.lastSeen, &t.lastRunID, &t.resolvedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		directive, origin, _ := strings.Cut(t.key, " -> ")
+		scheme, _, _ := strings.Cut(strings.ToLower(origin), ":")
+		if label, ok := syntheticOrigins[scheme]; ok {
+			t.label = directive + " -> " + label
+			tracks = append(tracks, t)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(tracks) == 0 {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, t := range tracks {
+		if _, err := tx.Exec(
+			`INSERT INTO violation_tracking (profile_id, group_key, first_seen, last_seen, last_run_id, resolved_at)
+			 VALUES (?, ?, ?, ?, ?, ?)
+			 ON CONFLICT(profile_id, group_key) DO UPDATE SET
+			   first_seen = MIN(first_seen, excluded.first_seen),
+			   last_seen = MAX(last_seen, excluded.last_seen),
+			   last_run_id = MAX(last_run_id, excluded.last_run_id),
+			   resolved_at = CASE WHEN resolved_at IS NULL OR excluded.resolved_at IS NULL THEN NULL
+			                      ELSE MAX(resolved_at, excluded.resolved_at) END`,
+			t.profileID, t.label, t.firstSeen, t.lastSeen, t.lastRunID, t.resolvedAt); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM violation_tracking WHERE profile_id = ? AND group_key = ?`, t.profileID, t.key); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// addColumnIfMissing adds a column to an existing table, for databases
+// created before the column existed.
+func addColumnIfMissing(db *sql.DB, table, column, decl string) error {
+	rows, err := db.Query(`PRAGMA table_info(` + table + `)`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid     int
+			name    string
+			typ     string
+			notNull int
+			dflt    sql.NullString
+			pk      int
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + decl)
+	return err
 }
 
 func ensureDefaultProfile(db *sql.DB) error {
@@ -258,223 +1143,258 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	profiles, err := s.listProfiles(r.Context())
+	profiles, err := s.indexProfiles(r.Context())
 	if err != nil {
 		http.Error(w, "profiles load failed", http.StatusInternalServerError)
 		return
 	}
+	lists, err := s.listURLLists(r.Context())
+	if err != nil {
+		http.Error(w, "url lists load failed", http.StatusInternalServerError)
+		return
+	}
+	defaults := profileDefaultURLs(profiles)
+	selected := parseProfileID(r.URL.Query().Get("profile_id"))
+	if !selected.Valid && len(profiles) > 0 {
+		selected = sql.NullInt64{Int64: profiles[0].ID, Valid: true}
+	}
 	prefill := strings.TrimSpace(r.URL.Query().Get("urls"))
+	if prefill == "" {
+		prefill = defaults[selected.Int64]
+	}
 	s.render(w, "index.html", map[string]any{
-		"Profiles":    profiles,
-		"PrefillURLs": prefill,
+		"Profiles":          profiles,
+		"URLLists":          lists,
+		"PrefillURLs":       prefill,
+		"SelectedProfileID": selected.Int64,
+		"DefaultURLs":       defaults,
+		"MaxURLsBytes":      s.maxURLsBytes,
 	})
 }
 
-func (s *Server) handleProfiles(w http.ResponseWriter, r *http.Request) {
+// profileDefaultURLs maps profile IDs to their configured default URLs,
+// skipping profiles without any.
+func profileDefaultURLs(profiles []Profile) map[int64]string {
+	defaults := make(map[int64]string)
+	for _, p := range profiles {
+		var cfg CSPConfig
+		if err := json.Unmarshal([]byte(p.ConfigJSON), &cfg); err != nil {
+			continue
+		}
+		if cfg.DefaultURLs != "" {
+			defaults[p.ID] = cfg.DefaultURLs
+		}
+	}
+	return defaults
+}
+
+func (s *Server) handleSchedules(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		profiles, err := s.listProfiles(r.Context())
+		schedules, err := s.listSchedules(r.Context())
 		if err != nil {
-			http.Error(w, "profiles load failed", http.StatusInternalServerError)
+			http.Error(w, "schedules load failed", http.StatusInternalServerError)
 			return
 		}
-		views := make([]ProfileView, 0, len(profiles))
-		for _, p := range profiles {
-			cfg, err := parseConfig(p.ConfigJSON)
-			if err != nil {
-				cfg = defaultConfig()
-			}
-			views = append(views, ProfileView{
-				ID:        p.ID,
-				Name:      p.Name,
-				CreatedAt: p.CreatedAt,
-				Config:    cfg,
-			})
-		}
-		s.render(w, "profiles.html", map[string]any{
-			"Profiles": views,
-			"Defaults": defaultConfig(),
+		profiles, _ := s.indexProfiles(r.Context())
+		s.render(w, "schedules.html", map[string]any{
+			"Schedules": schedules,
+			"Profiles":  profiles,
+			"Paused":    s.schedulerPaused.Load(),
 		})
 	case http.MethodPost:
-		if err := r.ParseForm(); err != nil {
-			http.Error(w, "bad form", http.StatusBadRequest)
+		if !s.parseURLsForm(w, r) {
 			return
 		}
-		name := strings.TrimSpace(r.FormValue("name"))
-		if name == "" {
-			http.Error(w, "name required", http.StatusBadRequest)
+		sc, err := scheduleFromForm(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		cfg := defaultConfig()
-		if v := strings.TrimSpace(r.FormValue("wait_until")); v != "" {
-			cfg.WaitUntil = v
-		}
-		if v := parseIntForm(r.FormValue("nav_timeout_ms")); v > 0 {
-			cfg.NavTimeoutMs = v
-		}
-		if v := parseIntForm(r.FormValue("settle_wait_ms")); v >= 0 {
-			cfg.SettleWaitMs = v
-		}
-		if v := parseIntForm(r.FormValue("concurrency")); v > 0 {
-			cfg.Concurrency = v
-		}
-		if v := parseIntForm(r.FormValue("between_url_ms")); v >= 0 {
-			cfg.BetweenURLMs = v
-		}
-		if v := strings.TrimSpace(r.FormValue("user_agent")); v != "" {
-			cfg.UserAgent = v
-		}
-		if v := strings.TrimSpace(r.FormValue("accept_language")); v != "" {
-			cfg.AcceptLanguage = v
-		}
-		cfgJSON, _ := json.Marshal(cfg)
-		if err := s.createProfile(r.Context(), name, string(cfgJSON)); err != nil {
-			http.Error(w, "create failed: "+err.Error(), http.StatusBadRequest)
+		id, err := s.createSchedule(r.Context(), sc.ProfileID, sc.URLsText, sc.IntervalMinutes, time.Now())
+		if err != nil {
+			log.Printf("create schedule: %v", err)
+			http.Error(w, "create failed", http.StatusInternalServerError)
 			return
 		}
-		http.Redirect(w, r, "/profiles", http.StatusSeeOther)
+		s.auditLog(r.Context(), "schedule.created", strconv.FormatInt(id, 10), s.auditUser(r))
+		http.Redirect(w, r, "/schedules", http.StatusSeeOther)
 	default:
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-func (s *Server) handleProfileUpdate(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleScheduleUpdate(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if err := r.ParseForm(); err != nil {
-		http.Error(w, "bad form", http.StatusBadRequest)
+	if !s.parseURLsForm(w, r) {
 		return
 	}
-	idStr := strings.TrimSpace(r.FormValue("id"))
-	if idStr == "" {
-		http.Error(w, "id required", http.StatusBadRequest)
+	id, err := strconv.ParseInt(strings.TrimSpace(r.FormValue("id")), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
 		return
 	}
-	id, err := strconv.ParseInt(idStr, 10, 64)
+	sc, err := scheduleFromForm(r)
 	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	name := strings.TrimSpace(r.FormValue("name"))
-	if name == "" {
-		http.Error(w, "name required", http.StatusBadRequest)
+	if err := s.updateSchedule(r.Context(), id, sc.ProfileID, sc.URLsText, sc.IntervalMinutes, time.Now()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		log.Printf("update schedule %d: %v", id, err)
+		http.Error(w, "update failed", http.StatusInternalServerError)
 		return
 	}
-	cfg := defaultConfig()
-	if v := strings.TrimSpace(r.FormValue("wait_until")); v != "" {
-		cfg.WaitUntil = v
+	s.auditLog(r.Context(), "schedule.updated", strconv.FormatInt(id, 10), s.auditUser(r))
+	http.Redirect(w, r, "/schedules", http.StatusSeeOther)
+}
+
+func (s *Server) handleScheduleDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
-	if v := parseIntForm(r.FormValue("nav_timeout_ms")); v > 0 {
-		cfg.NavTimeoutMs = v
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
 	}
-	if v := parseIntForm(r.FormValue("settle_wait_ms")); v >= 0 {
-		cfg.SettleWaitMs = v
+	id, err := strconv.ParseInt(strings.TrimSpace(r.FormValue("id")), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
 	}
-	if v := parseIntForm(r.FormValue("concurrency")); v > 0 {
-		cfg.Concurrency = v
+	if err := s.deleteSchedule(r.Context(), id); err != nil {
+		http.Error(w, "delete failed", http.StatusInternalServerError)
+		return
 	}
-	if v := parseIntForm(r.FormValue("between_url_ms")); v >= 0 {
-		cfg.BetweenURLMs = v
+	s.auditLog(r.Context(), "schedule.deleted", strconv.FormatInt(id, 10), s.auditUser(r))
+	http.Redirect(w, r, "/schedules", http.StatusSeeOther)
+}
+
+// urlsFormSlack is how far a form body may exceed maxURLsBytes, to leave
+// room for the fields sent alongside "urls".
+const urlsFormSlack = 64 << 10
+
+// parseURLsForm parses a form with a "urls" field. An oversized field is
+// answered with 400 instead: the body is capped before ParseForm reads it,
+// since a single huge paste is expensive to buffer and split, and the field
+// itself is checked once parsed. It reports whether the handler can go on.
+func (s *Server) parseURLsForm(w http.ResponseWriter, r *http.Request) bool {
+	if s.maxURLsBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(s.maxURLsBytes)+urlsFormSlack)
+	}
+	tooLong := fmt.Sprintf("urls exceeds %d bytes", s.maxURLsBytes)
+	if err := r.ParseForm(); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, tooLong, http.StatusBadRequest)
+			return false
+		}
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return false
 	}
-	if v := strings.TrimSpace(r.FormValue("user_agent")); v != "" {
-		cfg.UserAgent = v
+	if s.maxURLsBytes > 0 && len(r.FormValue("urls")) > s.maxURLsBytes {
+		http.Error(w, tooLong, http.StatusBadRequest)
+		return false
 	}
-	if v := strings.TrimSpace(r.FormValue("accept_language")); v != "" {
-		cfg.AcceptLanguage = v
+	return true
+}
+
+// scheduleFromForm reads the profile, URLs and interval of a schedule form.
+func scheduleFromForm(r *http.Request) (Schedule, error) {
+	sc := Schedule{
+		ProfileID:       parseProfileID(r.FormValue("profile_id")),
+		URLsText:        strings.TrimSpace(r.FormValue("urls")),
+		IntervalMinutes: parseIntForm(r.FormValue("interval_minutes")),
 	}
-	cfgJSON, _ := json.Marshal(cfg)
-	if err := s.updateProfile(r.Context(), id, name, string(cfgJSON)); err != nil {
-		http.Error(w, "update failed: "+err.Error(), http.StatusBadRequest)
-		return
+	if len(parseURLList(sc.URLsText)) == 0 {
+		return sc, errors.New("urls required")
 	}
-	http.Redirect(w, r, "/profiles", http.StatusSeeOther)
+	if sc.IntervalMinutes < minScheduleMinutes {
+		return sc, fmt.Errorf("interval must be at least %d minutes", minScheduleMinutes)
+	}
+	return sc, nil
 }
 
-func (s *Server) handleRuns(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleURLLists(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		runs, err := s.listRuns(r.Context())
+		lists, err := s.listURLLists(r.Context())
 		if err != nil {
-			http.Error(w, "runs load failed", http.StatusInternalServerError)
+			http.Error(w, "url lists load failed", http.StatusInternalServerError)
 			return
 		}
-		profiles, _ := s.listProfiles(r.Context())
-		s.render(w, "runs.html", map[string]any{
-			"Runs":     runs,
-			"Profiles": profiles,
+		s.render(w, "url_lists.html", map[string]any{
+			"Lists": lists,
 		})
 	case http.MethodPost:
-		if err := r.ParseForm(); err != nil {
-			http.Error(w, "bad form", http.StatusBadRequest)
-			return
-		}
-		urlsText := strings.TrimSpace(r.FormValue("urls"))
-		if urlsText == "" {
-			http.Error(w, "urls required", http.StatusBadRequest)
+		if !s.parseURLsForm(w, r) {
 			return
 		}
-		urls := parseURLList(urlsText)
-		if len(urls) == 0 {
-			http.Error(w, "no valid urls", http.StatusBadRequest)
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" {
+			http.Error(w, "name required", http.StatusBadRequest)
 			return
 		}
-
-		var profileID sql.NullInt64
-		cfg := defaultConfig()
-		if v := strings.TrimSpace(r.FormValue("profile_id")); v != "" {
-			id, err := strconv.ParseInt(v, 10, 64)
-			if err == nil {
-				profileID = sql.NullInt64{Int64: id, Valid: true}
-				if p, err := s.getProfile(r.Context(), id); err == nil {
-					if parsed, err := parseConfig(p.ConfigJSON); err == nil {
-						cfg = parsed
-					}
-				}
-			}
-		} else {
-			if p, err := s.getProfileByName(r.Context(), defaultProfileName); err == nil {
-				profileID = sql.NullInt64{Int64: p.ID, Valid: true}
-				if parsed, err := parseConfig(p.ConfigJSON); err == nil {
-					cfg = parsed
-				}
-			}
-		}
-
-		start := time.Now()
-		report, exitCode, err := runCSPCheck(r.Context(), urls, cfg)
-		elapsed := time.Since(start)
+		id, err := s.createURLList(r.Context(), name, r.FormValue("urls"))
 		if err != nil {
-			http.Error(w, "csp check failed: "+err.Error(), http.StatusInternalServerError)
+			if isUniqueViolation(err) {
+				http.Error(w, fmt.Sprintf("a url list named %q already exists", name), http.StatusConflict)
+				return
+			}
+			log.Printf("create url list %q: %v", name, err)
+			http.Error(w, "create failed", http.StatusInternalServerError)
 			return
 		}
+		s.auditLog(r.Context(), "url_list.created", strconv.FormatInt(id, 10), s.auditUser(r))
+		http.Redirect(w, r, "/url-lists", http.StatusSeeOther)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
 
-		resultsJSON, err := json.Marshal(report)
-		if err != nil {
-			http.Error(w, "marshal results failed", http.StatusInternalServerError)
-			return
-		}
-		summary := summarizeMulti(report)
-		summaryJSON, err := json.Marshal(summary)
-		if err != nil {
-			http.Error(w, "marshal summary failed", http.StatusInternalServerError)
+func (s *Server) handleURLListUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.parseURLsForm(w, r) {
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimSpace(r.FormValue("id")), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "name required", http.StatusBadRequest)
+		return
+	}
+	if err := s.updateURLList(r.Context(), id, name, r.FormValue("urls")); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
 			return
 		}
-
-		runID, err := s.createRun(r.Context(), profileID, urlsText, string(summaryJSON), string(resultsJSON), exitCode, elapsed.Milliseconds())
-		if err != nil {
-			http.Error(w, "save run failed", http.StatusInternalServerError)
+		if isUniqueViolation(err) {
+			http.Error(w, fmt.Sprintf("a url list named %q already exists", name), http.StatusConflict)
 			return
 		}
-
-		http.Redirect(w, r, fmt.Sprintf("/runs/%d", runID), http.StatusSeeOther)
-	default:
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		log.Printf("update url list %d: %v", id, err)
+		http.Error(w, "update failed", http.StatusInternalServerError)
+		return
 	}
+	s.auditLog(r.Context(), "url_list.updated", strconv.FormatInt(id, 10), s.auditUser(r))
+	http.Redirect(w, r, "/url-lists", http.StatusSeeOther)
 }
 
-func (s *Server) handleRunRerun(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleURLListDelete(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -483,169 +1403,191 @@ func (s *Server) handleRunRerun(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad form", http.StatusBadRequest)
 		return
 	}
-	idStr := strings.TrimSpace(r.FormValue("id"))
-	if idStr == "" {
-		http.Error(w, "id required", http.StatusBadRequest)
-		return
-	}
-	id, err := strconv.ParseInt(idStr, 10, 64)
+	id, err := strconv.ParseInt(strings.TrimSpace(r.FormValue("id")), 10, 64)
 	if err != nil {
 		http.Error(w, "invalid id", http.StatusBadRequest)
 		return
 	}
-	prev, err := s.getRun(r.Context(), id)
-	if err != nil {
-		http.Error(w, "run not found", http.StatusNotFound)
+	if err := s.deleteURLList(r.Context(), id); err != nil {
+		http.Error(w, "delete failed", http.StatusInternalServerError)
 		return
 	}
+	s.auditLog(r.Context(), "url_list.deleted", strconv.FormatInt(id, 10), s.auditUser(r))
+	http.Redirect(w, r, "/url-lists", http.StatusSeeOther)
+}
 
-	urls := parseURLList(prev.URLsText)
-	if len(urls) == 0 {
-		http.Error(w, "no valid urls", http.StatusBadRequest)
+func (s *Server) handleProfileDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-
-	cfg := defaultConfig()
-	profileID := prev.ProfileID
-	if v := strings.TrimSpace(r.FormValue("profile_id")); v != "" {
-		if pid, err := strconv.ParseInt(v, 10, 64); err == nil {
-			profileID = sql.NullInt64{Int64: pid, Valid: true}
-		}
-	}
-	if profileID.Valid {
-		if p, err := s.getProfile(r.Context(), profileID.Int64); err == nil {
-			if parsed, err := parseConfig(p.ConfigJSON); err == nil {
-				cfg = parsed
-			}
-		}
-	} else {
-		if p, err := s.getProfileByName(r.Context(), defaultProfileName); err == nil {
-			profileID = sql.NullInt64{Int64: p.ID, Valid: true}
-			if parsed, err := parseConfig(p.ConfigJSON); err == nil {
-				cfg = parsed
-			}
-		}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
 	}
-
-	start := time.Now()
-		report, exitCode, err := runCSPCheck(r.Context(), urls, cfg)
-		elapsed := time.Since(start)
+	id, err := strconv.ParseInt(strings.TrimSpace(r.FormValue("id")), 10, 64)
 	if err != nil {
-		http.Error(w, "csp check failed: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "invalid id", http.StatusBadRequest)
 		return
 	}
-
-	resultsJSON, err := json.Marshal(report)
+	p, err := s.getProfile(r.Context(), id)
 	if err != nil {
-		http.Error(w, "marshal results failed", http.StatusInternalServerError)
+		http.Error(w, "profile not found", http.StatusNotFound)
 		return
 	}
-	summary := summarizeMulti(report)
-	summaryJSON, err := json.Marshal(summary)
-	if err != nil {
-		http.Error(w, "marshal summary failed", http.StatusInternalServerError)
+	if p.Name == defaultProfileName {
+		http.Error(w, "the default profile cannot be deleted", http.StatusBadRequest)
 		return
 	}
-
-	runID, err := s.createRun(r.Context(), profileID, prev.URLsText, string(summaryJSON), string(resultsJSON), exitCode, elapsed.Milliseconds())
-	if err != nil {
-		http.Error(w, "save run failed", http.StatusInternalServerError)
+	if err := s.deleteProfile(r.Context(), id); err != nil {
+		log.Printf("delete profile %d: %v", id, err)
+		http.Error(w, "delete failed", http.StatusInternalServerError)
 		return
 	}
-
-	http.Redirect(w, r, fmt.Sprintf("/runs/%d", runID), http.StatusSeeOther)
+	s.auditLog(r.Context(), "profile.deleted", strconv.FormatInt(id, 10), s.auditUser(r))
+	http.Redirect(w, r, "/profiles", http.StatusSeeOther)
 }
 
-func (s *Server) handleRunDetail(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+// handleProfileArchive archives a profile, or restores it when archived=0.
+func (s *Server) handleProfileArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	idStr := strings.TrimPrefix(r.URL.Path, "/runs/")
-	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimSpace(r.FormValue("id")), 10, 64)
 	if err != nil {
-		http.NotFound(w, r)
+		http.Error(w, "invalid id", http.StatusBadRequest)
 		return
 	}
-	run, err := s.getRun(r.Context(), id)
+	p, err := s.getProfile(r.Context(), id)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			http.NotFound(w, r)
-			return
-		}
-		http.Error(w, "run load failed", http.StatusInternalServerError)
+		http.Error(w, "profile not found", http.StatusNotFound)
 		return
 	}
-	var multi MultiReport
-	if err := json.Unmarshal([]byte(run.ResultsJSON), &multi); err != nil || len(multi.Browsers) == 0 {
-		var single Report
-		if err := json.Unmarshal([]byte(run.ResultsJSON), &single); err != nil {
-			http.Error(w, "run parse failed", http.StatusInternalServerError)
+	archived := r.FormValue("archived") != "0"
+	if archived && p.Name == defaultProfileName {
+		http.Error(w, "the default profile cannot be archived", http.StatusBadRequest)
+		return
+	}
+	if err := s.setProfileArchived(r.Context(), id, archived); err != nil {
+		log.Printf("archive profile %d: %v", id, err)
+		http.Error(w, "archive failed", http.StatusInternalServerError)
+		return
+	}
+	action := "profile.archived"
+	if !archived {
+		action = "profile.restored"
+	}
+	s.auditLog(r.Context(), action, strconv.FormatInt(id, 10), s.auditUser(r))
+	http.Redirect(w, r, "/profiles?archived=1", http.StatusSeeOther)
+}
+
+func (s *Server) handleProfiles(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		showArchived := r.URL.Query().Get("archived") == "1"
+		profiles, err := s.listProfiles(r.Context(), showArchived)
+		if err != nil {
+			http.Error(w, "profiles load failed", http.StatusInternalServerError)
 			return
 		}
-		multi = MultiReport{
-			GeneratedAt: single.GeneratedAt,
-			Config:      single.Config,
-			Browsers:    map[string]Report{"chromium": single},
+		names := make(map[int64]string, len(profiles))
+		for _, p := range profiles {
+			names[p.ID] = p.Name
 		}
-	}
+		duplicates := map[int64][]string{}
+		for _, group := range findDuplicateProfiles(profiles) {
+			for _, id := range group {
+				for _, other := range group {
+					if other != id {
+						duplicates[id] = append(duplicates[id], names[other])
+					}
+				}
+			}
+		}
+		views := make([]ProfileView, 0, len(profiles))
+		for _, p := range profiles {
+			cfg, err := parseConfig(p.ConfigJSON)
+			view := ProfileView{
+				ID:          p.ID,
+				Name:        p.Name,
+				CreatedAt:   p.CreatedAt,
+				Archived:    p.Archived,
+				Config:      cfg.redacted(),
+				DuplicateOf: duplicates[p.ID],
+			}
+			if err != nil {
+				view.ConfigError = err.Error()
+			}
+			views = append(views, view)
+		}
+		s.render(w, "profiles.html", map[string]any{
+			"Profiles":     views,
+			"ShowArchived": showArchived,
+			"Defaults":     defaultConfig(),
 
-	var browserReports []BrowserReport
-	for _, name := range browsers {
-		if rep, ok := multi.Browsers[name]; ok {
-			browserReports = append(browserReports, BrowserReport{
-				Name:   name,
-				Report: rep,
-				Groups: groupViolationsByDisposition(rep.Results, "enforce"),
-				Warns:  groupViolationsByDisposition(rep.Results, "report-only"),
-			})
+			"MaxConcurrency": s.maxConcurrency,
+			"MaxProfiles":    s.maxProfiles,
+			"Devices":        deviceNames(),
+		})
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "bad form", http.StatusBadRequest)
+			return
 		}
-	}
-	// Include any unexpected browser keys
-	for name, rep := range multi.Browsers {
-		found := false
-		for _, b := range browserReports {
-			if b.Name == name {
-				found = true
-				break
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" {
+			http.Error(w, "name required", http.StatusBadRequest)
+			return
+		}
+		cfg := defaultConfig()
+		if err := applyConfigForm(&cfg, r); err != nil {
+			http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateConfig(cfg); err != nil {
+			http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		cfgJSON, _ := json.Marshal(cfg)
+		if err := s.createProfile(r.Context(), name, string(cfgJSON)); err != nil {
+			if isUniqueViolation(err) {
+				http.Error(w, fmt.Sprintf("a profile named %q already exists", name), http.StatusConflict)
+				return
+			}
+			if errors.Is(err, errProfileLimit) {
+				http.Error(w, fmt.Sprintf("profile limit reached: at most %d profiles besides %s; delete one first", s.maxProfiles, defaultProfileName), http.StatusBadRequest)
+				return
 			}
+			log.Printf("create profile %q: %v", name, err)
+			http.Error(w, "create failed", http.StatusInternalServerError)
+			return
 		}
-		if !found {
-			browserReports = append(browserReports, BrowserReport{
-				Name:   name,
-				Report: rep,
-				Groups: groupViolationsByDisposition(rep.Results, "enforce"),
-				Warns:  groupViolationsByDisposition(rep.Results, "report-only"),
-			})
+		target := name
+		if p, err := s.getProfileByName(r.Context(), name); err == nil {
+			target = strconv.FormatInt(p.ID, 10)
 		}
+		s.auditLog(r.Context(), "profile.created", target, s.auditUser(r))
+		http.Redirect(w, r, "/profiles", http.StatusSeeOther)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	}
-
-	profiles, _ := s.listProfiles(r.Context())
-
-	s.render(w, "run.html", map[string]any{
-		"Run":      run,
-		"Browsers": browserReports,
-		"MergedErr":  groupViolationsMultiByDisposition(browserReports, "enforce"),
-		"MergedWarn": groupViolationsMultiByDisposition(browserReports, "report-only"),
-		"Profiles": profiles,
-	})
 }
 
-func (s *Server) handleDocs(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+func (s *Server) handleProfileUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	s.render(w, "docs.html", map[string]any{})
-}
-
-func (s *Server) handleRunExport(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
 		return
 	}
-	idStr := strings.TrimSpace(r.URL.Query().Get("id"))
-	pretty := strings.TrimSpace(r.URL.Query().Get("pretty")) == "1"
+	idStr := strings.TrimSpace(r.FormValue("id"))
 	if idStr == "" {
 		http.Error(w, "id required", http.StatusBadRequest)
 		return
@@ -655,31 +1597,59 @@ func (s *Server) handleRunExport(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid id", http.StatusBadRequest)
 		return
 	}
-	run, err := s.getRun(r.Context(), id)
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "name required", http.StatusBadRequest)
+		return
+	}
+	existing, err := s.getProfile(r.Context(), id)
 	if err != nil {
-		http.Error(w, "run not found", http.StatusNotFound)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "profile not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "profile load failed", http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"csp-run-%d.json\"", run.ID))
-	if !pretty {
-		_, _ = w.Write([]byte(run.ResultsJSON))
+	// Start from the saved config so a field left blank keeps its current
+	// value instead of falling back to the default. It is validated once the
+	// form is applied, so an invalid stored config can be fixed here.
+	cfg, err := decodeConfig(existing.ConfigJSON)
+	if err != nil {
+		http.Error(w, "stored config unreadable: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	var obj any
-	if err := json.Unmarshal([]byte(run.ResultsJSON), &obj); err != nil {
-		_, _ = w.Write([]byte(run.ResultsJSON))
+	prevUser := cfg.BasicAuthUser
+	if err := applyConfigForm(&cfg, r); err != nil {
+		http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	b, err := json.MarshalIndent(obj, "", "  ")
-	if err != nil {
-		_, _ = w.Write([]byte(run.ResultsJSON))
+	// The edit form never echoes the stored password back, so a blank
+	// password field keeps the current one, but only for the same user.
+	if r.FormValue("basic_auth_pass") == "" && cfg.BasicAuthUser != prevUser {
+		cfg.BasicAuthPass = ""
+	}
+	if err := validateConfig(cfg); err != nil {
+		http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	_, _ = w.Write(b)
+	cfgJSON, _ := json.Marshal(cfg)
+	if err := s.updateProfile(r.Context(), id, name, string(cfgJSON)); err != nil {
+		if isUniqueViolation(err) {
+			http.Error(w, fmt.Sprintf("a profile named %q already exists", name), http.StatusConflict)
+			return
+		}
+		log.Printf("update profile %d: %v", id, err)
+		http.Error(w, "update failed", http.StatusInternalServerError)
+		return
+	}
+	s.auditLog(r.Context(), "profile.updated", strconv.FormatInt(id, 10), s.auditUser(r))
+	http.Redirect(w, r, "/profiles", http.StatusSeeOther)
 }
 
-func (s *Server) handleRunCopy(w http.ResponseWriter, r *http.Request) {
+// handleProfileRunLast re-runs the URLs of a profile's most recent run with
+// the profile's current config.
+func (s *Server) handleProfileRunLast(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -688,279 +1658,5947 @@ func (s *Server) handleRunCopy(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad form", http.StatusBadRequest)
 		return
 	}
-	idStr := strings.TrimSpace(r.FormValue("id"))
-	if idStr == "" {
-		http.Error(w, "id required", http.StatusBadRequest)
+	id, err := strconv.ParseInt(strings.TrimSpace(r.FormValue("profile_id")), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid profile id", http.StatusBadRequest)
 		return
 	}
-	id, err := strconv.ParseInt(idStr, 10, 64)
+	profile, err := s.getProfile(r.Context(), id)
 	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "profile load failed", http.StatusInternalServerError)
 		return
 	}
-	run, err := s.getRun(r.Context(), id)
+	last, err := s.lastRunForProfile(r.Context(), profile.ID)
 	if err != nil {
-		http.Error(w, "run not found", http.StatusNotFound)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "profile has no previous runs", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "run load failed", http.StatusInternalServerError)
 		return
 	}
-	http.Redirect(w, r, "/?urls="+url.QueryEscape(run.URLsText), http.StatusSeeOther)
+	profileID, cfg, err := s.resolveConfig(r.Context(), sql.NullInt64{Int64: profile.ID, Valid: true})
+	if err != nil {
+		http.Error(w, err.Error(), runErrorStatus(err))
+		return
+	}
+	runID, err := s.executeRun(r.Context(), profileID, last.URLsText, cfg)
+	if err != nil {
+		http.Error(w, err.Error(), runErrorStatus(err))
+		return
+	}
+	s.auditLog(r.Context(), "run.created", strconv.FormatInt(runID, 10), s.auditUser(r))
+	http.Redirect(w, r, fmt.Sprintf("/runs/%d", runID), http.StatusSeeOther)
 }
 
-func (s *Server) handleRunSnippet(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+// rerunHistoryLimit bounds how many of a profile's runs one history rerun
+// re-executes, newest first.
+const rerunHistoryLimit = 500
+
+// RerunOutcome is one historical run re-executed by a history rerun.
+type RerunOutcome struct {
+	SourceRunID int64  `json:"sourceRunId"`
+	RunID       int64  `json:"runId,omitempty"`
+	New         int    `json:"new"`
+	Resolved    int    `json:"resolved"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Changed reports whether the rerun found different violation groups.
+func (o RerunOutcome) Changed() bool {
+	return o.New > 0 || o.Resolved > 0
+}
+
+// RerunProgress is the state of a history rerun, as streamed to clients.
+type RerunProgress struct {
+	JobID     int64          `json:"jobId"`
+	ProfileID int64          `json:"profileId"`
+	Total     int            `json:"total"`
+	Done      int            `json:"done"`
+	Changed   int            `json:"changed"`
+	Unchanged int            `json:"unchanged"`
+	Failed    int            `json:"failed"`
+	Finished  bool           `json:"finished"`
+	Canceled  bool           `json:"canceled"`
+	Outcomes  []RerunOutcome `json:"outcomes"`
+}
+
+// rerunJob re-executes every historical run of a profile under its current
+// config. It outlives the request that started it and stops early when
+// canceled; runs already started are left to finish or fail.
+type rerunJob struct {
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	progress RerunProgress
+	// updated is closed and replaced whenever progress changes.
+	updated chan struct{}
+	// finishedAt is when progress became Finished.
+	finishedAt time.Time
+}
+
+// rerunJobTTL is how long a finished history rerun stays available to
+// clients polling for its results.
+const rerunJobTTL = time.Hour
+
+// snapshot returns a copy of the job's progress and a channel closed on the
+// next change.
+func (j *rerunJob) snapshot() (RerunProgress, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	p := j.progress
+	p.Outcomes = append([]RerunOutcome(nil), p.Outcomes...)
+	return p, j.updated
+}
+
+func (j *rerunJob) update(fn func(p *RerunProgress)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn(&j.progress)
+	close(j.updated)
+	j.updated = make(chan struct{})
+}
+
+// wait blocks until the job has finished.
+func (j *rerunJob) wait() RerunProgress {
+	for {
+		p, updated := j.snapshot()
+		if p.Finished {
+			return p
+		}
+		<-updated
+	}
+}
+
+// rerunParallelism is how many runs a history rerun executes at once: as
+// many as fit in CSP_MAX_CONCURRENCY pages in flight at the profile's
+// concurrency, and at least one.
+func (s *Server) rerunParallelism(cfg CSPConfig) int {
+	if s.maxConcurrency <= 0 || cfg.Concurrency <= 0 {
+		return 1
+	}
+	if n := s.maxConcurrency / cfg.Concurrency; n > 1 {
+		return n
+	}
+	return 1
+}
+
+// startRerunHistory starts a job re-executing the profile's runs, oldest
+// first, and returns once the job is registered.
+func (s *Server) startRerunHistory(ctx context.Context, profileID int64, user string) (*rerunJob, error) {
+	runs, err := s.listRunsForProfile(ctx, profileID, rerunHistoryLimit)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(runs)-1; i < j; i, j = i+1, j-1 {
+		runs[i], runs[j] = runs[j], runs[i]
+	}
+	pid, cfg, err := s.resolveConfig(ctx, sql.NullInt64{Int64: profileID, Valid: true})
+	if err != nil {
+		return nil, err
+	}
+
+	jobCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	job := &rerunJob{cancel: cancel, updated: make(chan struct{})}
+	s.jobsMu.Lock()
+	if s.rerunJobs == nil {
+		s.rerunJobs = map[int64]*rerunJob{}
+	}
+	s.pruneRerunJobs(time.Now())
+	s.lastJobID++
+	job.progress = RerunProgress{JobID: s.lastJobID, ProfileID: profileID, Total: len(runs), Outcomes: []RerunOutcome{}}
+	s.rerunJobs[s.lastJobID] = job
+	s.jobsMu.Unlock()
+
+	go func() {
+		defer cancel()
+		slots := make(chan struct{}, s.rerunParallelism(cfg))
+		var wg sync.WaitGroup
+		for _, prev := range runs {
+			select {
+			case slots <- struct{}{}:
+			case <-jobCtx.Done():
+			}
+			if jobCtx.Err() != nil {
+				break
+			}
+			wg.Add(1)
+			go func(prev Run) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				outcome := s.rerunOne(jobCtx, pid, cfg, prev, user)
+				job.update(func(p *RerunProgress) {
+					p.Done++
+					switch {
+					case outcome.Error != "":
+						p.Failed++
+					case outcome.Changed():
+						p.Changed++
+					default:
+						p.Unchanged++
+					}
+					p.Outcomes = append(p.Outcomes, outcome)
+				})
+			}(prev)
+		}
+		wg.Wait()
+		job.update(func(p *RerunProgress) {
+			p.Finished = true
+			job.finishedAt = time.Now()
+			p.Canceled = jobCtx.Err() != nil
+			sort.Slice(p.Outcomes, func(i, j int) bool { return p.Outcomes[i].SourceRunID < p.Outcomes[j].SourceRunID })
+		})
+	}()
+	return job, nil
+}
+
+// rerunOne re-executes prev and diffs the new run against it.
+func (s *Server) rerunOne(ctx context.Context, profileID sql.NullInt64, cfg CSPConfig, prev Run, user string) RerunOutcome {
+	outcome := RerunOutcome{SourceRunID: prev.ID}
+	runID, err := s.executeRun(ctx, profileID, prev.URLsText, cfg)
+	if err != nil {
+		outcome.RunID = runID
+		outcome.Error = err.Error()
+		return outcome
+	}
+	outcome.RunID = runID
+	s.auditLog(ctx, "run.created", strconv.FormatInt(runID, 10), user)
+	before, err := loadMultiReport(prev)
+	if err != nil {
+		outcome.Error = "run parse failed"
+		return outcome
+	}
+	run, err := s.getRun(ctx, runID)
+	if err != nil {
+		outcome.Error = "run load failed"
+		return outcome
+	}
+	after, err := loadMultiReport(run)
+	if err != nil {
+		outcome.Error = "run parse failed"
+		return outcome
+	}
+	d := diffRuns(before, after)
+	outcome.New, outcome.Resolved = len(d.New), len(d.Resolved)
+	return outcome
+}
+
+func (s *Server) rerunJob(id int64) *rerunJob {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	s.pruneRerunJobs(time.Now())
+	return s.rerunJobs[id]
+}
+
+// pruneRerunJobs drops jobs that finished more than rerunJobTTL before now.
+// The caller holds jobsMu.
+func (s *Server) pruneRerunJobs(now time.Time) {
+	for id, job := range s.rerunJobs {
+		job.mu.Lock()
+		expired := job.progress.Finished && now.Sub(job.finishedAt) > rerunJobTTL
+		job.mu.Unlock()
+		if expired {
+			delete(s.rerunJobs, id)
+		}
+	}
+}
+
+// handleProfileRerunHistory starts re-executing all of a profile's runs
+// under its current config and redirects to the job's progress page.
+func (s *Server) handleProfileRerunHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	rawURL := strings.TrimSpace(r.URL.Query().Get("url"))
-	if rawURL == "" {
-		http.Error(w, "url required", http.StatusBadRequest)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
 		return
 	}
-	lineStr := strings.TrimSpace(r.URL.Query().Get("line"))
-	if lineStr == "" {
-		http.Error(w, "line required", http.StatusBadRequest)
+	id, err := strconv.ParseInt(strings.TrimSpace(r.FormValue("profile_id")), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid profile id", http.StatusBadRequest)
 		return
 	}
-	lineNum, err := strconv.Atoi(lineStr)
-	if err != nil || lineNum <= 0 {
-		http.Error(w, "invalid line", http.StatusBadRequest)
+	profile, err := s.getProfile(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "profile load failed", http.StatusInternalServerError)
 		return
 	}
-	parsed, err := url.Parse(rawURL)
-	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
-		http.Error(w, "invalid url", http.StatusBadRequest)
+	job, err := s.startRerunHistory(r.Context(), profile.ID, s.auditUser(r))
+	if err != nil {
+		http.Error(w, "run load failed", http.StatusInternalServerError)
 		return
 	}
+	p, _ := job.snapshot()
+	if p.Total == 0 {
+		job.cancel()
+		http.Error(w, "profile has no previous runs", http.StatusBadRequest)
+		return
+	}
+	s.auditLog(r.Context(), "rerun_job.started", strconv.FormatInt(p.JobID, 10), s.auditUser(r))
+	http.Redirect(w, r, fmt.Sprintf("/rerun-jobs/%d", p.JobID), http.StatusSeeOther)
+}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
-	defer cancel()
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
-	if err != nil {
-		http.Error(w, "request failed", http.StatusBadRequest)
+// handleRerunJob serves a history rerun's progress page at /rerun-jobs/{id}
+// and its progress as server-sent events at /rerun-jobs/{id}/events: one
+// "progress" event per change, then "done" with the final summary.
+func (s *Server) handleRerunJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	req.Header.Set("User-Agent", "csp-web-snippet")
-	resp, err := http.DefaultClient.Do(req)
+	rest := strings.TrimPrefix(r.URL.Path, "/rerun-jobs/")
+	idStr, sub, _ := strings.Cut(rest, "/")
+	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "fetch failed", http.StatusBadRequest)
+		http.NotFound(w, r)
 		return
 	}
-	defer resp.Body.Close()
+	job := s.rerunJob(id)
+	if job == nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch sub {
+	case "":
+		p, _ := job.snapshot()
+		profile, err := s.getProfile(r.Context(), p.ProfileID)
+		if err != nil {
+			profile = Profile{ID: p.ProfileID}
+		}
+		s.render(w, "rerun_job.html", map[string]any{
+			"Job":     p,
+			"Profile": profile,
+		})
+	case "events":
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		for {
+			p, updated := job.snapshot()
+			data, err := json.Marshal(p)
+			if err != nil {
+				return
+			}
+			event := "progress"
+			if p.Finished {
+				event = "done"
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+			flusher.Flush()
+			if p.Finished {
+				return
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-updated:
+			}
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		http.Error(w, "fetch failed", http.StatusBadRequest)
+// handleRerunJobCancel stops a history rerun from starting more runs.
+func (s *Server) handleRerunJobCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimSpace(r.FormValue("id")), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	job := s.rerunJob(id)
+	if job == nil {
+		http.NotFound(w, r)
 		return
 	}
+	job.cancel()
+	s.auditLog(r.Context(), "rerun_job.canceled", strconv.FormatInt(id, 10), s.auditUser(r))
+	http.Redirect(w, r, fmt.Sprintf("/rerun-jobs/%d", id), http.StatusSeeOther)
+}
 
-	const maxBytes = 1 << 20
-	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes))
+// handleProfileSubpage serves /profiles/{id}/... pages.
+func (s *Server) handleProfileSubpage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/profiles/"), "/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.ParseInt(parts[0], 10, 64)
 	if err != nil {
-		http.Error(w, "read failed", http.StatusBadRequest)
+		http.NotFound(w, r)
 		return
 	}
-	lines := strings.Split(string(body), "\n")
-	if lineNum > len(lines) {
-		http.Error(w, "line out of range", http.StatusBadRequest)
+	profile, err := s.getProfile(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "profile load failed", http.StatusInternalServerError)
 		return
 	}
+	switch parts[1] {
+	case "history":
+		s.renderProfileHistory(w, r, profile)
+	case "matrix":
+		s.renderProfileMatrix(w, r, profile)
+	default:
+		http.NotFound(w, r)
+	}
+}
 
-	start := lineNum - 4
-	if start < 1 {
-		start = 1
+func (s *Server) renderProfileMatrix(w http.ResponseWriter, r *http.Request, profile Profile) {
+	limit := 10
+	if v := parseIntForm(r.URL.Query().Get("limit")); v > 0 {
+		limit = v
 	}
-	end := lineNum + 3
-	if end > len(lines) {
-		end = len(lines)
+	if limit > 100 {
+		limit = 100
 	}
-	var b strings.Builder
-	for i := start; i <= end; i++ {
-		fmt.Fprintf(&b, "%4d: %s\n", i, lines[i-1])
+	matrix, err := s.violationMatrix(r.Context(), profile.ID, limit)
+	if err != nil {
+		http.Error(w, "matrix load failed", http.StatusInternalServerError)
+		return
+	}
+	tracks, err := s.violationTracks(r.Context(), profile.ID)
+	if err != nil {
+		log.Printf("profile %d: violation tracking: %v", profile.ID, err)
 	}
+	s.render(w, "profile_matrix.html", map[string]any{
+		"Profile": profile,
+		"Matrix":  matrix,
+		"Limit":   limit,
+		"Tracks":  tracks,
+	})
+}
 
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	w.Header().Set("Content-Disposition", "attachment; filename=\"snippet.txt\"")
-	_, _ = w.Write([]byte(b.String()))
+func (s *Server) renderProfileHistory(w http.ResponseWriter, r *http.Request, profile Profile) {
+	entries, err := s.profileHistory(r.Context(), profile.ID)
+	if err != nil {
+		http.Error(w, "history load failed", http.StatusInternalServerError)
+		return
+	}
+	// Each entry holds the config as it was before the change at ChangedAt,
+	// so it is compared with the next entry, or the current config for the
+	// most recent change. Newest changes are listed first.
+	views := make([]ProfileChangeView, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		next := profile.ConfigJSON
+		if i+1 < len(entries) {
+			next = entries[i+1].ConfigJSON
+		}
+		views = append(views, ProfileChangeView{
+			ChangedAt: entries[i].ChangedAt,
+			Changes:   configChanges(entries[i].ConfigJSON, next),
+		})
+	}
+	s.render(w, "profile_history.html", map[string]any{
+		"Profile": profile,
+		"History": views,
+	})
 }
 
-func (s *Server) render(w http.ResponseWriter, name string, data map[string]any) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := s.tmpl.ExecuteTemplate(w, name, data); err != nil {
-		http.Error(w, "template error", http.StatusInternalServerError)
+// configChanges lists fields that differ between two stored config JSON
+// documents, sorted by field name. Secret values are never shown.
+func configChanges(prevJSON, nextJSON string) []ConfigChange {
+	var prev, next map[string]any
+	_ = json.Unmarshal([]byte(prevJSON), &prev)
+	_ = json.Unmarshal([]byte(nextJSON), &next)
+
+	fields := map[string]struct{}{}
+	for k := range prev {
+		fields[k] = struct{}{}
+	}
+	for k := range next {
+		fields[k] = struct{}{}
 	}
+	names := make([]string, 0, len(fields))
+	for k := range fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var changes []ConfigChange
+	for _, k := range names {
+		from, to := configValueString(prev[k]), configValueString(next[k])
+		if from == to {
+			continue
+		}
+		if k == "basicAuthPass" {
+			from, to = "(hidden)", "(changed)"
+		}
+		if k == "notifyWebhook" {
+			from, to = redactURL(from), redactURL(to)
+		}
+		changes = append(changes, ConfigChange{Field: k, From: from, To: to})
+	}
+	return changes
 }
 
-func (s *Server) listProfiles(ctx context.Context) ([]Profile, error) {
-	rows, err := s.db.QueryContext(ctx, `SELECT id, name, config_json, created_at FROM profiles ORDER BY created_at DESC`)
-	if err != nil {
-		return nil, err
+func configValueString(v any) string {
+	if v == nil {
+		return ""
 	}
-	defer rows.Close()
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
 
-	var profiles []Profile
-	for rows.Next() {
-		var p Profile
-		if err := rows.Scan(&p.ID, &p.Name, &p.ConfigJSON, &p.CreatedAt); err != nil {
-			return nil, err
+func (s *Server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		from, to, err := parseRunDateRange(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		runs, err := s.listRunsBetween(r.Context(), from, to)
+		if err != nil {
+			http.Error(w, "runs load failed", http.StatusInternalServerError)
+			return
+		}
+		profiles, _ := s.listProfiles(r.Context(), true)
+		var failed, checked []Run
+		for _, run := range runs {
+			if runError(run) != "" {
+				failed = append(failed, run)
+				continue
+			}
+			checked = append(checked, run)
+		}
+		badges := s.regressionBadges(r.Context(), checked)
+		s.render(w, "runs.html", map[string]any{
+			"Runs":     runs,
+			"Profiles": profiles,
+			"Badges":   badges,
+			"Failed":   failed,
+			"From":     r.URL.Query().Get("from"),
+			"To":       r.URL.Query().Get("to"),
+		})
+	case http.MethodPost:
+		if !s.parseURLsForm(w, r) {
+			return
+		}
+		profileID, cfg, err := s.resolveConfig(r.Context(), parseProfileID(r.FormValue("profile_id")))
+		if err != nil {
+			http.Error(w, err.Error(), runErrorStatus(err))
+			return
+		}
+		urlsText := strings.TrimSpace(r.FormValue("urls"))
+		if urlsText == "" {
+			urlsText = cfg.DefaultURLs
+		}
+		if urlsText == "" {
+			http.Error(w, "urls required", http.StatusBadRequest)
+			return
+		}
+		runID, err := s.executeRun(r.Context(), profileID, urlsText, cfg)
+		if err != nil {
+			http.Error(w, err.Error(), runErrorStatus(err))
+			return
+		}
+		s.auditLog(r.Context(), "run.created", strconv.FormatInt(runID, 10), s.auditUser(r))
+		if label := strings.TrimSpace(r.FormValue("label")); label != "" {
+			if err := s.setRunLabel(r.Context(), runID, label); err != nil {
+				log.Printf("run %d: label: %v", runID, err)
+			}
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/runs/%d", runID), http.StatusSeeOther)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRunFromURL starts a run from a newline-delimited URL list fetched
+// from the "source" URL.
+func (s *Server) handleRunFromURL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+	source := strings.TrimSpace(r.FormValue("source"))
+	if source == "" {
+		http.Error(w, "source required", http.StatusBadRequest)
+		return
+	}
+	client := s.urlListClient
+	if client == nil {
+		client = newGuardedClient(10 * time.Second)
+	}
+	urlsText, err := fetchURLList(r.Context(), client, source)
+	if err != nil {
+		http.Error(w, "fetch url list failed: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	profileID, cfg, err := s.resolveConfig(r.Context(), parseProfileID(r.FormValue("profile_id")))
+	if err != nil {
+		http.Error(w, err.Error(), runErrorStatus(err))
+		return
+	}
+	runID, err := s.executeRun(r.Context(), profileID, urlsText, cfg)
+	if err != nil {
+		http.Error(w, err.Error(), runErrorStatus(err))
+		return
+	}
+	s.auditLog(r.Context(), "run.created", strconv.FormatInt(runID, 10), s.auditUser(r))
+	http.Redirect(w, r, fmt.Sprintf("/runs/%d", runID), http.StatusSeeOther)
+}
+
+// ProfileComparison is one profile's row on the all-profiles comparison
+// page. Error is set, and RunID zero, when its run failed.
+type ProfileComparison struct {
+	ProfileID   int64
+	ProfileName string
+	RunID       int64
+	Enforce     int
+	Violations  int
+	Error       string
+	// Fewest marks the profile(s) with the fewest enforced violations.
+	Fewest bool
+}
+
+// handleRunAllProfiles runs the submitted URLs under every non-archived
+// profile, one after the other, and renders a page comparing the runs.
+func (s *Server) handleRunAllProfiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.parseURLsForm(w, r) {
+		return
+	}
+	urlsText := strings.TrimSpace(r.FormValue("urls"))
+	if len(parseURLList(urlsText)) == 0 {
+		http.Error(w, "urls required", http.StatusBadRequest)
+		return
+	}
+	profiles, err := s.listProfiles(r.Context(), false)
+	if err != nil {
+		http.Error(w, "profiles load failed", http.StatusInternalServerError)
+		return
+	}
+	label := strings.TrimSpace(r.FormValue("label"))
+	rows := make([]ProfileComparison, 0, len(profiles))
+	for _, p := range profiles {
+		row := ProfileComparison{ProfileID: p.ID, ProfileName: p.Name}
+		profileID, cfg, err := s.resolveConfig(r.Context(), sql.NullInt64{Int64: p.ID, Valid: true})
+		var runID int64
+		if err == nil {
+			runID, err = s.executeRun(r.Context(), profileID, urlsText, cfg)
+		}
+		if err == nil {
+			s.auditLog(r.Context(), "run.created", strconv.FormatInt(runID, 10), s.auditUser(r))
+		}
+		if err == nil && label != "" {
+			if err := s.setRunLabel(r.Context(), runID, label); err != nil {
+				log.Printf("run %d: label: %v", runID, err)
+			}
+		}
+		var multi MultiReport
+		if err == nil {
+			var run Run
+			if run, err = s.getRun(r.Context(), runID); err == nil {
+				multi, err = loadMultiReport(run)
+			}
+		}
+		if err != nil {
+			log.Printf("all profiles: %s: %v", p.Name, err)
+			row.Error = err.Error()
+			rows = append(rows, row)
+			continue
+		}
+		row.RunID = runID
+		row.Enforce, row.Violations = countViolations(multi)
+		rows = append(rows, row)
+	}
+	markFewestEnforced(rows)
+	s.render(w, "all_profiles.html", map[string]any{
+		"URLs": parseURLList(urlsText),
+		"Rows": rows,
+	})
+}
+
+// countViolations returns the enforced and total violation counts of a run
+// across all browsers.
+func countViolations(multi MultiReport) (enforce, total int) {
+	for _, rep := range multi.Browsers {
+		for _, r := range rep.Results {
+			for _, v := range r.Violations {
+				total++
+				if isDisposition(v.Disposition, "enforce") {
+					enforce++
+				}
+			}
+		}
+	}
+	return enforce, total
+}
+
+// markFewestEnforced sets Fewest on the successful rows with the lowest
+// enforced violation count; ties are all marked.
+func markFewestEnforced(rows []ProfileComparison) {
+	fewest := -1
+	for _, row := range rows {
+		if row.Error == "" && (fewest < 0 || row.Enforce < fewest) {
+			fewest = row.Enforce
+		}
+	}
+	for i := range rows {
+		rows[i].Fewest = rows[i].Error == "" && rows[i].Enforce == fewest
+	}
+}
+
+// ProfileURLGroup is the URLs of a CSV upload assigned to one profile.
+type ProfileURLGroup struct {
+	ProfileName string
+	URLs        []string
+}
+
+// parseProfileCSV reads url,profileName rows, grouped by profile in order
+// of first appearance. A blank profile name means the default profile, a
+// leading "url" header row is skipped, and lines starting with # are
+// comments. A URL listed twice for the same profile is checked once.
+func parseProfileCSV(r io.Reader) ([]ProfileURLGroup, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	var groups []ProfileURLGroup
+	index := map[string]int{}
+	for row := 1; ; row++ {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		raw := strings.TrimSpace(rec[0])
+		if row == 1 && strings.EqualFold(raw, "url") {
+			continue
+		}
+		if raw == "" && len(rec) == 1 {
+			continue
+		}
+		if len(rec) > 2 {
+			return nil, fmt.Errorf("row %d: want url,profileName", row)
+		}
+		u, ok := normalizeURL(raw)
+		if !ok || !(strings.HasPrefix(raw, "http://") || strings.HasPrefix(raw, "https://")) {
+			return nil, fmt.Errorf("row %d: %q is not a full http or https URL", row, raw)
+		}
+		name := defaultProfileName
+		if len(rec) == 2 && strings.TrimSpace(rec[1]) != "" {
+			name = strings.TrimSpace(rec[1])
+		}
+		i, ok := index[name]
+		if !ok {
+			i = len(groups)
+			index[name] = i
+			groups = append(groups, ProfileURLGroup{ProfileName: name})
+		}
+		if !containsString(groups[i].URLs, u) {
+			groups[i].URLs = append(groups[i].URLs, u)
+		}
+	}
+	return groups, nil
+}
+
+// groupedURLsText is the stored URL list of a CSV run: every URL tagged
+// with its profile, so the run page groups pages by profile.
+func groupedURLsText(groups []ProfileURLGroup) string {
+	var b strings.Builder
+	for _, g := range groups {
+		tag := strings.Join(strings.Fields(g.ProfileName), "-")
+		for _, u := range g.URLs {
+			fmt.Fprintf(&b, "%s # tag:%s\n", u, tag)
+		}
+	}
+	return b.String()
+}
+
+// mergeGroupReports joins the reports of checks over disjoint URL groups
+// into one, browser by browser, in group order.
+func mergeGroupReports(parts []MultiReport) MultiReport {
+	merged := MultiReport{GeneratedAt: parts[0].GeneratedAt, Browsers: map[string]Report{}}
+	byBrowser := map[string][]Report{}
+	var names []string
+	for _, part := range parts {
+		for name, rep := range part.Browsers {
+			if _, ok := byBrowser[name]; !ok {
+				names = append(names, name)
+			}
+			byBrowser[name] = append(byBrowser[name], rep)
+		}
+		merged.Flaky = append(merged.Flaky, part.Flaky...)
+		merged.ThresholdExceeded = merged.ThresholdExceeded || part.ThresholdExceeded
+	}
+	for _, name := range names {
+		reps := byBrowser[name]
+		rep := mergeReports(reps, true)
+		var errs []string
+		for _, r := range reps {
+			if r.Error != "" && !containsString(errs, r.Error) {
+				errs = append(errs, r.Error)
+			}
+		}
+		rep.Error = strings.Join(errs, "; ")
+		merged.Browsers[name] = rep
+	}
+	return merged
+}
+
+// executeGroupedRun checks each group under its own profile and stores the
+// results as one run. The run belongs to a profile only when every URL
+// used the same one.
+func (s *Server) executeGroupedRun(ctx context.Context, groups []ProfileURLGroup) (int64, error) {
+	type resolved struct {
+		urls []string
+		cfg  CSPConfig
+	}
+	var checks []resolved
+	var runProfile sql.NullInt64
+	profiles := map[string]string{}
+	for _, g := range groups {
+		p, err := s.getProfileByName(ctx, g.ProfileName)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return 0, fmt.Errorf("%w: %q", errUnknownProfile, g.ProfileName)
+			}
+			return 0, err
+		}
+		profileID, cfg, err := s.resolveConfig(ctx, sql.NullInt64{Int64: p.ID, Valid: true})
+		if err != nil {
+			return 0, err
+		}
+		if len(groups) == 1 {
+			runProfile = profileID
+		}
+		checks = append(checks, resolved{urls: g.URLs, cfg: cfg})
+		for _, u := range g.URLs {
+			profiles[u] = p.Name
+		}
+	}
+	return s.storeCheckedRun(ctx, runProfile, groupedURLsText(groups), func(ctx context.Context, _ []string) (MultiReport, int, error) {
+		parts := make([]MultiReport, 0, len(checks))
+		exitCode := 0
+		for _, c := range checks {
+			part, code, err := s.checkURLs(ctx, c.urls, c.cfg)
+			if err != nil {
+				return MultiReport{}, 0, err
+			}
+			if code > exitCode {
+				exitCode = code
+			}
+			parts = append(parts, part)
+		}
+		merged := mergeGroupReports(parts)
+		merged.Config = map[string]any{"profiles": profiles}
+		return merged, exitCode, nil
+	})
+}
+
+// handleRunCSV runs an uploaded url,profileName CSV as one run, each URL
+// under its own profile.
+func (s *Server) handleRunCSV(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.maxURLsBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(s.maxURLsBytes)+urlsFormSlack)
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, fmt.Sprintf("csv exceeds %d bytes", s.maxURLsBytes), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "csv file required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	groups, err := parseProfileCSV(file)
+	if err != nil {
+		http.Error(w, "invalid csv: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(groups) == 0 {
+		http.Error(w, "urls required", http.StatusBadRequest)
+		return
+	}
+	runID, err := s.executeGroupedRun(r.Context(), groups)
+	if err != nil {
+		http.Error(w, err.Error(), runErrorStatus(err))
+		return
+	}
+	s.auditLog(r.Context(), "run.created", strconv.FormatInt(runID, 10), s.auditUser(r))
+	if label := strings.TrimSpace(r.FormValue("label")); label != "" {
+		if err := s.setRunLabel(r.Context(), runID, label); err != nil {
+			log.Printf("run %d: label: %v", runID, err)
+		}
+	}
+	http.Redirect(w, r, fmt.Sprintf("/runs/%d", runID), http.StatusSeeOther)
+}
+
+const maxURLListBytes = 1 << 20
+
+// fetchURLList downloads a text/plain URL list from source. The caller's
+// client decides which hosts may be reached; see newGuardedClient.
+func fetchURLList(ctx context.Context, client *http.Client, source string) (string, error) {
+	parsed, err := url.Parse(source)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", errors.New("source must be an http or https URL")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "csp-web-url-list")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(strings.ToLower(ct), "text/plain") {
+		return "", fmt.Errorf("unexpected content type %q, want text/plain", ct)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxURLListBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if len(body) > maxURLListBytes {
+		return "", fmt.Errorf("url list exceeds %d bytes", maxURLListBytes)
+	}
+	return string(body), nil
+}
+
+// newGuardedClient returns an HTTP client that refuses to connect to
+// loopback, private, link-local, and other non-public addresses. The check
+// runs at dial time, after DNS resolution, so rebinding tricks are covered.
+func newGuardedClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+				return fmt.Errorf("refusing to connect to non-public address %s", host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast()
+}
+
+func (s *Server) handleRunRerun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+	idStr := strings.TrimSpace(r.FormValue("id"))
+	if idStr == "" {
+		http.Error(w, "id required", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	prev, err := s.getRun(r.Context(), id)
+	if err != nil {
+		http.Error(w, "run not found", http.StatusNotFound)
+		return
+	}
+
+	profileID := prev.ProfileID
+	if v := parseProfileID(r.FormValue("profile_id")); v.Valid {
+		profileID = v
+	}
+	profileID, cfg, err := s.resolveConfig(r.Context(), profileID)
+	if err != nil {
+		http.Error(w, err.Error(), runErrorStatus(err))
+		return
+	}
+	runID, err := s.executeRun(r.Context(), profileID, prev.URLsText, cfg)
+	if err != nil {
+		http.Error(w, err.Error(), runErrorStatus(err))
+		return
+	}
+	s.auditLog(r.Context(), "run.created", strconv.FormatInt(runID, 10), s.auditUser(r))
+
+	http.Redirect(w, r, fmt.Sprintf("/runs/%d", runID), http.StatusSeeOther)
+}
+
+// handleRecheckTimeouts reruns only the pages of a run whose navigation timed
+// out, with the run's profile config given twice the time. The profile
+// itself is left unchanged.
+func (s *Server) handleRecheckTimeouts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimSpace(r.FormValue("id")), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	prev, err := s.getRun(r.Context(), id)
+	if err != nil {
+		http.Error(w, "run not found", http.StatusNotFound)
+		return
+	}
+	multi, err := loadMultiReport(prev)
+	if err != nil {
+		http.Error(w, "run parse failed", http.StatusInternalServerError)
+		return
+	}
+	urls := timedOutURLs(multi)
+	if len(urls) == 0 {
+		http.Error(w, "run has no timed-out pages", http.StatusBadRequest)
+		return
+	}
+
+	profileID, cfg, err := s.resolveConfig(r.Context(), prev.ProfileID)
+	if err != nil {
+		http.Error(w, err.Error(), runErrorStatus(err))
+		return
+	}
+	runID, err := s.executeRun(r.Context(), profileID, strings.Join(urls, "\n"), withLongerTimeouts(cfg))
+	if err != nil {
+		http.Error(w, err.Error(), runErrorStatus(err))
+		return
+	}
+	s.auditLog(r.Context(), "run.created", strconv.FormatInt(runID, 10), s.auditUser(r))
+	http.Redirect(w, r, fmt.Sprintf("/runs/%d", runID), http.StatusSeeOther)
+}
+
+// timedOutURLs lists, once each, the pages that timed out in any browser.
+func timedOutURLs(multi MultiReport) []string {
+	seen := make(map[string]bool)
+	var urls []string
+	for _, b := range browsers {
+		for _, res := range multi.Browsers[b].Results {
+			if res.TimedOut() && !seen[res.URL] {
+				seen[res.URL] = true
+				urls = append(urls, res.URL)
+			}
+		}
+	}
+	return urls
+}
+
+// Caps on the timeouts withLongerTimeouts retries with.
+const (
+	maxRetryNavTimeoutMs = 5 * 60 * 1000
+	maxRetrySettleWaitMs = 60 * 1000
+)
+
+// withLongerTimeouts doubles the navigation timeout and settle wait the run
+// actually used, TimeoutMultiplier included, up to maxRetryNavTimeoutMs and
+// maxRetrySettleWaitMs. A profile already above a cap keeps its own value.
+func withLongerTimeouts(cfg CSPConfig) CSPConfig {
+	longer := func(ms, limit int) int {
+		if ms >= limit {
+			return ms
+		}
+		if ms*2 > limit {
+			return limit
+		}
+		return ms * 2
+	}
+	navMs, settleMs := cfg.effectiveTimeouts()
+	cfg.NavTimeoutMs = longer(navMs, maxRetryNavTimeoutMs)
+	cfg.SettleWaitMs = longer(settleMs, maxRetrySettleWaitMs)
+	cfg.TimeoutMultiplier = 0
+	return cfg
+}
+
+func (s *Server) handleRunDetail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	idStr := strings.TrimPrefix(r.URL.Path, "/runs/")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	run, err := s.getRun(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "run load failed", http.StatusInternalServerError)
+		return
+	}
+	multi, err := loadMultiReport(run)
+	if err != nil {
+		http.Error(w, "run parse failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+	if prefersJSON(r.Header.Get("Accept")) {
+		writeJSON(w, http.StatusOK, multi)
+		return
+	}
+
+	browserReports := buildBrowserReports(multi)
+	browserNames := make([]string, 0, len(browserReports))
+	for _, b := range browserReports {
+		browserNames = append(browserNames, b.Name)
+	}
+	// browser=<name> shows only that browser's results; everything below,
+	// merged groups included, then works on just that report.
+	selectedBrowser := strings.TrimSpace(r.URL.Query().Get("browser"))
+	if selectedBrowser != "" {
+		var only []BrowserReport
+		for _, b := range browserReports {
+			if b.Name == selectedBrowser {
+				only = append(only, b)
+			}
+		}
+		if len(only) == 0 {
+			http.Error(w, "browser not in run", http.StatusNotFound)
+			return
+		}
+		browserReports = only
+	}
+
+	var unsettled, failed []string
+	excluded := 0
+	for _, b := range browserReports {
+		if b.Report.Error != "" {
+			failed = append(failed, b.Report.Error)
+			continue
+		}
+		if !b.Settled {
+			unsettled = append(unsettled, b.Name)
+		}
+		if b.Excluded > excluded {
+			excluded = b.Excluded
+		}
+	}
+
+	profiles, _ := s.listProfiles(r.Context(), false)
+	fps, err := s.falsePositives(r.Context(), run.ProfileID)
+	if err != nil {
+		log.Printf("run %d: false positives: %v", run.ID, err)
+	}
+	isBaseline := false
+	if run.ProfileID.Valid {
+		if id, err := s.baselineRunID(r.Context(), run.ProfileID.Int64); err == nil {
+			isBaseline = id == run.ID
+		}
+	}
+
+	// disposition=all groups enforce and report-only violations together;
+	// each group then shows its split.
+	allDispositions := r.URL.Query().Get("disposition") == "all"
+	// normalizeScheme=1 groups http:// and https:// (or ws:// and wss://)
+	// copies of an origin together; each group then shows its scheme mix.
+	normalizeScheme := r.URL.Query().Get("normalizeScheme") == "1"
+	var mergedErr, mergedWarn []MergedGroup
+	if allDispositions {
+		mergedErr = groupViolationsMulti(browserReports, normalizeScheme)
+		for i := range browserReports {
+			browserReports[i].Groups = groupViolations(browserReports[i].analyzed(), normalizeScheme)
+			browserReports[i].Warns = nil
+		}
+	} else {
+		mergedErr = groupViolationsMultiByDisposition(browserReports, "enforce", normalizeScheme)
+		mergedWarn = groupViolationsMultiByDisposition(browserReports, "report-only", normalizeScheme)
+		if normalizeScheme {
+			for i := range browserReports {
+				browserReports[i].Groups = groupViolationsByDisposition(browserReports[i].analyzed(), "enforce", true)
+				browserReports[i].Warns = groupViolationsByDisposition(browserReports[i].analyzed(), "report-only", true)
+			}
+		}
+	}
+	// Split cards cannot see the other disposition, so mixed enforcement is
+	// decided on the unsplit groups.
+	mixed := map[string]bool{}
+	for _, g := range groupViolationsMulti(browserReports, normalizeScheme) {
+		if g.Group.MixedEnforcement() {
+			mixed[g.Group.Key] = true
+		}
+	}
+	sourceBlame := map[string][]SourceFileGroup{}
+	for _, b := range browserReports {
+		if groups := groupBySourceFile(b.Report.Results); len(groups) > 0 {
+			sourceBlame[b.Name] = groups
+		}
+	}
+	consensus := r.URL.Query().Get("consensus") == "1"
+	if consensus {
+		mergedErr = consensusGroups(mergedErr, browserReports)
+		mergedWarn = consensusGroups(mergedWarn, browserReports)
+	}
+
+	s.render(w, "run.html", map[string]any{
+		"Run":                  run,
+		"Browsers":             browserReports,
+		"BrowserNames":         browserNames,
+		"SelectedBrowser":      selectedBrowser,
+		"MergedErr":            mergedErr,
+		"MergedWarn":           mergedWarn,
+		"Consensus":            consensus,
+		"AllDispositions":      allDispositions,
+		"NormalizeScheme":      normalizeScheme,
+		"MixedEnforcement":     mixed,
+		"FalsePositives":       fps,
+		"IsBaseline":           isBaseline,
+		"Profiles":             profiles,
+		"Unsettled":            unsettled,
+		"Excluded":             excluded,
+		"Failed":               failed,
+		"NoCSP":                pagesWithoutCSP(multi),
+		"Flaky":                multi.Flaky,
+		"PromotionRisk":        promotionRisk(browserReports),
+		"SourceBlame":          sourceBlame,
+		"TagGroups":            groupPagesByTag(run.URLsText, multi),
+		"Expectations":         pageExpectations(run.URLsText, multi),
+		"CompareCandidates":    s.compareCandidates(r.Context(), run),
+		"ThresholdExceeded":    multi.ThresholdExceeded,
+		"MaxViolationsPerPage": multi.Config["maxViolationsPerPage"],
+	})
+}
+
+// prefersJSON reports whether an Accept header ranks application/json above
+// text/html. As in HTTP, each type takes the q-value of the most specific
+// range matching it (type/subtype, then type/*, then */*). An empty header
+// or a tie means HTML.
+func prefersJSON(accept string) bool {
+	type match struct {
+		specificity int
+		q           float64
+	}
+	var jsonQ, htmlQ match
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		for _, t := range []struct {
+			name string
+			m    *match
+		}{{"application/json", &jsonQ}, {"text/html", &htmlQ}} {
+			specificity := 0
+			switch mediaType {
+			case t.name:
+				specificity = 3
+			case strings.SplitN(t.name, "/", 2)[0] + "/*":
+				specificity = 2
+			case "*/*":
+				specificity = 1
+			}
+			if specificity > t.m.specificity {
+				*t.m = match{specificity, q}
+			}
+		}
+	}
+	return jsonQ.q > htmlQ.q
+}
+
+// buildBrowserReports groups each browser's violations, in the usual browser
+// order followed by any unexpected browser keys.
+func buildBrowserReports(multi MultiReport) []BrowserReport {
+	patterns := excludePatterns(multi)
+	newReport := func(name string, rep Report) BrowserReport {
+		b := BrowserReport{Name: name, Report: rep, Settled: reportSettled(rep)}
+		results := rep.Results
+		if len(patterns) > 0 {
+			b.Analyzed = filterExcluded(rep.Results, patterns)
+			b.Excluded = len(rep.Results) - len(b.Analyzed)
+			results = b.Analyzed
+		}
+		b.Groups = groupViolationsByDisposition(results, "enforce", false)
+		b.Warns = groupViolationsByDisposition(results, "report-only", false)
+		return b
+	}
+
+	var browserReports []BrowserReport
+	for _, name := range browsers {
+		if rep, ok := multi.Browsers[name]; ok {
+			browserReports = append(browserReports, newReport(name, rep))
+		}
+	}
+	// Include any unexpected browser keys
+	for name, rep := range multi.Browsers {
+		found := false
+		for _, b := range browserReports {
+			if b.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			browserReports = append(browserReports, newReport(name, rep))
+		}
+	}
+	return browserReports
+}
+
+// excludePatterns returns the exclude patterns recorded with a run.
+func excludePatterns(multi MultiReport) []string {
+	raw, _ := multi.Config["excludePatterns"].([]any)
+	var out []string
+	for _, v := range raw {
+		if p, ok := v.(string); ok && p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// filterExcluded drops results whose URL matches one of patterns.
+func filterExcluded(results []ReportPageResult, patterns []string) []ReportPageResult {
+	out := make([]ReportPageResult, 0, len(results))
+	for _, r := range results {
+		if !matchesExclude(r.URL, patterns) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// matchesExclude reports whether rawURL matches any pattern. Patterns starting
+// with "/" are compared with the URL path, others with the full URL. A pattern
+// containing * (any run of characters) or ? (one character) must match
+// completely; any other pattern is a prefix.
+func matchesExclude(rawURL string, patterns []string) bool {
+	target := rawURL
+	var path string
+	if u, err := url.Parse(rawURL); err == nil {
+		path = u.Path
+		if u.RawQuery != "" {
+			path += "?" + u.RawQuery
+		}
+	}
+	for _, p := range patterns {
+		subject := target
+		if strings.HasPrefix(p, "/") {
+			subject = path
+		}
+		if strings.ContainsAny(p, "*?") {
+			if globMatch(p, subject) {
+				return true
+			}
+		} else if strings.HasPrefix(subject, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// globMatch matches s against a pattern where * matches any run of
+// characters, including "/", and ? matches exactly one.
+func globMatch(pattern, s string) bool {
+	if pattern == "" {
+		return s == ""
+	}
+	switch pattern[0] {
+	case '*':
+		for i := 0; i <= len(s); i++ {
+			if globMatch(pattern[1:], s[i:]) {
+				return true
+			}
+		}
+		return false
+	case '?':
+		return s != "" && globMatch(pattern[1:], s[1:])
+	default:
+		return s != "" && s[0] == pattern[0] && globMatch(pattern[1:], s[1:])
+	}
+}
+
+// RunDiff lists violation group keys that appear in a run but not its
+// baseline (New) and the reverse (Resolved), across all browsers.
+type RunDiff struct {
+	New      []string `json:"new"`
+	Resolved []string `json:"resolved"`
+}
+
+// diffRuns compares the violation groups of two runs.
+func diffRuns(base, other MultiReport) RunDiff {
+	baseKeys := violationKeys(base)
+	otherKeys := violationKeys(other)
+	var d RunDiff
+	for k := range otherKeys {
+		if !baseKeys[k] {
+			d.New = append(d.New, k)
+		}
+	}
+	for k := range baseKeys {
+		if !otherKeys[k] {
+			d.Resolved = append(d.Resolved, k)
+		}
+	}
+	sort.Strings(d.New)
+	sort.Strings(d.Resolved)
+	return d
+}
+
+func violationKeys(multi MultiReport) map[string]bool {
+	keys := map[string]bool{}
+	for _, rep := range multi.Browsers {
+		for _, g := range groupViolations(rep.Results, false) {
+			keys[g.Key] = true
+		}
+	}
+	return keys
+}
+
+// maxCompareCandidates caps the runs offered for comparison on a run page.
+const maxCompareCandidates = 20
+
+// compareCandidates lists the most recent runs to diff run against, newest
+// first: checked runs of the same profile, or any checked runs when run has
+// no profile. Failed runs have no results to diff.
+func (s *Server) compareCandidates(ctx context.Context, run Run) []Run {
+	where, args := `id <> ? AND exit_code <> ?`, []any{run.ID, failedRunExitCode}
+	if run.ProfileID.Valid {
+		where, args = where+` AND profile_id = ?`, append(args, run.ProfileID.Int64)
+	}
+	runs, err := s.listRuns(ctx, where, args...)
+	if err != nil {
+		log.Printf("run %d: compare candidates: %v", run.ID, err)
+		return nil
+	}
+	if len(runs) > maxCompareCandidates {
+		runs = runs[:maxCompareCandidates]
+	}
+	return runs
+}
+
+// handleRunDiff shows the violation groups that run other added and
+// resolved compared with run base.
+func (s *Server) handleRunDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var loaded [2]Run
+	var multis [2]MultiReport
+	for i, param := range []string{"base", "other"} {
+		id, err := strconv.ParseInt(strings.TrimSpace(r.URL.Query().Get(param)), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid "+param, http.StatusBadRequest)
+			return
+		}
+		run, err := s.getRun(r.Context(), id)
+		if err != nil {
+			http.Error(w, "run not found", http.StatusNotFound)
+			return
+		}
+		multi, err := loadMultiReport(run)
+		if err != nil {
+			http.Error(w, "run parse failed", http.StatusInternalServerError)
+			return
+		}
+		loaded[i], multis[i] = run, multi
+	}
+	s.render(w, "diff.html", map[string]any{
+		"Base":  loaded[0],
+		"Other": loaded[1],
+		"Diff":  diffRuns(multis[0], multis[1]),
+	})
+}
+
+// RegressionBadge summarises a run's diff against its profile's baseline.
+type RegressionBadge struct {
+	BaselineRunID int64
+	New           int
+	Resolved      int
+}
+
+type badgeKey struct {
+	runID, baselineID int64
+}
+
+// regressionBadges diffs each run against its profile's baseline, keyed by
+// run ID. Baselines are loaded once for the whole list; runs without a
+// profile, whose profile has no baseline, or that are the baseline get no
+// badge.
+func (s *Server) regressionBadges(ctx context.Context, runs []Run) map[int64]*RegressionBadge {
+	badges := map[int64]*RegressionBadge{}
+	baselines, err := s.baselineRunIDs(ctx)
+	if err != nil {
+		return badges
+	}
+	baseReports := map[int64]*MultiReport{}
+	for _, run := range runs {
+		if !run.ProfileID.Valid {
+			continue
+		}
+		baselineID, ok := baselines[run.ProfileID.Int64]
+		if !ok || baselineID == run.ID {
+			continue
+		}
+		key := badgeKey{run.ID, baselineID}
+		s.badgeMu.Lock()
+		badge, ok := s.badgeCache[key]
+		s.badgeMu.Unlock()
+		if ok {
+			badges[run.ID] = &badge
+			continue
+		}
+
+		baseMulti, ok := baseReports[baselineID]
+		if !ok {
+			if baseRun, err := s.getRun(ctx, baselineID); err == nil {
+				if m, err := loadMultiReport(baseRun); err == nil {
+					baseMulti = &m
+				}
+			}
+			baseReports[baselineID] = baseMulti
+		}
+		if baseMulti == nil {
+			continue
+		}
+		multi, err := loadMultiReport(run)
+		if err != nil {
+			continue
+		}
+		d := diffRuns(*baseMulti, multi)
+		badge = RegressionBadge{BaselineRunID: baselineID, New: len(d.New), Resolved: len(d.Resolved)}
+
+		s.badgeMu.Lock()
+		if s.badgeCache == nil {
+			s.badgeCache = map[badgeKey]RegressionBadge{}
+		}
+		s.badgeCache[key] = badge
+		s.badgeMu.Unlock()
+		badges[run.ID] = &badge
+	}
+	return badges
+}
+
+func (s *Server) handleSetBaseline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimSpace(r.FormValue("run_id")), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid run id", http.StatusBadRequest)
+		return
+	}
+	run, err := s.getRun(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "run load failed", http.StatusInternalServerError)
+		return
+	}
+	if !run.ProfileID.Valid {
+		http.Error(w, "run has no profile", http.StatusBadRequest)
+		return
+	}
+	if err := s.setBaseline(r.Context(), run.ProfileID.Int64, run.ID); err != nil {
+		http.Error(w, "baseline update failed", http.StatusInternalServerError)
+		return
+	}
+	s.auditLog(r.Context(), "run.baseline_set", strconv.FormatInt(run.ID, 10), s.auditUser(r))
+	http.Redirect(w, r, fmt.Sprintf("/runs/%d", run.ID), http.StatusSeeOther)
+}
+
+// AnalysisGroup is the JSON form of a merged violation group.
+type AnalysisGroup struct {
+	Key                string   `json:"key"`
+	EffectiveDirective string   `json:"effectiveDirective"`
+	BlockedOrigin      string   `json:"blockedOrigin"`
+	Disposition        string   `json:"disposition,omitempty"`
+	Count              int      `json:"count"`
+	Browsers           []string `json:"browsers"`
+	Pages              []string `json:"pages"`
+}
+
+// RunAnalysis is the machine-readable twin of the run detail page.
+// CrossBrowser lists groups that some, but not all, browsers reported.
+type RunAnalysis struct {
+	RunID        int64           `json:"runId"`
+	Browsers     []string        `json:"browsers"`
+	Enforce      []AnalysisGroup `json:"enforce"`
+	ReportOnly   []AnalysisGroup `json:"reportOnly"`
+	CrossBrowser []AnalysisGroup `json:"crossBrowser"`
+}
+
+func analyzeRun(run Run, multi MultiReport) RunAnalysis {
+	browserReports := buildBrowserReports(multi)
+	out := RunAnalysis{
+		RunID:        run.ID,
+		Browsers:     []string{},
+		Enforce:      []AnalysisGroup{},
+		ReportOnly:   []AnalysisGroup{},
+		CrossBrowser: []AnalysisGroup{},
+	}
+	for _, b := range browserReports {
+		out.Browsers = append(out.Browsers, b.Name)
+	}
+	for _, d := range []struct {
+		disposition string
+		dst         *[]AnalysisGroup
+	}{
+		{"enforce", &out.Enforce},
+		{"report-only", &out.ReportOnly},
+	} {
+		for _, g := range groupViolationsMultiByDisposition(browserReports, d.disposition, false) {
+			ag := analysisGroup(g)
+			*d.dst = append(*d.dst, ag)
+			if len(g.Browsers) < len(browserReports) {
+				ag.Disposition = d.disposition
+				out.CrossBrowser = append(out.CrossBrowser, ag)
+			}
+		}
+	}
+	return out
+}
+
+func analysisGroup(g MergedGroup) AnalysisGroup {
+	pages := make([]string, 0, len(g.Group.Pages))
+	for page := range g.Group.Pages {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+	return AnalysisGroup{
+		Key:                g.Group.Key,
+		EffectiveDirective: g.Group.EffectiveDirective,
+		BlockedOrigin:      g.Group.BlockedOrigin,
+		Count:              g.Group.Count,
+		Browsers:           g.Browsers,
+		Pages:              pages,
+	}
+}
+
+// OriginViolations is the /api/runs/{id}/by-origin response.
+type OriginViolations struct {
+	RunID  int64           `json:"runId"`
+	Origin string          `json:"origin"`
+	Groups []AnalysisGroup `json:"groups"`
+}
+
+// violationsByOrigin returns the run's violation groups, enforce then
+// report-only, whose groupOrigin matches origin; see originMatches. The
+// synthetic labels, such as "(data: URI)", match themselves.
+func violationsByOrigin(multi MultiReport, origin string) []AnalysisGroup {
+	filtered := multi
+	filtered.Browsers = make(map[string]Report, len(multi.Browsers))
+	for name, rep := range multi.Browsers {
+		results := make([]ReportPageResult, 0, len(rep.Results))
+		for _, r := range rep.Results {
+			var vs []Violation
+			for _, v := range r.Violations {
+				if originMatches(groupOrigin(v), origin) {
+					vs = append(vs, v)
+				}
+			}
+			r.Violations = vs
+			results = append(results, r)
+		}
+		rep.Results = results
+		filtered.Browsers[name] = rep
+	}
+	browserReports := buildBrowserReports(filtered)
+	out := []AnalysisGroup{}
+	for _, disposition := range []string{"enforce", "report-only"} {
+		for _, g := range groupViolationsMultiByDisposition(browserReports, disposition, false) {
+			ag := analysisGroup(g)
+			ag.Disposition = disposition
+			out = append(out, ag)
+		}
+	}
+	return out
+}
+
+// originMatches reports whether a blocked origin is want's host or one of
+// its subdomains, so "cdn.example.com" matches https://cdn.example.com and
+// https://eu.cdn.example.com. want may carry a scheme, which must then match
+// too.
+func originMatches(blocked, want string) bool {
+	host := func(s string) (scheme, host string) {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if u, err := url.Parse(s); err == nil && u.Host != "" {
+			return u.Scheme, u.Hostname()
+		}
+		return "", strings.TrimSuffix(s, "/")
+	}
+	blockedScheme, blockedHost := host(blocked)
+	wantScheme, wantHost := host(want)
+	if blockedHost == "" || wantHost == "" || (wantScheme != "" && wantScheme != blockedScheme) {
+		return false
+	}
+	return blockedHost == wantHost || strings.HasSuffix(blockedHost, "."+wantHost)
+}
+
+// handleAPIRun serves /api/runs/{id}/... endpoints.
+func (s *Server) handleAPIRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/runs/"), "/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	run, err := s.getRun(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "run load failed", http.StatusInternalServerError)
+		return
+	}
+	multi, err := loadMultiReport(run)
+	if err != nil {
+		http.Error(w, "run parse failed", http.StatusInternalServerError)
+		return
+	}
+	switch parts[1] {
+	case "analysis":
+		writeJSON(w, http.StatusOK, analyzeRun(run, multi))
+	case "raw":
+		name := strings.TrimSpace(r.URL.Query().Get("browser"))
+		if name == "" {
+			name = "chromium"
+		}
+		raw, ok := rawBrowserReport(run.ResultsJSON, name)
+		if !ok {
+			http.Error(w, "browser not in run", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(raw)
+	case "by-origin":
+		origin := strings.TrimSpace(r.URL.Query().Get("origin"))
+		if origin == "" {
+			http.Error(w, "origin required", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, OriginViolations{RunID: run.ID, Origin: origin, Groups: violationsByOrigin(multi, origin)})
+	case "tree":
+		writeJSON(w, http.StatusOK, buildViolationTree(multi))
+	case "vs-baseline":
+		if !run.ProfileID.Valid {
+			http.Error(w, "run has no profile, so no baseline", http.StatusConflict)
+			return
+		}
+		baselineID, err := s.baselineRunID(r.Context(), run.ProfileID.Int64)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "no baseline set for this run's profile", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "baseline load failed", http.StatusInternalServerError)
+			return
+		}
+		baseRun, err := s.getRun(r.Context(), baselineID)
+		if err != nil {
+			http.Error(w, "baseline load failed", http.StatusInternalServerError)
+			return
+		}
+		baseMulti, err := loadMultiReport(baseRun)
+		if err != nil {
+			http.Error(w, "baseline parse failed", http.StatusInternalServerError)
+			return
+		}
+		d := diffRuns(baseMulti, multi)
+		if d.New == nil {
+			d.New = []string{}
+		}
+		if d.Resolved == nil {
+			d.Resolved = []string{}
+		}
+		writeJSON(w, http.StatusOK, d)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// rawBrowserReport returns browser's report from resultsJSON byte for byte,
+// including fields Report does not model. Single-report results from before
+// multi-browser runs are chromium's.
+func rawBrowserReport(resultsJSON, browser string) (json.RawMessage, bool) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(resultsJSON), &top); err != nil {
+		return nil, false
+	}
+	if rawBrowsers, ok := top["browsers"]; ok {
+		var byBrowser map[string]json.RawMessage
+		if err := json.Unmarshal(rawBrowsers, &byBrowser); err != nil {
+			return nil, false
+		}
+		raw, ok := byBrowser[browser]
+		return raw, ok
+	}
+	if browser != "chromium" {
+		return nil, false
+	}
+	return json.RawMessage(resultsJSON), true
+}
+
+// loadMultiReport decodes a run's stored results. Runs saved before multi-browser
+// support hold a single chromium Report, which is wrapped to look the same.
+func loadMultiReport(run Run) (MultiReport, error) {
+	if err := loadSpilledResults(&run); err != nil {
+		return MultiReport{}, err
+	}
+	var multi MultiReport
+	if err := json.Unmarshal([]byte(run.ResultsJSON), &multi); err == nil && len(multi.Browsers) > 0 {
+		return multi, nil
+	}
+	var single Report
+	if err := json.Unmarshal([]byte(run.ResultsJSON), &single); err != nil {
+		return MultiReport{}, err
+	}
+	return MultiReport{
+		GeneratedAt: single.GeneratedAt,
+		Config:      single.Config,
+		Browsers:    map[string]Report{"chromium": single},
+	}, nil
+}
+
+func (s *Server) handleDocs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.render(w, "docs.html", map[string]any{})
+}
+
+func (s *Server) handleRunExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	idStr := strings.TrimSpace(r.URL.Query().Get("id"))
+	pretty := strings.TrimSpace(r.URL.Query().Get("pretty")) == "1"
+	naming := strings.TrimSpace(r.URL.Query().Get("naming"))
+	if idStr == "" {
+		http.Error(w, "id required", http.StatusBadRequest)
+		return
+	}
+	if naming != "" && naming != "camel" && naming != "snake" {
+		http.Error(w, "invalid naming", http.StatusBadRequest)
+		return
+	}
+	sortMode := strings.TrimSpace(r.URL.Query().Get("sort"))
+	if sortMode != "" && sortMode != "url" && sortMode != "violations" {
+		http.Error(w, "invalid sort", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	run, err := s.getRun(r.Context(), id)
+	if err != nil {
+		http.Error(w, "run not found", http.StatusNotFound)
+		return
+	}
+	if r.URL.Query().Get("format") == "repro" {
+		multi, err := loadMultiReport(run)
+		if err != nil {
+			http.Error(w, "run parse failed", http.StatusInternalServerError)
+			return
+		}
+		_, cfg, err := s.resolveConfig(r.Context(), run.ProfileID)
+		if err != nil {
+			http.Error(w, err.Error(), runErrorStatus(err))
+			return
+		}
+		w.Header().Set("Content-Type", "text/x-shellscript; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", strings.TrimSuffix(exportFilename(run), ".json")+"-repro.sh"))
+		_, _ = io.WriteString(w, buildReproScript(run, recordedConfig(multi, cfg)))
+		return
+	}
+	if r.URL.Query().Get("format") == "policy" {
+		disposition := r.URL.Query().Get("disposition")
+		if disposition != "" && disposition != "enforce" && disposition != "all" {
+			http.Error(w, "invalid disposition", http.StatusBadRequest)
+			return
+		}
+		multi, err := loadMultiReport(run)
+		if err != nil {
+			http.Error(w, "run parse failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.WriteString(w, "Content-Security-Policy: "+suggestedRunPolicy(multi, disposition == "all")+"\n")
+		return
+	}
+	if r.URL.Query().Get("format") == "gob" {
+		multi, err := loadMultiReport(run)
+		if err != nil {
+			http.Error(w, "run parse failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-gob")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", strings.TrimSuffix(exportFilename(run), ".json")+".gob"))
+		if err := encodeReportGob(w, multi); err != nil {
+			log.Printf("gob export run %d: %v", run.ID, err)
+		}
+		return
+	}
+	if r.URL.Query().Get("format") == "matrix-csv" {
+		multi, err := loadMultiReport(run)
+		if err != nil {
+			http.Error(w, "run parse failed", http.StatusInternalServerError)
+			return
+		}
+		headers, rows := buildPivot(multi)
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", strings.TrimSuffix(exportFilename(run), ".json")+"-matrix.csv"))
+		cw := csv.NewWriter(w)
+		_ = cw.Write(headers)
+		_ = cw.WriteAll(rows)
+		return
+	}
+	resultsJSON := run.ResultsJSON
+	if sortMode != "" {
+		multi, err := loadMultiReport(run)
+		if err != nil {
+			http.Error(w, "run parse failed", http.StatusInternalServerError)
+			return
+		}
+		for name, rep := range multi.Browsers {
+			sortResults(rep.Results, sortMode)
+			multi.Browsers[name] = rep
+		}
+		b, err := json.Marshal(multi)
+		if err != nil {
+			http.Error(w, "marshal results failed", http.StatusInternalServerError)
+			return
+		}
+		resultsJSON = string(b)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFilename(run)))
+	if !pretty && naming != "snake" {
+		_, _ = w.Write([]byte(resultsJSON))
+		return
+	}
+	var obj any
+	dec := json.NewDecoder(strings.NewReader(resultsJSON))
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil {
+		_, _ = w.Write([]byte(resultsJSON))
+		return
+	}
+	if naming == "snake" {
+		obj = snakeCaseKeys(obj)
+	}
+	var b []byte
+	if pretty {
+		b, err = json.MarshalIndent(obj, "", "  ")
+	} else {
+		b, err = json.Marshal(obj)
+	}
+	if err != nil {
+		_, _ = w.Write([]byte(resultsJSON))
+		return
+	}
+	_, _ = w.Write(b)
+}
+
+// sortResults orders results in place: "url" alphabetically by URL, then
+// device; "violations" by violation count, most first, ties by URL. Any other
+// mode leaves the stored order.
+func sortResults(results []ReportPageResult, mode string) {
+	byURL := func(a, b ReportPageResult) bool {
+		if a.URL != b.URL {
+			return a.URL < b.URL
+		}
+		return a.Device < b.Device
+	}
+	switch mode {
+	case "url":
+		sort.SliceStable(results, func(i, j int) bool { return byURL(results[i], results[j]) })
+	case "violations":
+		sort.SliceStable(results, func(i, j int) bool {
+			if len(results[i].Violations) != len(results[j].Violations) {
+				return len(results[i].Violations) > len(results[j].Violations)
+			}
+			return byURL(results[i], results[j])
+		})
+	}
+}
+
+// snakeCaseKeys rewrites the object keys of decoded JSON from camelCase to
+// snake_case, recursively. Only keys that look like field names are touched:
+// map keys that are data, such as header names or URLs, contain other
+// characters and pass through unchanged.
+func snakeCaseKeys(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for k, val := range t {
+			out[snakeCase(k)] = snakeCaseKeys(val)
+		}
+		return out
+	case []any:
+		for i, val := range t {
+			t[i] = snakeCaseKeys(val)
+		}
+		return t
+	default:
+		return v
+	}
+}
+
+// snakeCase converts a camelCase identifier such as "effectiveDirective" to
+// "effective_directive". Anything that is not a plain identifier starting
+// with a lowercase letter is returned as is.
+func snakeCase(key string) string {
+	if key == "" || key[0] < 'a' || key[0] > 'z' {
+		return key
+	}
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case c >= 'A' && c <= 'Z':
+			// Runs of capitals ("blockedURI") stay one word.
+			if key[i-1] < 'A' || key[i-1] > 'Z' {
+				b.WriteByte('_')
+			}
+			b.WriteByte(c + ('a' - 'A'))
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			b.WriteByte(c)
+		default:
+			return key
+		}
+	}
+	return b.String()
+}
+
+// recordedConfig overlays the settings a run recorded in its results on
+// base, so a reproduction uses what the run used even if the profile has
+// changed since. The pre-action count it records is not a config value and
+// is skipped.
+func recordedConfig(multi MultiReport, base CSPConfig) CSPConfig {
+	recorded := make(map[string]any, len(multi.Config))
+	for k, v := range multi.Config {
+		if k != "preActions" {
+			recorded[k] = v
+		}
+	}
+	b, err := json.Marshal(recorded)
+	if err != nil {
+		return base
+	}
+	cfg := base
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return base
+	}
+	return cfg
+}
+
+// buildReproScript returns a shell script that runs csp-check.mjs over the
+// run's URLs with its settings, once per browser and device. The basic auth
+// password and pre-navigation actions are never written out; placeholders
+// mark where they go.
+func buildReproScript(run Run, cfg CSPConfig) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#!/bin/sh\n# Reproduces csp-web run #%d (%s) with csp-check.mjs.\n", run.ID, run.CreatedAt)
+	b.WriteString("# Replace any <placeholder> values before running.\nset -e\n\n")
+	b.WriteString("cat > urls.txt <<'CSP_URLS'\n")
+	for _, u := range parseURLList(run.URLsText) {
+		b.WriteString(u + "\n")
+	}
+	b.WriteString("CSP_URLS\n\n")
+
+	pass := ""
+	if cfg.BasicAuthPass != "" || cfg.BasicAuthUser != "" {
+		pass = "<basic auth password>"
+	}
+	cfg.BasicAuthPass = pass
+	preActions := ""
+	if len(cfg.PreActions) > 0 {
+		preActions = fmt.Sprintf("<pre-navigation actions JSON, %d step(s), from the profile>", len(cfg.PreActions))
+	}
+	for _, kv := range configEnv(cfg, preActions) {
+		k, v, _ := strings.Cut(kv, "=")
+		fmt.Fprintf(&b, "export %s=%s\n", k, shellQuote(v))
+	}
+	b.WriteString("export CSP_OUTPUT_JSON=1\n\n")
+
+	args := ""
+	for _, a := range cfg.ExtraArgs {
+		args += " " + shellQuote(a)
+	}
+	devices := cfg.Devices
+	if len(devices) == 0 {
+		devices = []string{""}
+	}
+	for _, device := range devices {
+		env := ""
+		suffix := ""
+		if device != "" {
+			suffix = "-" + device
+			for _, kv := range deviceEnv(device) {
+				k, v, _ := strings.Cut(kv, "=")
+				env += k + "=" + shellQuote(v) + " "
+			}
+		}
+		fmt.Fprintf(&b, "for browser in %s; do\n", strings.Join(browsers, " "))
+		fmt.Fprintf(&b, "  %sCSP_BROWSER=\"$browser\" CSP_OUTPUT_FILE=\"report-$browser%s.json\" node csp-check.mjs urls.txt%s\n", env, suffix, args)
+		b.WriteString("done\n")
+	}
+	return b.String()
+}
+
+// shellQuote wraps s in single quotes for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func (s *Server) handleRunLabel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimSpace(r.FormValue("id")), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := s.setRunLabel(r.Context(), id, strings.TrimSpace(r.FormValue("label"))); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "label update failed", http.StatusInternalServerError)
+		return
+	}
+	s.auditLog(r.Context(), "run.labeled", strconv.FormatInt(id, 10), s.auditUser(r))
+	http.Redirect(w, r, fmt.Sprintf("/runs/%d", id), http.StatusSeeOther)
+}
+
+// handleRunPin pins a run, or unpins it unless pinned=1, so that profile
+// rotation keeps or may remove it.
+func (s *Server) handleRunPin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimSpace(r.FormValue("id")), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	pinned := r.FormValue("pinned") == "1"
+	if err := s.setRunPinned(r.Context(), id, pinned); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "pin update failed", http.StatusInternalServerError)
+		return
+	}
+	action := "run.unpinned"
+	if pinned {
+		action = "run.pinned"
+	}
+	s.auditLog(r.Context(), action, strconv.FormatInt(id, 10), s.auditUser(r))
+	http.Redirect(w, r, fmt.Sprintf("/runs/%d", id), http.StatusSeeOther)
+}
+
+// handleMarkFalsePositive marks one violation, by signature, as a false
+// positive for the run's profile, or clears the mark when clear=1.
+func (s *Server) handleMarkFalsePositive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimSpace(r.FormValue("run_id")), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid run id", http.StatusBadRequest)
+		return
+	}
+	signature := strings.TrimSpace(r.FormValue("signature"))
+	if signature == "" {
+		http.Error(w, "signature required", http.StatusBadRequest)
+		return
+	}
+	run, err := s.getRun(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "run load failed", http.StatusInternalServerError)
+		return
+	}
+	action := "false_positive.marked"
+	if r.FormValue("clear") == "1" {
+		action = "false_positive.cleared"
+		err = s.clearFalsePositive(r.Context(), run.ProfileID, signature)
+	} else {
+		reason := strings.TrimSpace(r.FormValue("reason"))
+		if reason == "" {
+			reason = "false positive"
+		}
+		err = s.markFalsePositive(r.Context(), run.ProfileID, signature, reason)
+	}
+	if err != nil {
+		http.Error(w, "false positive update failed", http.StatusInternalServerError)
+		return
+	}
+	s.auditLog(r.Context(), action, signature, s.auditUser(r))
+	http.Redirect(w, r, fmt.Sprintf("/runs/%d#violations", run.ID), http.StatusSeeOther)
+}
+
+// violationSignature identifies a single violation by all of its reported
+// fields, so the same violation in later runs gets the same signature.
+func violationSignature(v Violation) string {
+	line, col := "", ""
+	if v.LineNumber != nil {
+		line = strconv.Itoa(*v.LineNumber)
+	}
+	if v.ColumnNumber != nil {
+		col = strconv.Itoa(*v.ColumnNumber)
+	}
+	h := sha256.New()
+	for _, f := range []string{
+		v.DocumentURI, v.BlockedURI, v.EffectiveDirective, v.ViolatedDirective,
+		v.Disposition, v.SourceFile, line, col, v.Sample,
+	} {
+		h.Write([]byte(f))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// exportFilename names a run's JSON download: csp-<label>-<date>-<id>.json
+// for labelled runs, csp-run-<id>.json otherwise.
+func exportFilename(run Run) string {
+	label := sanitizeFilenamePart(run.Label)
+	if label == "" {
+		return fmt.Sprintf("csp-run-%d.json", run.ID)
+	}
+	date := run.CreatedAt
+	if t, err := time.Parse(time.RFC3339, run.CreatedAt); err == nil {
+		date = t.UTC().Format("2006-01-02")
+	}
+	date = sanitizeFilenamePart(date)
+	if date == "" {
+		return fmt.Sprintf("csp-%s-%d.json", label, run.ID)
+	}
+	return fmt.Sprintf("csp-%s-%s-%d.json", label, date, run.ID)
+}
+
+// sanitizeFilenamePart keeps ASCII letters, digits and underscores, turning
+// anything else (spaces, dots, path separators) into single dashes.
+func sanitizeFilenamePart(s string) string {
+	var b strings.Builder
+	for _, r := range strings.TrimSpace(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+	out := b.String()
+	for strings.Contains(out, "--") {
+		out = strings.ReplaceAll(out, "--", "-")
+	}
+	return strings.Trim(out, "-")
+}
+
+func (s *Server) handleRunCopy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+	idStr := strings.TrimSpace(r.FormValue("id"))
+	if idStr == "" {
+		http.Error(w, "id required", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	run, err := s.getRun(r.Context(), id)
+	if err != nil {
+		http.Error(w, "run not found", http.StatusNotFound)
+		return
+	}
+	http.Redirect(w, r, "/?urls="+url.QueryEscape(run.URLsText), http.StatusSeeOther)
+}
+
+func (s *Server) handleRunSnippet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rawURL := strings.TrimSpace(r.URL.Query().Get("url"))
+	if rawURL == "" {
+		http.Error(w, "url required", http.StatusBadRequest)
+		return
+	}
+	lineStr := strings.TrimSpace(r.URL.Query().Get("line"))
+	if lineStr == "" {
+		http.Error(w, "line required", http.StatusBadRequest)
+		return
+	}
+	lineNum, err := strconv.Atoi(lineStr)
+	if err != nil || lineNum <= 0 {
+		http.Error(w, "invalid line", http.StatusBadRequest)
+		return
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		http.Error(w, "invalid url", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		http.Error(w, "request failed", http.StatusBadRequest)
+		return
+	}
+	req.Header.Set("User-Agent", "csp-web-snippet")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		http.Error(w, "fetch failed", http.StatusBadRequest)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		http.Error(w, "fetch failed", http.StatusBadRequest)
+		return
+	}
+
+	const maxBytes = 1 << 20
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes))
+	if err != nil {
+		http.Error(w, "read failed", http.StatusBadRequest)
+		return
+	}
+	lines := strings.Split(string(body), "\n")
+	if lineNum > len(lines) {
+		http.Error(w, "line out of range", http.StatusBadRequest)
+		return
+	}
+
+	start := lineNum - 4
+	if start < 1 {
+		start = 1
+	}
+	end := lineNum + 3
+	if end > len(lines) {
+		end = len(lines)
+	}
+	var b strings.Builder
+	for i := start; i <= end; i++ {
+		fmt.Fprintf(&b, "%4d: %s\n", i, lines[i-1])
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"snippet.txt\"")
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// ExportManifestRun describes one run-<id>.json entry of the export archive.
+type ExportManifestRun struct {
+	ID        int64           `json:"id"`
+	File      string          `json:"file"`
+	ProfileID *int64          `json:"profileId"`
+	CreatedAt string          `json:"createdAt"`
+	Label     string          `json:"label,omitempty"`
+	URLs      []string        `json:"urls"`
+	ExitCode  int             `json:"exitCode"`
+	ElapsedMs int64           `json:"elapsedMs"`
+	Summary   json.RawMessage `json:"summary,omitempty"`
+}
+
+// handleAdminExportAll streams every stored run as run-<id>.json entries of
+// a zip archive, followed by manifest.json. Runs are loaded one at a time so
+// neither the archive nor a long-lived query is held while the client reads.
+func (s *Server) handleAdminExportAll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ids, err := s.listRunIDs(r.Context())
+	if err != nil {
+		http.Error(w, "runs load failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "csp-runs-"+time.Now().UTC().Format("2006-01-02")+".zip"))
+	zw := zip.NewWriter(w)
+	manifest := struct {
+		GeneratedAt string              `json:"generatedAt"`
+		Version     string              `json:"version"`
+		Runs        []ExportManifestRun `json:"runs"`
+	}{GeneratedAt: time.Now().UTC().Format(time.RFC3339), Version: version, Runs: []ExportManifestRun{}}
+
+	for _, id := range ids {
+		run, err := s.getRun(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue // deleted since listing
+		}
+		if err != nil {
+			log.Printf("export all: run %d: %v", id, err)
+			return
+		}
+		entry := ExportManifestRun{
+			ID:        run.ID,
+			File:      fmt.Sprintf("run-%d.json", run.ID),
+			CreatedAt: run.CreatedAt,
+			Label:     run.Label,
+			URLs:      parseURLList(run.URLsText),
+			ExitCode:  run.ExitCode,
+			ElapsedMs: run.ElapsedMs,
+		}
+		if run.ProfileID.Valid {
+			entry.ProfileID = &run.ProfileID.Int64
+		}
+		if json.Valid([]byte(run.SummaryJSON)) {
+			entry.Summary = json.RawMessage(run.SummaryJSON)
+		}
+		f, err := zw.Create(entry.File)
+		if err != nil {
+			log.Printf("export all: %v", err)
+			return
+		}
+		if _, err := io.WriteString(f, run.ResultsJSON); err != nil {
+			log.Printf("export all: %v", err)
+			return
+		}
+		manifest.Runs = append(manifest.Runs, entry)
+	}
+
+	f, err := zw.Create("manifest.json")
+	if err != nil {
+		log.Printf("export all: %v", err)
+		return
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		log.Printf("export all: %v", err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("export all: %v", err)
+	}
+}
+
+// maxImportBytes bounds an uploaded import archive, which is read into
+// memory because zip needs random access.
+const maxImportBytes = 256 << 20
+
+// ImportResult reports what POST /admin/import-all.zip did with each entry.
+type ImportResult struct {
+	Imported int      `json:"imported"`
+	Skipped  int      `json:"skipped"`
+	RunIDs   []int64  `json:"runIds"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// handleAdminImportAll restores runs from an archive made by
+// /admin/export-all.zip, sent as the raw request body. Every run-<id>.json
+// entry that passes validateReport becomes a new run with a new ID; the
+// manifest, when present, supplies the time, label, URLs and exit code.
+// Imported runs have no profile, since profile IDs differ between instances.
+func (s *Server) handleAdminImportAll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err != nil {
+		http.Error(w, "archive too large or unreadable", http.StatusRequestEntityTooLarge)
+		return
+	}
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		http.Error(w, "invalid zip archive", http.StatusBadRequest)
+		return
+	}
+
+	meta := map[string]ExportManifestRun{}
+	for _, f := range zr.File {
+		if f.Name != "manifest.json" {
+			continue
+		}
+		var manifest struct {
+			Runs []ExportManifestRun `json:"runs"`
+		}
+		if data, err := readZipEntry(f); err == nil && json.Unmarshal(data, &manifest) == nil {
+			for _, m := range manifest.Runs {
+				meta[m.File] = m
+			}
+		}
+	}
+
+	result := ImportResult{RunIDs: []int64{}}
+	skip := func(name string, err error) {
+		result.Skipped++
+		result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", name, err))
+	}
+	for _, f := range zr.File {
+		if !strings.HasPrefix(f.Name, "run-") || !strings.HasSuffix(f.Name, ".json") {
+			continue
+		}
+		data, err := readZipEntry(f)
+		if err != nil {
+			skip(f.Name, err)
+			continue
+		}
+		var multi MultiReport
+		if err := json.Unmarshal(data, &multi); err != nil {
+			skip(f.Name, err)
+			continue
+		}
+		if err := validateReport(multi); err != nil {
+			skip(f.Name, err)
+			continue
+		}
+		run := importedRun(multi, data, meta[f.Name])
+		id, err := s.insertRun(r.Context(), run)
+		if err != nil {
+			log.Printf("import %s: %v", f.Name, err)
+			skip(f.Name, errors.New("store failed"))
+			continue
+		}
+		result.Imported++
+		result.RunIDs = append(result.RunIDs, id)
+		s.auditLog(r.Context(), "run.imported", strconv.FormatInt(id, 10), s.auditUser(r))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func readZipEntry(f *zip.File) ([]byte, error) {
+	if f.UncompressedSize64 > maxImportBytes {
+		return nil, errors.New("entry too large")
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, maxImportBytes))
+}
+
+// handleAdminImportGob stores the report posted in the body, as written by
+// /runs/export?format=gob, as a new run without a profile.
+func (s *Server) handleAdminImportGob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	multi, err := decodeReportGob(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err != nil {
+		http.Error(w, "invalid gob report", http.StatusBadRequest)
+		return
+	}
+	if err := validateReport(multi); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := json.Marshal(multi)
+	if err != nil {
+		http.Error(w, "marshal results failed", http.StatusInternalServerError)
+		return
+	}
+	run := importedRun(multi, data, ExportManifestRun{Label: strings.TrimSpace(r.URL.Query().Get("label"))})
+	id, err := s.insertRun(r.Context(), run)
+	if err != nil {
+		log.Printf("import gob: %v", err)
+		http.Error(w, "store failed", http.StatusInternalServerError)
+		return
+	}
+	s.auditLog(r.Context(), "run.imported", strconv.FormatInt(id, 10), s.auditUser(r))
+	writeJSON(w, http.StatusOK, ImportResult{Imported: 1, RunIDs: []int64{id}})
+}
+
+// gobReport is the payload of the gob export. gob sends a pointer's target
+// rather than the pointer and leaves out zero values, so a Settled of false
+// or a LineNumber of 0 would come back nil; Set records, in
+// visitOptionalFields order, which of those fields were present.
+type gobReport struct {
+	Report MultiReport
+	Set    []bool
+}
+
+func init() {
+	// Config values decoded from JSON.
+	gob.Register(map[string]any{})
+	gob.Register([]any{})
+}
+
+// encodeReportGob writes multi in the Go-specific gob format.
+func encodeReportGob(w io.Writer, multi MultiReport) error {
+	payload := gobReport{Report: multi}
+	visitOptionalFields(&multi,
+		func(p **bool) { payload.Set = append(payload.Set, *p != nil) },
+		func(p **int) { payload.Set = append(payload.Set, *p != nil) })
+	return gob.NewEncoder(w).Encode(payload)
+}
+
+// decodeReportGob reads a report written by encodeReportGob, restoring the
+// optional fields and empty lists gob does not transmit.
+func decodeReportGob(r io.Reader) (MultiReport, error) {
+	var payload gobReport
+	if err := gob.NewDecoder(r).Decode(&payload); err != nil {
+		return MultiReport{}, err
+	}
+	multi := payload.Report
+	i := 0
+	set := func() bool {
+		i++
+		return i <= len(payload.Set) && payload.Set[i-1]
+	}
+	visitOptionalFields(&multi,
+		func(p **bool) {
+			if set() && *p == nil {
+				*p = new(bool)
+			}
+		},
+		func(p **int) {
+			if set() && *p == nil {
+				*p = new(int)
+			}
+		})
+	for name, rep := range multi.Browsers {
+		if rep.Results == nil {
+			rep.Results = []ReportPageResult{}
+		}
+		for j := range rep.Results {
+			if rep.Results[j].Violations == nil {
+				rep.Results[j].Violations = []Violation{}
+			}
+		}
+		multi.Browsers[name] = rep
+	}
+	return multi, nil
+}
+
+// visitOptionalFields calls onBool or onInt with the address of every
+// pointer field in multi, always in the same order. New *bool or *int fields
+// in the report types must be added here; TestVisitOptionalFieldsCoversEveryPointer
+// fails until they are.
+func visitOptionalFields(multi *MultiReport, onBool func(**bool), onInt func(**int)) {
+	violation := func(v *Violation) {
+		onInt(&v.StatusCode)
+		onInt(&v.LineNumber)
+		onInt(&v.ColumnNumber)
+	}
+	for _, name := range orderedBrowserNames(*multi) {
+		rep := multi.Browsers[name]
+		onBool(&rep.Settled)
+		for j := range rep.Results {
+			res := &rep.Results[j]
+			onInt(&res.Status)
+			onBool(&res.Settled)
+			onBool(&res.HasCSP)
+			for k := range res.Violations {
+				violation(&res.Violations[k])
+			}
+		}
+		multi.Browsers[name] = rep
+	}
+	for k := range multi.Flaky {
+		violation(&multi.Flaky[k])
+	}
+}
+
+// importedRun builds the run to store for an imported results document,
+// preferring manifest metadata and falling back to what the results hold.
+func importedRun(multi MultiReport, resultsJSON []byte, m ExportManifestRun) Run {
+	summaryJSON, _ := json.Marshal(summarizeMulti(multi))
+	run := Run{
+		CreatedAt:   m.CreatedAt,
+		URLsText:    strings.Join(m.URLs, "\n"),
+		SummaryJSON: string(summaryJSON),
+		ResultsJSON: string(resultsJSON),
+		ExitCode:    m.ExitCode,
+		ElapsedMs:   m.ElapsedMs,
+		Label:       m.Label,
+	}
+	if run.CreatedAt == "" {
+		run.CreatedAt = multi.GeneratedAt
+	}
+	if run.CreatedAt == "" {
+		run.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	if run.URLsText == "" {
+		seen := map[string]bool{}
+		var urls []string
+		for _, name := range orderedBrowserNames(multi) {
+			for _, res := range multi.Browsers[name].Results {
+				if !seen[res.URL] {
+					seen[res.URL] = true
+					urls = append(urls, res.URL)
+				}
+			}
+		}
+		run.URLsText = strings.Join(urls, "\n")
+	}
+	return run
+}
+
+// validateReport checks that a stored or imported multi-browser report is
+// usable: at least one browser, non-negative totals, and a full http/https
+// URL for every page.
+func validateReport(multi MultiReport) error {
+	if len(multi.Browsers) == 0 {
+		return errors.New("report has no browsers")
+	}
+	for _, name := range orderedBrowserNames(multi) {
+		rep := multi.Browsers[name]
+		if rep.Totals.Pages < 0 || rep.Totals.Violations < 0 {
+			return fmt.Errorf("%s: negative totals", name)
+		}
+		for i, res := range rep.Results {
+			u, err := url.Parse(res.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("%s: result %d has no valid url", name, i+1)
+			}
+		}
+	}
+	return nil
+}
+
+// handleSchedulerPause serves /admin/scheduler/pause and /resume. A run the
+// scheduler has already started is not interrupted.
+func (s *Server) handleSchedulerPause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	paused := strings.HasSuffix(r.URL.Path, "/pause")
+	if s.schedulerPaused.Swap(paused) != paused {
+		log.Printf("scheduler paused: %v", paused)
+		action := "scheduler.resumed"
+		if paused {
+			action = "scheduler.paused"
+		}
+		s.auditLog(r.Context(), action, "", s.auditUser(r))
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": paused})
+}
+
+func (s *Server) handleAdminVacuum(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	before, after, err := s.vacuumDB(r.Context())
+	if errors.Is(err, errDBBusy) {
+		http.Error(w, "database busy, try again later", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "vacuum failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{
+		"beforeBytes":    before,
+		"afterBytes":     after,
+		"reclaimedBytes": before - after,
+	})
+}
+
+func (s *Server) handleAPIBrowsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"browsers": s.availableBrowsers()})
+}
+
+// handleRunLogStream streams node stderr from every running check as
+// server-sent events. Only lines written while the client is connected are
+// sent; nothing is buffered for late subscribers.
+func (s *Server) handleRunLogStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	lines, cancel := nodeLogs.subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case line := <-lines:
+			data, err := json.Marshal(line)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: log\ndata: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
+
+// StatusSnapshot is a one-call summary for monitoring dashboards.
+type StatusSnapshot struct {
+	TotalRuns           int     `json:"totalRuns"`
+	RunsLast24h         int     `json:"runsLast24h"`
+	ViolationsLast24h   int     `json:"violationsLast24h"`
+	LatestRunAt         *string `json:"latestRunAt"`
+	LatestRunViolations *int    `json:"latestRunViolations"`
+	QueueDepth          int64   `json:"queueDepth"`
+	SchedulerPaused     bool    `json:"schedulerPaused"`
+}
+
+// QuickCheckResult is the response of /api/quick-check. Quick checks are
+// not stored as runs.
+type QuickCheckResult struct {
+	EffectiveConfig CSPConfig   `json:"effectiveConfig"`
+	ExitCode        int         `json:"exitCode"`
+	Summary         RunSummary  `json:"summary"`
+	Report          MultiReport `json:"report"`
+}
+
+// handleAPIQuickCheck checks the url parameters with a profile's config
+// (the default profile when profile_id is absent), after applying any
+// config overrides given as query parameters.
+func (s *Server) handleAPIQuickCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	urls := parseURLList(strings.Join(q["url"], "\n"))
+	if len(urls) == 0 {
+		http.Error(w, "at least one full http or https url is required", http.StatusBadRequest)
+		return
+	}
+	_, cfg, err := s.resolveConfig(r.Context(), parseProfileID(q.Get("profile_id")))
+	if err != nil {
+		http.Error(w, err.Error(), runErrorStatus(err))
+		return
+	}
+	for key, values := range q {
+		if key == "url" || key == "profile_id" {
+			continue
+		}
+		apply, ok := configOverrides[key]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown parameter %q", key), http.StatusBadRequest)
+			return
+		}
+		if err := apply(&cfg, strings.TrimSpace(values[len(values)-1])); err != nil {
+			http.Error(w, fmt.Sprintf("invalid %s: %v", key, err), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := validateConfig(cfg); err != nil {
+		http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.maxConcurrency > 0 && cfg.Concurrency > s.maxConcurrency {
+		http.Error(w, fmt.Sprintf("invalid concurrency: at most %d on this server", s.maxConcurrency), http.StatusBadRequest)
+		return
+	}
+
+	s.activeRuns.Add(1)
+	multi, exitCode, err := s.checkURLs(r.Context(), urls, cfg)
+	s.activeRuns.Add(-1)
+	if err != nil {
+		log.Printf("quick check: %v", err)
+		http.Error(w, "csp check failed", http.StatusInternalServerError)
+		return
+	}
+	multi = truncateSamples(multi, s.maxSampleLen)
+	maskResponseHeaders(multi)
+	writeJSON(w, http.StatusOK, QuickCheckResult{
+		EffectiveConfig: cfg.redacted(),
+		ExitCode:        exitCode,
+		Summary:         summarizeMulti(multi),
+		Report:          multi,
+	})
+}
+
+// waitUntilValues are the navigation events Playwright can wait for.
+var waitUntilValues = []string{"commit", "domcontentloaded", "load", "networkidle"}
+
+// configOverrides parse quick-check query parameters, named like CSPConfig's
+// JSON fields, into a config.
+var configOverrides = map[string]func(cfg *CSPConfig, v string) error{
+	"waitUntil": func(cfg *CSPConfig, v string) error {
+		if !containsString(waitUntilValues, v) {
+			return fmt.Errorf("must be one of %s", strings.Join(waitUntilValues, ", "))
+		}
+		cfg.WaitUntil = v
+		return nil
+	},
+	"navTimeoutMs": intOverride(func(cfg *CSPConfig) *int { return &cfg.NavTimeoutMs }, 1),
+	"settleWaitMs": intOverride(func(cfg *CSPConfig) *int { return &cfg.SettleWaitMs }, 0),
+	"timeoutMultiplier": func(cfg *CSPConfig, v string) error {
+		m, err := strconv.ParseFloat(v, 64)
+		if err != nil || m < minTimeoutMultiplier || m > maxTimeoutMultiplier {
+			return fmt.Errorf("must be a number between %g and %g", minTimeoutMultiplier, maxTimeoutMultiplier)
+		}
+		cfg.TimeoutMultiplier = m
+		return nil
+	},
+	"betweenUrlMs":         intOverride(func(cfg *CSPConfig) *int { return &cfg.BetweenURLMs }, 0),
+	"concurrency":          intOverride(func(cfg *CSPConfig) *int { return &cfg.Concurrency }, 1),
+	"userAgent":            func(cfg *CSPConfig, v string) error { cfg.UserAgent = v; return nil },
+	"acceptLanguage":       func(cfg *CSPConfig, v string) error { cfg.AcceptLanguage = v; return nil },
+	"disableJs":            boolOverride(func(cfg *CSPConfig) *bool { return &cfg.DisableJS }),
+	"ignoreTlsErrors":      boolOverride(func(cfg *CSPConfig) *bool { return &cfg.IgnoreTLSErrors }),
+	"captureHeaders":       boolOverride(func(cfg *CSPConfig) *bool { return &cfg.CaptureHeaders }),
+	"failOnReportOnly":     boolOverride(func(cfg *CSPConfig) *bool { return &cfg.FailOnReportOnly }),
+	"maxViolationsPerPage": intOverride(func(cfg *CSPConfig) *int { return &cfg.MaxViolationsPerPage }, 0),
+	"devices": func(cfg *CSPConfig, v string) error {
+		cfg.Devices = nil
+		for _, d := range strings.Split(v, ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				cfg.Devices = append(cfg.Devices, d)
+			}
+		}
+		return nil
+	},
+}
+
+func intOverride(field func(*CSPConfig) *int, min int) func(*CSPConfig, string) error {
+	return func(cfg *CSPConfig, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < min {
+			return fmt.Errorf("must be an integer of at least %d", min)
+		}
+		*field(cfg) = n
+		return nil
+	}
+}
+
+func boolOverride(field func(*CSPConfig) *bool) func(*CSPConfig, string) error {
+	return func(cfg *CSPConfig, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.New("must be true or false")
+		}
+		*field(cfg) = b
+		return nil
+	}
+}
+
+// URLHistoryPoint is one run's violation count for a single URL, summed
+// across browsers.
+type URLHistoryPoint struct {
+	RunID      int64  `json:"runId"`
+	CreatedAt  string `json:"createdAt"`
+	Violations int    `json:"violations"`
+}
+
+// urlHistoryScanRuns bounds how many recent runs a URL history request
+// decodes while looking for the URL.
+const urlHistoryScanRuns = 500
+
+func (s *Server) handleAPIURLHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	target, ok := normalizeURL(strings.TrimSpace(r.URL.Query().Get("url")))
+	if !ok {
+		http.Error(w, "url must be a full http or https URL", http.StatusBadRequest)
+		return
+	}
+	limit := 20
+	if v := parseIntForm(r.URL.Query().Get("limit")); v > 0 {
+		limit = v
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	points, err := s.urlHistory(r.Context(), target, limit)
+	if err != nil {
+		log.Printf("url history %s: %v", target, err)
+		http.Error(w, "history load failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, points)
+}
+
+// RunListEntry is one run in the /api/runs listing.
+type RunListEntry struct {
+	ID        int64           `json:"id"`
+	ProfileID *int64          `json:"profileId"`
+	CreatedAt string          `json:"createdAt"`
+	Label     string          `json:"label,omitempty"`
+	ExitCode  int             `json:"exitCode"`
+	ElapsedMs int64           `json:"elapsedMs"`
+	Summary   json.RawMessage `json:"summary,omitempty"`
+}
+
+// handleAPIRuns lists the 100 newest runs, newest first, optionally limited
+// to the from/to date range that Run History accepts.
+func (s *Server) handleAPIRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	from, to, err := parseRunDateRange(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	runs, err := s.listRunsBetween(r.Context(), from, to)
+	if err != nil {
+		log.Printf("api runs: %v", err)
+		http.Error(w, "runs load failed", http.StatusInternalServerError)
+		return
+	}
+	out := make([]RunListEntry, 0, len(runs))
+	for _, run := range runs {
+		entry := RunListEntry{
+			ID:        run.ID,
+			CreatedAt: run.CreatedAt,
+			Label:     run.Label,
+			ExitCode:  run.ExitCode,
+			ElapsedMs: run.ElapsedMs,
+		}
+		if run.ProfileID.Valid {
+			id := run.ProfileID.Int64
+			entry.ProfileID = &id
+		}
+		if json.Valid([]byte(run.SummaryJSON)) {
+			entry.Summary = json.RawMessage(run.SummaryJSON)
+		}
+		out = append(out, entry)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleAPILatestForURL answers "when was this URL last checked, and what
+// was found": the newest run, under any profile, that checked the url
+// parameter, or 404 when none of the recent runs did.
+func (s *Server) handleAPILatestForURL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	target, ok := normalizeURL(strings.TrimSpace(r.URL.Query().Get("url")))
+	if !ok {
+		http.Error(w, "url must be a full http or https URL", http.StatusBadRequest)
+		return
+	}
+	points, err := s.urlHistory(r.Context(), target, 1)
+	if err != nil {
+		log.Printf("latest run for %s: %v", target, err)
+		http.Error(w, "history load failed", http.StatusInternalServerError)
+		return
+	}
+	if len(points) == 0 {
+		http.Error(w, "url not checked by any recent run", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, points[0])
+}
+
+// urlHistory returns the violation counts of target in the last limit runs
+// that checked it, oldest first.
+func (s *Server) urlHistory(ctx context.Context, target string, limit int) ([]URLHistoryPoint, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+runColumns+` FROM runs ORDER BY created_at DESC, id DESC LIMIT ?`, urlHistoryScanRuns)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []URLHistoryPoint{}
+	for rows.Next() && len(points) < limit {
+		run, err := s.scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		multi, err := loadMultiReport(run)
+		if err != nil {
+			continue
+		}
+		found, violations := false, 0
+		for _, rep := range multi.Browsers {
+			for _, res := range rep.Results {
+				if u, ok := normalizeURL(res.URL); ok && u == target {
+					found = true
+					violations += len(res.Violations)
+				}
+			}
+		}
+		if found {
+			points = append(points, URLHistoryPoint{RunID: run.ID, CreatedAt: run.CreatedAt, Violations: violations})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+		points[i], points[j] = points[j], points[i]
+	}
+	return points, nil
+}
+
+// OriginCount is one blocked origin in the /api/all-origins listing.
+type OriginCount struct {
+	Origin string `json:"origin"`
+	// Count is the origin's violations summed across browsers and runs.
+	Count int `json:"count"`
+	// Runs is how many runs reported the origin at least once.
+	Runs int `json:"runs"`
+}
+
+// allOriginsCacheTTL is how long the default /api/all-origins answer is
+// reused, as scanning every recent run is slow on a large database. Other
+// windows are not cached, so callers cannot grow the cache.
+const (
+	allOriginsCacheTTL = 5 * time.Minute
+	defaultOriginsDays = 90
+)
+
+type cachedOrigins struct {
+	at      time.Time
+	origins []OriginCount
+}
+
+// handleAPIAllOrigins lists every origin blocked in runs of the last days
+// days (default defaultOriginsDays), under any profile, for building an
+// allowlist.
+func (s *Server) handleAPIAllOrigins(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	days := defaultOriginsDays
+	if raw := r.URL.Query().Get("days"); raw != "" {
+		if days = parseIntForm(raw); days <= 0 {
+			http.Error(w, "days must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	cacheable := days == defaultOriginsDays
+	if cacheable {
+		s.originsMu.Lock()
+		cached := s.originsCache
+		s.originsMu.Unlock()
+		if cached.origins != nil && time.Since(cached.at) < allOriginsCacheTTL {
+			writeJSON(w, http.StatusOK, cached.origins)
+			return
+		}
+	}
+
+	now := time.Now()
+	origins, err := s.allBlockedOrigins(r.Context(), now.AddDate(0, 0, -days))
+	if err != nil {
+		log.Printf("all origins: %v", err)
+		http.Error(w, "origins load failed", http.StatusInternalServerError)
+		return
+	}
+	if cacheable {
+		s.originsMu.Lock()
+		s.originsCache = cachedOrigins{at: now, origins: origins}
+		s.originsMu.Unlock()
+	}
+	writeJSON(w, http.StatusOK, origins)
+}
+
+// allBlockedOrigins counts the distinct blocked origins reported by any
+// browser in runs created since since, most violations first. Origins are
+// groupOrigin's, so data: and blob: URIs appear under their synthetic
+// labels; other violations without an origin are skipped.
+func (s *Server) allBlockedOrigins(ctx context.Context, since time.Time) ([]OriginCount, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+runColumns+` FROM runs WHERE created_at >= ? ORDER BY id`, since.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byOrigin := map[string]*OriginCount{}
+	for rows.Next() {
+		run, err := s.scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		multi, err := loadMultiReport(run)
+		if err != nil {
+			continue
+		}
+		seen := map[string]bool{}
+		for _, rep := range multi.Browsers {
+			for _, res := range rep.Results {
+				for _, v := range res.Violations {
+					origin := strings.TrimSpace(groupOrigin(v))
+					if origin == "" {
+						continue
+					}
+					c := byOrigin[origin]
+					if c == nil {
+						c = &OriginCount{Origin: origin}
+						byOrigin[origin] = c
+					}
+					c.Count++
+					if !seen[origin] {
+						seen[origin] = true
+						c.Runs++
+					}
+				}
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	out := make([]OriginCount, 0, len(byOrigin))
+	for _, c := range byOrigin {
+		out = append(out, *c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Origin < out[j].Origin
+	})
+	return out, nil
+}
+
+func (s *Server) handleAPIStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	snap, err := s.statusSnapshot(r.Context())
+	if err != nil {
+		http.Error(w, "status load failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, snap)
+}
+
+// statusSnapshot reads only run counts and summaries, never the stored
+// results, so it stays cheap to poll.
+func (s *Server) statusSnapshot(ctx context.Context) (StatusSnapshot, error) {
+	snap := StatusSnapshot{QueueDepth: s.activeRuns.Load(), SchedulerPaused: s.schedulerPaused.Load()}
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM runs`).Scan(&snap.TotalRuns); err != nil {
+		return snap, err
+	}
+
+	cutoff := time.Now().Add(-24 * time.Hour).UTC().Format(time.RFC3339)
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT summary_json FROM runs WHERE created_at >= ? AND exit_code <> ?`, cutoff, failedRunExitCode)
+	if err != nil {
+		return snap, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var summary string
+		if err := rows.Scan(&summary); err != nil {
+			return snap, err
+		}
+		snap.RunsLast24h++
+		snap.ViolationsLast24h += jsonViolations(summary)
+	}
+	if err := rows.Err(); err != nil {
+		return snap, err
+	}
+
+	var createdAt, summary string
+	err = s.db.QueryRowContext(ctx,
+		`SELECT created_at, summary_json FROM runs WHERE exit_code <> ? ORDER BY created_at DESC, id DESC LIMIT 1`,
+		failedRunExitCode).Scan(&createdAt, &summary)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return snap, err
+	default:
+		n := jsonViolations(summary)
+		snap.LatestRunAt = &createdAt
+		snap.LatestRunViolations = &n
+	}
+	return snap, nil
+}
+
+// handleOpenAPI serves the hand-maintained web/openapi.json. Keep it in step
+// with the routes registered in routes.
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	spec, err := embeddedFS.ReadFile("web/openapi.json")
+	if err != nil {
+		http.Error(w, "spec unavailable", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(spec)
+}
+
+// availableBrowsers lists the browsers every run checks and whether each is
+// installed. The probe result is cached for the life of the process.
+func (s *Server) availableBrowsers() []BrowserInfo {
+	s.browserOnce.Do(func() {
+		if s.browserProbe == nil {
+			return
+		}
+		installed, err := s.browserProbe()
+		if err != nil {
+			log.Printf("browser probe: %v", err)
+			return
+		}
+		s.browserInstalled = installed
+	})
+	out := make([]BrowserInfo, 0, len(browsers))
+	for _, name := range browsers {
+		info := BrowserInfo{Name: name}
+		if ok, found := s.browserInstalled[name]; found {
+			info.Available = &ok
+		}
+		out = append(out, info)
+	}
+	return out
+}
+
+// validateNodeCommand checks at startup that local checks can start: the
+// script must be a regular file and the node binary must resolve on PATH.
+// Otherwise every run would fail later with a less obvious exec error.
+func validateNodeCommand(nodeBin, scriptPath string) error {
+	if _, err := exec.LookPath(nodeBin); err != nil {
+		return fmt.Errorf("CSP_NODE_BIN %q not found: %w", nodeBin, err)
+	}
+	info, err := os.Stat(scriptPath)
+	if err != nil {
+		return fmt.Errorf("CSP_SCRIPT_PATH %q: %w", scriptPath, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("CSP_SCRIPT_PATH %q is not a regular file", scriptPath)
+	}
+	return nil
+}
+
+// probeBrowsers asks the node script which Playwright browsers are installed.
+func probeBrowsers() (map[string]bool, error) {
+	nodeBin := envDefault("CSP_NODE_BIN", "node")
+	scriptPath := envDefault("CSP_SCRIPT_PATH", "./csp-check.mjs")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, nodeBin, scriptPath, "--list-browsers").Output()
+	if err != nil {
+		return nil, err
+	}
+	var installed map[string]bool
+	if err := json.Unmarshal(out, &installed); err != nil {
+		return nil, err
+	}
+	return installed, nil
+}
+
+func (s *Server) render(w http.ResponseWriter, name string, data map[string]any) {
+	if data == nil {
+		data = map[string]any{}
+	}
+	// Branding shared by every page header.
+	data["InstanceName"] = s.instanceName
+	data["FaviconURL"] = s.faviconURL
+	data["ReadOnly"] = s.readOnly
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if s.maxRenderBytes <= 0 {
+		if err := s.tmpl.ExecuteTemplate(w, name, data); err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+		}
+		return
+	}
+	lw := &limitedWriter{w: w, limit: s.maxRenderBytes}
+	err := s.tmpl.ExecuteTemplate(lw, name, data)
+	if errors.Is(err, errOutputLimit) {
+		// The status line is long gone; end the page with a visible note.
+		log.Printf("render %s: output truncated at %d bytes", name, s.maxRenderBytes)
+		fmt.Fprintf(w, "\n<p class=\"warning\">Page truncated: it exceeded %d bytes. Use the JSON export for the full results.</p>\n", s.maxRenderBytes)
+		return
+	}
+	if err != nil {
+		http.Error(w, "template error", http.StatusInternalServerError)
+	}
+}
+
+var errOutputLimit = errors.New("output limit reached")
+
+// limitedWriter passes at most limit bytes to w, then fails every write with
+// errOutputLimit so template execution stops early.
+type limitedWriter struct {
+	w       io.Writer
+	limit   int64
+	written int64
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	remaining := lw.limit - lw.written
+	if remaining <= 0 {
+		return 0, errOutputLimit
+	}
+	if int64(len(p)) > remaining {
+		n, err := lw.w.Write(p[:remaining])
+		lw.written += int64(n)
+		if err != nil {
+			return n, err
+		}
+		return n, errOutputLimit
+	}
+	n, err := lw.w.Write(p)
+	lw.written += int64(n)
+	return n, err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+var errDBBusy = errors.New("database busy")
+
+// Retry policy for writes that hit SQLITE_BUSY/SQLITE_LOCKED: up to
+// retryAttempts tries, doubling the delay from retryBaseDelay each time.
+var (
+	retryAttempts  = 5
+	retryBaseDelay = 20 * time.Millisecond
+)
+
+// withRetry runs fn, retrying with exponential backoff while it fails
+// because the database is busy or locked. Other errors return immediately;
+// if ctx is done while waiting to retry, its error is returned.
+func withRetry(ctx context.Context, fn func() error) error {
+	delay := retryBaseDelay
+	var err error
+	for attempt := 1; attempt <= retryAttempts; attempt++ {
+		err = fn()
+		if err == nil || !isBusyError(err) || attempt == retryAttempts {
+			return err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+	return err
+}
+
+func isBusyError(err error) bool {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		code := sqliteErr.Code() & 0xff
+		return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "sqlite_busy")
+}
+
+// isUniqueViolation reports whether err is sqlite rejecting a duplicate value
+// for a UNIQUE column.
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+	}
+	return false
+}
+
+// vacuumDB runs VACUUM and PRAGMA optimize and reports the database file size
+// before and after. It refuses to start while other writes are in flight.
+func (s *Server) vacuumDB(ctx context.Context) (int64, int64, error) {
+	if !s.dbMu.TryLock() {
+		return 0, 0, errDBBusy
+	}
+	defer s.dbMu.Unlock()
+
+	before, err := fileSize(s.dbPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err := s.db.ExecContext(ctx, `VACUUM`); err != nil {
+		return before, 0, err
+	}
+	if _, err := s.db.ExecContext(ctx, `PRAGMA optimize`); err != nil {
+		return before, 0, err
+	}
+	after, err := fileSize(s.dbPath)
+	if err != nil {
+		return before, 0, err
+	}
+	return before, after, nil
+}
+
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// listProfiles returns profiles newest first. Archived profiles are only
+// included when includeArchived is set.
+func (s *Server) listProfiles(ctx context.Context, includeArchived bool) ([]Profile, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, name, config_json, created_at, archived FROM profiles WHERE ? OR archived = 0 ORDER BY created_at DESC`,
+		includeArchived,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var profiles []Profile
+	for rows.Next() {
+		var p Profile
+		if err := rows.Scan(&p.ID, &p.Name, &p.ConfigJSON, &p.CreatedAt, &p.Archived); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, rows.Err()
+}
+
+// findDuplicateProfiles groups the IDs of profiles whose configs are equal
+// once normalized by parseConfig, so key order, whitespace and fields left
+// at their defaults do not matter. Groups and the IDs in them keep the
+// order of profiles; configs that do not parse are never grouped.
+func findDuplicateProfiles(profiles []Profile) [][]int64 {
+	byConfig := map[string][]int64{}
+	var order []string
+	for _, p := range profiles {
+		cfg, err := parseConfig(p.ConfigJSON)
+		if err != nil {
+			continue
+		}
+		b, err := json.Marshal(cfg)
+		if err != nil {
+			continue
+		}
+		key := string(b)
+		if _, ok := byConfig[key]; !ok {
+			order = append(order, key)
+		}
+		byConfig[key] = append(byConfig[key], p.ID)
+	}
+	var groups [][]int64
+	for _, key := range order {
+		if len(byConfig[key]) > 1 {
+			groups = append(groups, byConfig[key])
+		}
+	}
+	return groups
+}
+
+// indexProfiles returns profiles for the run form dropdown: the default profile
+// first, then the rest in the configured order ("created", newest first, or
+// "name").
+func (s *Server) indexProfiles(ctx context.Context) ([]Profile, error) {
+	profiles, err := s.listProfiles(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	if s.profileOrder == "name" {
+		sort.SliceStable(profiles, func(i, j int) bool {
+			return strings.ToLower(profiles[i].Name) < strings.ToLower(profiles[j].Name)
+		})
+	}
+	sort.SliceStable(profiles, func(i, j int) bool {
+		return profiles[i].Name == defaultProfileName && profiles[j].Name != defaultProfileName
+	})
+	return profiles, nil
+}
+
+// errProfileLimit is returned by createProfile once maxProfiles non-default
+// profiles exist.
+var errProfileLimit = errors.New("profile limit reached")
+
+// createProfile inserts a profile. The cap check and the insert are a single
+// statement so concurrent creates cannot overshoot maxProfiles.
+func (s *Server) createProfile(ctx context.Context, name, configJSON string) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return withRetry(ctx, func() error {
+		res, err := s.db.ExecContext(ctx,
+			`INSERT INTO profiles (name, config_json, created_at)
+			 SELECT ?, ?, ?
+			 WHERE ? <= 0 OR ? = ? OR (SELECT COUNT(*) FROM profiles WHERE name != ?) < ?`,
+			name, configJSON, time.Now().UTC().Format(time.RFC3339),
+			s.maxProfiles, name, defaultProfileName, defaultProfileName, s.maxProfiles,
+		)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return errProfileLimit
+		}
+		return nil
+	})
+}
+
+// deleteProfile removes a profile. Its runs are kept with no profile; its
+// schedules, history, baseline and false positives go with it.
+func (s *Server) deleteProfile(ctx context.Context, id int64) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return withRetry(ctx, func() error {
+		_, err := s.db.ExecContext(ctx, `DELETE FROM profiles WHERE id = ?`, id)
+		return err
+	})
+}
+
+func (s *Server) setProfileArchived(ctx context.Context, id int64, archived bool) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return withRetry(ctx, func() error {
+		_, err := s.db.ExecContext(ctx, `UPDATE profiles SET archived = ? WHERE id = ?`, archived, id)
+		return err
+	})
+}
+
+func (s *Server) createURLList(ctx context.Context, name, urlsText string) (int64, error) {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	var id int64
+	err := withRetry(ctx, func() error {
+		res, err := s.db.ExecContext(ctx,
+			`INSERT INTO url_lists (name, urls_text, created_at) VALUES (?, ?, ?)`,
+			name, urlsText, time.Now().UTC().Format(time.RFC3339),
+		)
+		if err != nil {
+			return err
+		}
+		id, err = res.LastInsertId()
+		return err
+	})
+	return id, err
+}
+
+func (s *Server) listURLLists(ctx context.Context) ([]URLList, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, name, urls_text, created_at FROM url_lists ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lists []URLList
+	for rows.Next() {
+		var l URLList
+		if err := rows.Scan(&l.ID, &l.Name, &l.URLsText, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		lists = append(lists, l)
+	}
+	return lists, rows.Err()
+}
+
+func (s *Server) getURLList(ctx context.Context, id int64) (URLList, error) {
+	var l URLList
+	err := s.db.QueryRowContext(ctx, `SELECT id, name, urls_text, created_at FROM url_lists WHERE id = ?`, id).
+		Scan(&l.ID, &l.Name, &l.URLsText, &l.CreatedAt)
+	return l, err
+}
+
+func (s *Server) updateURLList(ctx context.Context, id int64, name, urlsText string) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return withRetry(ctx, func() error {
+		res, err := s.db.ExecContext(ctx, `UPDATE url_lists SET name = ?, urls_text = ? WHERE id = ?`, name, urlsText, id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+}
+
+func (s *Server) deleteURLList(ctx context.Context, id int64) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return withRetry(ctx, func() error {
+		_, err := s.db.ExecContext(ctx, `DELETE FROM url_lists WHERE id = ?`, id)
+		return err
+	})
+}
+
+// updateProfile saves a profile and records its previous config in
+// profile_history so edits can be reviewed later.
+func (s *Server) updateProfile(ctx context.Context, id int64, name, configJSON string) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return withRetry(ctx, func() error {
+		return s.updateProfileTx(ctx, id, name, configJSON)
+	})
+}
+
+func (s *Server) updateProfileTx(ctx context.Context, id int64, name, configJSON string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var prevJSON string
+	if err := tx.QueryRowContext(ctx, `SELECT config_json FROM profiles WHERE id = ?`, id).Scan(&prevJSON); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO profile_history (profile_id, config_json, changed_at) VALUES (?, ?, ?)`,
+		id, prevJSON, time.Now().UTC().Format(time.RFC3339),
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE profiles SET name = ?, config_json = ? WHERE id = ?`,
+		name, configJSON, id,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// profileHistory returns the configs a profile had before each update,
+// oldest first.
+func (s *Server) profileHistory(ctx context.Context, profileID int64) ([]ProfileHistoryEntry, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, profile_id, config_json, changed_at FROM profile_history WHERE profile_id = ? ORDER BY id ASC`,
+		profileID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []ProfileHistoryEntry
+	for rows.Next() {
+		var e ProfileHistoryEntry
+		if err := rows.Scan(&e.ID, &e.ProfileID, &e.ConfigJSON, &e.ChangedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+func (s *Server) createSchedule(ctx context.Context, profileID sql.NullInt64, urlsText string, intervalMinutes int, now time.Time) (int64, error) {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	next := now.Add(time.Duration(intervalMinutes) * time.Minute)
+	var id int64
+	err := withRetry(ctx, func() error {
+		res, err := s.db.ExecContext(ctx,
+			`INSERT INTO schedules (profile_id, urls_text, interval_minutes, next_run_at, created_at) VALUES (?, ?, ?, ?, ?)`,
+			profileID, urlsText, intervalMinutes, next.UTC().Format(time.RFC3339), now.UTC().Format(time.RFC3339),
+		)
+		if err != nil {
+			return err
+		}
+		id, err = res.LastInsertId()
+		return err
+	})
+	return id, err
+}
+
+const scheduleColumns = `id, profile_id, urls_text, interval_minutes, next_run_at, last_run_id, created_at`
+
+func (s *Server) querySchedules(ctx context.Context, query string, args ...any) ([]Schedule, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Schedule
+	for rows.Next() {
+		var sc Schedule
+		if err := rows.Scan(&sc.ID, &sc.ProfileID, &sc.URLsText, &sc.IntervalMinutes, &sc.NextRunAt, &sc.LastRunID, &sc.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, sc)
+	}
+	return out, rows.Err()
+}
+
+func (s *Server) listSchedules(ctx context.Context) ([]Schedule, error) {
+	return s.querySchedules(ctx, `SELECT `+scheduleColumns+` FROM schedules ORDER BY id`)
+}
+
+// dueSchedules returns schedules whose next run is at or before now.
+func (s *Server) dueSchedules(ctx context.Context, now time.Time) ([]Schedule, error) {
+	return s.querySchedules(ctx,
+		`SELECT `+scheduleColumns+` FROM schedules WHERE next_run_at <= ? ORDER BY next_run_at`,
+		now.UTC().Format(time.RFC3339))
+}
+
+// updateSchedule changes a schedule. A new interval also restarts its clock
+// from now, as createSchedule does, so shortening it takes effect at once
+// instead of after the old interval.
+func (s *Server) updateSchedule(ctx context.Context, id int64, profileID sql.NullInt64, urlsText string, intervalMinutes int, now time.Time) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	next := now.Add(time.Duration(intervalMinutes) * time.Minute).UTC().Format(time.RFC3339)
+	return withRetry(ctx, func() error {
+		res, err := s.db.ExecContext(ctx,
+			`UPDATE schedules SET profile_id = ?, urls_text = ?,
+			   next_run_at = CASE WHEN interval_minutes = ? THEN next_run_at ELSE ? END,
+			   interval_minutes = ?
+			 WHERE id = ?`,
+			profileID, urlsText, intervalMinutes, next, intervalMinutes, id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+}
+
+// markScheduleRun records a schedule's latest run and when it is next due.
+func (s *Server) markScheduleRun(ctx context.Context, id int64, runID sql.NullInt64, next time.Time) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return withRetry(ctx, func() error {
+		_, err := s.db.ExecContext(ctx,
+			`UPDATE schedules SET next_run_at = ?, last_run_id = COALESCE(?, last_run_id) WHERE id = ?`,
+			next.UTC().Format(time.RFC3339), runID, id)
+		return err
+	})
+}
+
+func (s *Server) deleteSchedule(ctx context.Context, id int64) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return withRetry(ctx, func() error {
+		_, err := s.db.ExecContext(ctx, `DELETE FROM schedules WHERE id = ?`, id)
+		return err
+	})
+}
+
+// falsePositives returns the violations marked as false positives for a
+// profile, as signature -> reason.
+func (s *Server) falsePositives(ctx context.Context, profileID sql.NullInt64) (map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT signature, reason FROM false_positives WHERE profile_id IS ?`, profileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]string{}
+	for rows.Next() {
+		var sig, reason string
+		if err := rows.Scan(&sig, &reason); err != nil {
+			return nil, err
+		}
+		out[sig] = reason
+	}
+	return out, rows.Err()
+}
+
+func (s *Server) markFalsePositive(ctx context.Context, profileID sql.NullInt64, signature, reason string) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return withRetry(ctx, func() error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if _, err := tx.ExecContext(ctx, `DELETE FROM false_positives WHERE profile_id IS ? AND signature = ?`, profileID, signature); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO false_positives (profile_id, signature, reason, created_at) VALUES (?, ?, ?, ?)`,
+			profileID, signature, reason, time.Now().UTC().Format(time.RFC3339)); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+func (s *Server) clearFalsePositive(ctx context.Context, profileID sql.NullInt64, signature string) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return withRetry(ctx, func() error {
+		_, err := s.db.ExecContext(ctx, `DELETE FROM false_positives WHERE profile_id IS ? AND signature = ?`, profileID, signature)
+		return err
+	})
+}
+
+// ViolationTrack is the lifetime of one violation group within a profile.
+// ResolvedAt is empty while the group still shows up in the latest run.
+type ViolationTrack struct {
+	Key        string
+	FirstSeen  string
+	LastSeen   string
+	LastRunID  int64
+	ResolvedAt string
+}
+
+// trackScope names a browser and page, as stored in violation_tracking.pages.
+func trackScope(browser, page string) string {
+	return browser + " " + page
+}
+
+// violationScopes returns the browser and page pairs each group in multi was
+// seen on, by group key, and the pairs the run fully checked: pages that
+// loaded and settled without going over the violation threshold, in
+// browsers that did not fail.
+func violationScopes(multi MultiReport) (seen map[string]map[string]bool, checked map[string]bool) {
+	seen, checked = map[string]map[string]bool{}, map[string]bool{}
+	for name, rep := range multi.Browsers {
+		for _, r := range rep.Results {
+			scope := trackScope(name, r.URL)
+			if rep.Error == "" && r.OK && !r.TimedOut() && !r.OverThreshold {
+				checked[scope] = true
+			}
+			for _, v := range r.Violations {
+				key := groupKey(v, false)
+				if seen[key] == nil {
+					seen[key] = map[string]bool{}
+				}
+				seen[key][scope] = true
+			}
+		}
+	}
+	return seen, checked
+}
+
+// trackViolations records that run runID of a profile, finished at at, saw
+// the groups in seen on the given browsers and pages and fully checked the
+// pages in checked. Seen groups get their last-seen time bumped (and are
+// reopened if they had been resolved). An open group is resolved only once
+// every page it was seen on has been re-checked without it, so a run of
+// some of the profile's pages, or one where a browser failed, leaves the
+// other pages' groups open. Groups tracked before pages were recorded are
+// resolved by any run that does not see them.
+func (s *Server) trackViolations(ctx context.Context, profileID, runID int64, at string, seen map[string]map[string]bool, checked map[string]bool) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return withRetry(ctx, func() error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		rows, err := tx.QueryContext(ctx,
+			`SELECT group_key, pages FROM violation_tracking WHERE profile_id = ? AND resolved_at IS NULL`, profileID)
+		if err != nil {
+			return err
+		}
+		open := map[string]string{}
+		for rows.Next() {
+			var key, pages string
+			if err := rows.Scan(&key, &pages); err != nil {
+				rows.Close()
+				return err
+			}
+			open[key] = pages
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		// remaining drops the checked pages from a group's stored pages.
+		remaining := func(pages string) map[string]bool {
+			left := map[string]bool{}
+			for _, scope := range strings.Split(pages, "\n") {
+				if scope != "" && !checked[scope] {
+					left[scope] = true
+				}
+			}
+			return left
+		}
+		join := func(scopes map[string]bool) string {
+			list := make([]string, 0, len(scopes))
+			for scope := range scopes {
+				list = append(list, scope)
+			}
+			sort.Strings(list)
+			return strings.Join(list, "\n")
+		}
+
+		for key, scopes := range seen {
+			pages := remaining(open[key])
+			for scope := range scopes {
+				pages[scope] = true
+			}
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO violation_tracking (profile_id, group_key, first_seen, last_seen, last_run_id, pages)
+				 VALUES (?, ?, ?, ?, ?, ?)
+				 ON CONFLICT(profile_id, group_key) DO UPDATE SET
+				   last_seen = excluded.last_seen, last_run_id = excluded.last_run_id,
+				   pages = excluded.pages, resolved_at = NULL`,
+				profileID, key, at, at, runID, join(pages)); err != nil {
+				return err
+			}
+		}
+		for key, pages := range open {
+			if seen[key] != nil {
+				continue
+			}
+			left := remaining(pages)
+			if pages != "" && len(left) > 0 {
+				_, err = tx.ExecContext(ctx,
+					`UPDATE violation_tracking SET pages = ? WHERE profile_id = ? AND group_key = ?`,
+					join(left), profileID, key)
+			} else {
+				_, err = tx.ExecContext(ctx,
+					`UPDATE violation_tracking SET resolved_at = ?, pages = '' WHERE profile_id = ? AND group_key = ?`,
+					at, profileID, key)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+}
+
+// violationTracks lists a profile's tracked groups, open ones first, then
+// by most recently seen.
+func (s *Server) violationTracks(ctx context.Context, profileID int64) ([]ViolationTrack, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT group_key, first_seen, last_seen, last_run_id, COALESCE(resolved_at, '')
+		 FROM violation_tracking WHERE profile_id = ?
+		 ORDER BY resolved_at IS NOT NULL, last_seen DESC, group_key`, profileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tracks []ViolationTrack
+	for rows.Next() {
+		var t ViolationTrack
+		if err := rows.Scan(&t.Key, &t.FirstSeen, &t.LastSeen, &t.LastRunID, &t.ResolvedAt); err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks, rows.Err()
+}
+
+// baselineRunID returns the baseline run of a profile, or sql.ErrNoRows.
+func (s *Server) baselineRunID(ctx context.Context, profileID int64) (int64, error) {
+	var id int64
+	err := s.db.QueryRowContext(ctx, `SELECT run_id FROM baselines WHERE profile_id = ?`, profileID).Scan(&id)
+	return id, err
+}
+
+// baselineRunIDs returns every profile's baseline run, keyed by profile ID.
+func (s *Server) baselineRunIDs(ctx context.Context) (map[int64]int64, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT profile_id, run_id FROM baselines`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := map[int64]int64{}
+	for rows.Next() {
+		var profileID, runID int64
+		if err := rows.Scan(&profileID, &runID); err != nil {
+			return nil, err
+		}
+		ids[profileID] = runID
+	}
+	return ids, rows.Err()
+}
+
+func (s *Server) setBaseline(ctx context.Context, profileID, runID int64) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return withRetry(ctx, func() error {
+		_, err := s.db.ExecContext(ctx,
+			`INSERT INTO baselines (profile_id, run_id, set_at) VALUES (?, ?, ?)
+			 ON CONFLICT(profile_id) DO UPDATE SET run_id = excluded.run_id, set_at = excluded.set_at`,
+			profileID, runID, time.Now().UTC().Format(time.RFC3339))
+		return err
+	})
+}
+
+func (s *Server) setRunLabel(ctx context.Context, id int64, label string) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return withRetry(ctx, func() error {
+		res, err := s.db.ExecContext(ctx, `UPDATE runs SET label = ? WHERE id = ?`, label, id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+}
+
+func (s *Server) setRunPinned(ctx context.Context, id int64, pinned bool) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return withRetry(ctx, func() error {
+		res, err := s.db.ExecContext(ctx, `UPDATE runs SET pinned = ? WHERE id = ?`, pinned, id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+}
+
+// rotateProfileRuns deletes the runs of a profile older than its keep newest
+// checked runs, except pinned runs and baselines, along with any spilled
+// results files. Failed runs do not count towards keep, so a string of
+// failures cannot rotate out real results; they go once they are older than
+// every kept run. Each deleted run is audited as run.deleted.
+func (s *Server) rotateProfileRuns(ctx context.Context, profileID int64, keep int) error {
+	s.dbMu.RLock()
+	var paths []string
+	var deleted []int64
+	err := withRetry(ctx, func() error {
+		paths, deleted = nil, nil
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		var cutAt string
+		var cutID int64
+		err = tx.QueryRowContext(ctx,
+			`SELECT created_at, id FROM runs WHERE profile_id = ? AND exit_code <> ?
+			 ORDER BY created_at DESC, id DESC LIMIT 1 OFFSET ?`,
+			profileID, failedRunExitCode, keep-1).Scan(&cutAt, &cutID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil // fewer than keep checked runs
+		}
+		if err != nil {
+			return err
+		}
+		rows, err := tx.QueryContext(ctx,
+			`SELECT id, results_path FROM runs
+			 WHERE profile_id = ? AND pinned = 0
+			   AND id NOT IN (SELECT run_id FROM baselines)
+			   AND (created_at < ? OR (created_at = ? AND id < ?))`,
+			profileID, cutAt, cutAt, cutID)
+		if err != nil {
+			return err
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			var path string
+			if err := rows.Scan(&id, &path); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+			if path != "" {
+				paths = append(paths, s.spillPath(path))
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM runs WHERE id = ?`, id); err != nil {
+				return err
+			}
+		}
+		deleted = ids
+		return tx.Commit()
+	})
+	// auditLog takes dbMu itself.
+	s.dbMu.RUnlock()
+	if err != nil {
+		return err
+	}
+	for _, id := range deleted {
+		s.auditLog(ctx, "run.deleted", strconv.FormatInt(id, 10), "rotation")
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("rotate profile %d: %v", profileID, err)
+		}
+	}
+	return nil
+}
+
+// listRunsForProfile returns a profile's most recent runs, newest first.
+// Runs whose check failed outright (see createFailedRun) are left out: they
+// have no results to compare, rerun or count.
+func (s *Server) listRunsForProfile(ctx context.Context, profileID int64, limit int) ([]Run, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+runColumns+` FROM runs WHERE profile_id = ? AND exit_code <> ? ORDER BY created_at DESC, id DESC LIMIT ?`,
+		profileID, failedRunExitCode, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		r, err := s.scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// lastRunForProfile returns the profile's most recent checked run, or
+// sql.ErrNoRows.
+func (s *Server) lastRunForProfile(ctx context.Context, profileID int64) (Run, error) {
+	runs, err := s.listRunsForProfile(ctx, profileID, 1)
+	if err != nil {
+		return Run{}, err
+	}
+	if len(runs) == 0 {
+		return Run{}, sql.ErrNoRows
+	}
+	return runs[0], nil
+}
+
+// violationMatrix counts each violation group, across all browsers, in the
+// last limit runs of a profile. Runs whose results cannot be parsed count as
+// having no violations.
+func (s *Server) violationMatrix(ctx context.Context, profileID int64, limit int) (ViolationMatrix, error) {
+	runs, err := s.listRunsForProfile(ctx, profileID, limit)
+	if err != nil {
+		return ViolationMatrix{}, err
+	}
+	for i, j := 0, len(runs)-1; i < j; i, j = i+1, j-1 {
+		runs[i], runs[j] = runs[j], runs[i]
+	}
+
+	counts := map[string][]int{}
+	for i, run := range runs {
+		multi, err := loadMultiReport(run)
+		if err != nil {
+			continue
+		}
+		for _, rep := range multi.Browsers {
+			for _, g := range groupViolations(rep.Results, false) {
+				if counts[g.Key] == nil {
+					counts[g.Key] = make([]int, len(runs))
+				}
+				counts[g.Key][i] += g.Count
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	matrix := ViolationMatrix{Runs: runs}
+	for _, k := range keys {
+		matrix.Rows = append(matrix.Rows, MatrixRow{Key: k, Counts: counts[k]})
+	}
+	return matrix, nil
+}
+
+func (s *Server) getProfile(ctx context.Context, id int64) (Profile, error) {
+	var p Profile
+	row := s.db.QueryRowContext(ctx, `SELECT id, name, config_json, created_at, archived FROM profiles WHERE id = ?`, id)
+	if err := row.Scan(&p.ID, &p.Name, &p.ConfigJSON, &p.CreatedAt, &p.Archived); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+func (s *Server) getProfileByName(ctx context.Context, name string) (Profile, error) {
+	var p Profile
+	row := s.db.QueryRowContext(ctx, `SELECT id, name, config_json, created_at, archived FROM profiles WHERE name = ?`, name)
+	if err := row.Scan(&p.ID, &p.Name, &p.ConfigJSON, &p.CreatedAt, &p.Archived); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// runColumns is the column list scanRun expects, in order.
+const runColumns = `id, profile_id, created_at, urls_text, summary_json, results_json, exit_code, elapsed_ms, label, results_path, pinned`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanRun reads a row selected with runColumns, decompressing the stored
+// results if needed. Results too large for the database are left in their
+// spill file, so listing runs touches no files; getRun and loadMultiReport
+// read them when needed.
+func (s *Server) scanRun(row rowScanner) (Run, error) {
+	var r Run
+	var results []byte
+	var resultsPath string
+	if err := row.Scan(&r.ID, &r.ProfileID, &r.CreatedAt, &r.URLsText, &r.SummaryJSON, &results, &r.ExitCode, &r.ElapsedMs, &r.Label, &resultsPath, &r.Pinned); err != nil {
+		return r, err
+	}
+	if resultsPath != "" {
+		r.spillFile = s.spillPath(resultsPath)
+		return r, nil
+	}
+	text, err := maybeDecompress(results)
+	if err != nil {
+		return r, fmt.Errorf("run %d results: %w", r.ID, err)
+	}
+	r.ResultsJSON = text
+	return r, nil
+}
+
+// loadSpilledResults reads a spilled run's results into ResultsJSON. It does
+// nothing for runs whose results are in the database.
+func loadSpilledResults(run *Run) error {
+	if run.spillFile == "" {
+		return nil
+	}
+	results, err := os.ReadFile(run.spillFile)
+	if err != nil {
+		return fmt.Errorf("run %d results: %w", run.ID, err)
+	}
+	text, err := maybeDecompress(results)
+	if err != nil {
+		return fmt.Errorf("run %d results: %w", run.ID, err)
+	}
+	run.ResultsJSON, run.spillFile = text, ""
+	return nil
+}
+
+// listRuns returns the 100 newest runs matching where, an SQL condition on
+// runs with placeholders for args, or the 100 newest of all when where is
+// empty.
+func (s *Server) listRuns(ctx context.Context, where string, args ...any) ([]Run, error) {
+	if where != "" {
+		where = ` WHERE ` + where
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT `+runColumns+` FROM runs`+where+` ORDER BY created_at DESC LIMIT 100`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		r, err := s.scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// listRunsBetween lists runs created at or after from and before to, as
+// returned by parseRunDateRange; an empty bound is left open.
+func (s *Server) listRunsBetween(ctx context.Context, from, to string) ([]Run, error) {
+	var conds []string
+	var args []any
+	if from != "" {
+		conds, args = append(conds, `created_at >= ?`), append(args, from)
+	}
+	if to != "" {
+		conds, args = append(conds, `created_at < ?`), append(args, to)
+	}
+	return s.listRuns(ctx, strings.Join(conds, ` AND `), args...)
+}
+
+// parseRunDateRange reads the from and to query parameters as YYYY-MM-DD
+// dates in UTC and returns them as RFC3339 bounds for created_at: from is
+// the start of its day, inclusive, and to the start of the day after, to be
+// compared exclusively, so from=2024-01-01&to=2024-01-31 covers all of
+// January. A missing parameter comes back empty.
+func parseRunDateRange(q url.Values) (from, to string, err error) {
+	if v := strings.TrimSpace(q.Get("from")); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return "", "", errors.New("from must be a YYYY-MM-DD date")
+		}
+		from = t.Format(time.RFC3339)
+	}
+	if v := strings.TrimSpace(q.Get("to")); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return "", "", errors.New("to must be a YYYY-MM-DD date")
+		}
+		to = t.AddDate(0, 0, 1).Format(time.RFC3339)
+	}
+	if from != "" && to != "" && from >= to {
+		return "", "", errors.New("from must not be after to")
+	}
+	return from, to, nil
+}
+
+// listRunIDs returns every run ID, oldest first.
+func (s *Server) listRunIDs(ctx context.Context) ([]int64, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM runs ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *Server) createRun(ctx context.Context, profileID sql.NullInt64, urlsText, summaryJSON, resultsJSON string, exitCode int, elapsedMs int64) (int64, error) {
+	id, err := s.insertRun(ctx, Run{
+		ProfileID:   profileID,
+		CreatedAt:   time.Now().UTC().Format(time.RFC3339),
+		URLsText:    urlsText,
+		SummaryJSON: summaryJSON,
+		ResultsJSON: resultsJSON,
+		ExitCode:    exitCode,
+		ElapsedMs:   elapsedMs,
+	})
+	if err == nil && profileID.Valid && s.runsPerProfile > 0 {
+		if err := s.rotateProfileRuns(ctx, profileID.Int64, s.runsPerProfile); err != nil {
+			log.Printf("run %d: rotate profile %d: %v", id, profileID.Int64, err)
+		}
+	}
+	return id, err
+}
+
+// failedRunExitCode is stored for runs whose check failed outright, one above
+// the highest code csp-check.mjs itself exits with.
+const failedRunExitCode = 3
+
+// createFailedRun stores a run whose check returned runErr before producing
+// any results. Every browser gets runErr as its report error, so the run
+// page shows what went wrong, and the summary carries it for the run list.
+func (s *Server) createFailedRun(ctx context.Context, profileID sql.NullInt64, urlsText string, runErr error, elapsedMs int64) (int64, error) {
+	multi := MultiReport{
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		Config:      map[string]any{},
+		Browsers:    make(map[string]Report, len(browsers)),
+	}
+	for _, b := range browsers {
+		multi.Browsers[b] = Report{Error: runErr.Error()}
+	}
+	resultsJSON, err := json.Marshal(multi)
+	if err != nil {
+		return 0, err
+	}
+	summaryJSON, err := json.Marshal(RunSummary{Browsers: map[string]ReportTotals{}, Error: runErr.Error()})
+	if err != nil {
+		return 0, err
+	}
+	return s.createRun(ctx, profileID, urlsText, string(summaryJSON), string(resultsJSON), failedRunExitCode, elapsedMs)
+}
+
+// runError returns the error a failed run was recorded with, or "".
+func runError(run Run) string {
+	var summary RunSummary
+	if err := json.Unmarshal([]byte(run.SummaryJSON), &summary); err != nil {
+		return ""
+	}
+	return summary.Error
+}
+
+// insertRun stores run as given, including its CreatedAt and Label, under a
+// new ID.
+func (s *Server) insertRun(ctx context.Context, run Run) (int64, error) {
+	stored, path, err := s.storedResults(run.ResultsJSON)
+	if err != nil {
+		return 0, err
+	}
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	var id int64
+	err = withRetry(ctx, func() error {
+		res, err := s.db.ExecContext(ctx,
+			`INSERT INTO runs (profile_id, created_at, urls_text, summary_json, results_json, exit_code, elapsed_ms, label, results_path)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			run.ProfileID, run.CreatedAt, run.URLsText, run.SummaryJSON, stored, run.ExitCode, run.ElapsedMs, run.Label, path,
+		)
+		if err != nil {
+			return err
+		}
+		id, err = res.LastInsertId()
+		return err
+	})
+	if err != nil && path != "" {
+		_ = os.Remove(s.spillPath(path))
+	}
+	return id, err
+}
+
+// storedResults decides how a run's results are stored. Within
+// maxResultsBytes they go into results_json as usual, gzipped if
+// compressResults is set. Larger results are gzipped regardless, and if that
+// is still too large they are written gzipped to a file in spillDir instead:
+// results_json is left empty and the file's name is returned for
+// results_path, so the database and spill directory can be moved together.
+func (s *Server) storedResults(resultsJSON string) (stored any, path string, err error) {
+	stored, err = maybeCompress(resultsJSON, s.compressResults)
+	if err != nil || s.maxResultsBytes <= 0 || storedLen(stored) <= s.maxResultsBytes {
+		return stored, "", err
+	}
+	if stored, err = maybeCompress(resultsJSON, true); err != nil {
+		return nil, "", err
+	}
+	if storedLen(stored) <= s.maxResultsBytes {
+		return stored, "", nil
+	}
+	if err := os.MkdirAll(s.spillDir, 0o750); err != nil {
+		return nil, "", err
+	}
+	f, err := os.CreateTemp(s.spillDir, "run-*.json.gz")
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := f.Write(stored.([]byte)); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return nil, "", err
+	}
+	return []byte{}, filepath.Base(f.Name()), nil
+}
+
+// spillPath resolves a stored results_path against spillDir. Runs spilled
+// before paths were stored relative keep their absolute path.
+func (s *Server) spillPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(s.spillDir, path)
+}
+
+// storedLen is the size of a maybeCompress result.
+func storedLen(stored any) int {
+	switch v := stored.(type) {
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	}
+	return 0
+}
+
+func (s *Server) getRun(ctx context.Context, id int64) (Run, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+runColumns+` FROM runs WHERE id = ?`, id)
+	run, err := s.scanRun(row)
+	if err != nil {
+		return run, err
+	}
+	return run, loadSpilledResults(&run)
+}
+
+// gzipMagic prefixes gzip streams; stored results starting with it are
+// compressed, anything else is plain JSON from before compression existed
+// or with it disabled.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// maybeCompress gzips data when enabled. The result is stored as a BLOB;
+// uncompressed data is stored as TEXT as before.
+func maybeCompress(data string, enabled bool) (any, error) {
+	if !enabled {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(data)); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// maybeDecompress returns stored results as text, gunzipping them when they
+// carry the gzip magic bytes.
+func maybeDecompress(data []byte) (string, error) {
+	if !bytes.HasPrefix(data, gzipMagic) {
+		return string(data), nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func parseProfileID(raw string) sql.NullInt64 {
+	id, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+	if err != nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: id, Valid: true}
+}
+
+// resolveConfig loads the config for profileID, falling back to the default
+// profile when no profile is given and to defaultConfig when a profile cannot
+// be loaded. A profile whose stored config is invalid is an errInvalidConfig
+// error rather than a run with other settings.
+func (s *Server) resolveConfig(ctx context.Context, profileID sql.NullInt64) (sql.NullInt64, CSPConfig, error) {
+	var (
+		p   Profile
+		err error
+	)
+	if profileID.Valid {
+		p, err = s.getProfile(ctx, profileID.Int64)
+	} else {
+		p, err = s.getProfileByName(ctx, defaultProfileName)
+		if err == nil {
+			profileID = sql.NullInt64{Int64: p.ID, Valid: true}
+		}
+	}
+	if err != nil {
+		return profileID, s.capConcurrency(defaultConfig()), nil
+	}
+	cfg, err := parseConfig(p.ConfigJSON)
+	if err != nil {
+		return profileID, CSPConfig{}, fmt.Errorf("%w for profile %q: %w", errInvalidConfig, p.Name, err)
+	}
+	return profileID, s.capConcurrency(cfg), nil
+}
+
+// capConcurrency lowers cfg.Concurrency to maxConcurrency, so profiles saved
+// under a higher cap, or before it was lowered, still run within it.
+func (s *Server) capConcurrency(cfg CSPConfig) CSPConfig {
+	if s.maxConcurrency > 0 && cfg.Concurrency > s.maxConcurrency {
+		cfg.Concurrency = s.maxConcurrency
+	}
+	return cfg
+}
+
+var errNoURLs = errors.New("no valid urls")
+
+var errUnknownProfile = errors.New("unknown profile")
+
+// errInvalidConfig is returned by resolveConfig for a profile whose stored
+// config does not parse or validate; it is not run with other settings.
+var errInvalidConfig = errors.New("invalid stored config")
+
+// executeRun checks the URLs in urlsText under cfg and stores the run.
+// Violations the list marks with "expect:<directive>" do not fail it.
+func (s *Server) executeRun(ctx context.Context, profileID sql.NullInt64, urlsText string, cfg CSPConfig) (int64, error) {
+	expect := expectedDirectives(urlsText)
+	return s.storeCheckedRun(ctx, profileID, urlsText, func(ctx context.Context, urls []string) (MultiReport, int, error) {
+		report, exitCode, err := s.checkURLs(ctx, urls, cfg)
+		if err != nil || len(expect) == 0 {
+			return report, exitCode, err
+		}
+		return report, computeExitCode(withoutExpected(report, expect), exitCode, cfg), nil
+	})
+}
+
+// checkURLs runs the checker once, or twice keeping only the stable
+// violations when cfg de-flakes.
+func (s *Server) checkURLs(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
+	check := s.runChecker().Check
+	report, exitCode, err := check(ctx, urls, cfg)
+	if err != nil || !cfg.DeFlake {
+		return report, exitCode, err
+	}
+	second, secondExit, err := check(ctx, urls, cfg)
+	if err != nil {
+		return MultiReport{}, 0, err
+	}
+	nodeExit := exitCode
+	if secondExit > nodeExit {
+		nodeExit = secondExit
+	}
+	if nodeExit <= 1 {
+		nodeExit = 0
+	}
+	report, report.Flaky = intersectReports(report, second)
+	applyViolationThreshold(&report, cfg.MaxViolationsPerPage)
+	if report.Config == nil {
+		report.Config = map[string]any{}
+	}
+	report.Config["deFlake"] = true
+	return report, computeExitCode(report, nodeExit, cfg), nil
+}
+
+// storeCheckedRun checks the URLs in urlsText with check and stores the
+// run, or a failed run when check fails.
+func (s *Server) storeCheckedRun(ctx context.Context, profileID sql.NullInt64, urlsText string, check func(ctx context.Context, urls []string) (MultiReport, int, error)) (int64, error) {
+	urls := parseURLList(urlsText)
+	if len(urls) == 0 {
+		return 0, errNoURLs
+	}
+
+	s.activeRuns.Add(1)
+	defer s.activeRuns.Add(-1)
+	start := time.Now()
+	report, exitCode, err := check(ctx, urls)
+	elapsed := time.Since(start)
+	if err != nil {
+		// A check canceled because its request went away is not a failure of
+		// the site. Anything else is recorded even if ctx has since expired.
+		if errors.Is(err, context.Canceled) {
+			return 0, fmt.Errorf("csp check failed: %w", err)
+		}
+		failedID, saveErr := s.createFailedRun(context.WithoutCancel(ctx), profileID, urlsText, err, elapsed.Milliseconds())
+		if saveErr != nil {
+			log.Printf("record failed run: %v", saveErr)
+			return 0, fmt.Errorf("csp check failed: %w", err)
+		}
+		return failedID, fmt.Errorf("csp check failed (recorded as run #%d): %w", failedID, err)
+	}
+	report = truncateSamples(report, s.maxSampleLen)
+	maskResponseHeaders(report)
+
+	resultsJSON, err := json.Marshal(report)
+	if err != nil {
+		return 0, errors.New("marshal results failed")
+	}
+	summary := summarizeMulti(report)
+	summaryJSON, err := json.Marshal(summary)
+	if err != nil {
+		return 0, errors.New("marshal summary failed")
+	}
+
+	runID, err := s.createRun(ctx, profileID, urlsText, string(summaryJSON), string(resultsJSON), exitCode, elapsed.Milliseconds())
+	if err != nil {
+		return 0, errors.New("save run failed")
+	}
+	if profileID.Valid {
+		at := time.Now().UTC().Format(time.RFC3339)
+		seen, checked := violationScopes(report)
+		if err := s.trackViolations(ctx, profileID.Int64, runID, at, seen, checked); err != nil {
+			log.Printf("run %d: violation tracking: %v", runID, err)
+		}
+	}
+	payload := WebhookPayload{
+		Event:      "run.completed",
+		RunID:      runID,
+		ExitCode:   exitCode,
+		Pages:      summary.Pages,
+		Violations: summary.Violations,
+	}
+	if profileID.Valid {
+		payload.ProfileID = &profileID.Int64
+	}
+	if s.webhook != nil {
+		go s.webhook.send(payload)
+	}
+	if profileID.Valid {
+		if _, cfg, err := s.resolveConfig(ctx, profileID); err == nil && cfg.NotifyWebhook != "" && summary.Violations > cfg.NotifyThreshold {
+			profilePayload := payload
+			profilePayload.Event = "run.over_threshold"
+			go s.profileNotifier(cfg.NotifyWebhook).send(profilePayload)
+		}
+	}
+	if s.postRunHook != nil {
+		go s.postRunHook.run(payload)
+	}
+	return runID, nil
+}
+
+// intersectReports keeps the violations of a that b also reported for the
+// same browser and page, matched by violationSignature and counted, so a
+// violation a reported twice needs two matches. Everything unmatched on
+// either side is returned as flaky. Browsers only one pass has are kept from
+// a unchanged, since there is nothing to compare them with.
+func intersectReports(a, b MultiReport) (stable MultiReport, flaky []Violation) {
+	stable = a
+	stable.Browsers = make(map[string]Report, len(a.Browsers))
+	for name, repA := range a.Browsers {
+		repB, ok := b.Browsers[name]
+		if !ok || repA.Error != "" || repB.Error != "" {
+			stable.Browsers[name] = repA
+			continue
+		}
+		pageKey := func(r ReportPageResult) string { return r.Device + " " + r.URL }
+		// remaining counts b's violations per page and signature not yet
+		// matched against a.
+		remaining := map[string]map[string]int{}
+		for _, r := range repB.Results {
+			k := pageKey(r)
+			if remaining[k] == nil {
+				remaining[k] = map[string]int{}
+			}
+			for _, v := range r.Violations {
+				remaining[k][violationSignature(v)]++
+			}
+		}
+		rep := repA
+		rep.Results = make([]ReportPageResult, len(repA.Results))
+		rep.Totals.Violations = 0
+		for i, r := range repA.Results {
+			k := pageKey(r)
+			var kept []Violation
+			for _, v := range r.Violations {
+				sig := violationSignature(v)
+				if remaining[k][sig] > 0 {
+					remaining[k][sig]--
+					kept = append(kept, v)
+				} else {
+					flaky = append(flaky, v)
+				}
+			}
+			if kept == nil {
+				kept = []Violation{}
+			}
+			r.Violations = kept
+			rep.Totals.Violations += len(kept)
+			rep.Results[i] = r
+		}
+		for _, r := range repB.Results {
+			k := pageKey(r)
+			for _, v := range r.Violations {
+				sig := violationSignature(v)
+				if remaining[k][sig] > 0 {
+					remaining[k][sig]--
+					flaky = append(flaky, v)
+				}
+			}
+		}
+		stable.Browsers[name] = rep
+	}
+	return stable, flaky
+}
+
+// WebhookPayload is POSTed as JSON to CSP_WEBHOOK_URL after each run.
+type WebhookPayload struct {
+	Event      string `json:"event"`
+	RunID      int64  `json:"runId"`
+	ProfileID  *int64 `json:"profileId"`
+	ExitCode   int    `json:"exitCode"`
+	Pages      int    `json:"pages"`
+	Violations int    `json:"violations"`
+}
+
+// webhookNotifier delivers run notifications, retrying failures with
+// exponential backoff. All attempts share one deadline so a dead endpoint
+// cannot hold a goroutine for long.
+type webhookNotifier struct {
+	url       string
+	client    *http.Client
+	attempts  int
+	baseDelay time.Duration
+	deadline  time.Duration
+}
+
+// profileNotifier delivers to a profile's NotifyWebhook with the server's
+// retry settings.
+func (s *Server) profileNotifier(target string) *webhookNotifier {
+	n := s.webhookRetry
+	n.url = target
+	if n.client == nil {
+		n.client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if n.deadline <= 0 {
+		n.deadline = time.Minute
+	}
+	return &n
+}
+
+// send delivers payload in the background; failures end up in the log.
+func (n *webhookNotifier) send(payload WebhookPayload) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.deadline)
+	defer cancel()
+	if err := n.notify(ctx, payload); err != nil {
+		body, _ := json.Marshal(payload)
+		log.Printf("webhook: dead letter for run %d: %v: %s", payload.RunID, err, body)
+	}
+}
+
+// notify POSTs payload until the endpoint answers 2xx, the attempts run out
+// or ctx expires.
+func (n *webhookNotifier) notify(ctx context.Context, payload WebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	attempts := n.attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := n.baseDelay
+	var lastErr error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("after %d attempts: %w (last error: %v)", i, ctx.Err(), lastErr)
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+		lastErr = n.post(ctx, body)
+		if lastErr == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("after %d attempts: %w", attempts, lastErr)
+}
+
+func (n *webhookNotifier) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// postRunHook runs CSP_POST_RUN_CMD through sh after each run, with the run
+// in CSP_RUN_* variables and the webhook payload as JSON on stdin.
+type postRunHook struct {
+	command string
+	timeout time.Duration
+}
+
+// maxHookOutput caps how much of a failed hook's output is logged.
+const maxHookOutput = 4 << 10
+
+// run executes the command and waits for it; failures and timeouts end up in
+// the log and never affect the run.
+func (h *postRunHook) run(payload WebhookPayload) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("post-run command for run %d: %v", payload.RunID, err)
+		return
+	}
+	profileID := ""
+	if payload.ProfileID != nil {
+		profileID = strconv.FormatInt(*payload.ProfileID, 10)
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", h.command)
+	cmd.Env = append(os.Environ(),
+		"CSP_RUN_ID="+strconv.FormatInt(payload.RunID, 10),
+		"CSP_RUN_PROFILE_ID="+profileID,
+		"CSP_RUN_EXIT_CODE="+strconv.Itoa(payload.ExitCode),
+		"CSP_RUN_PAGES="+strconv.Itoa(payload.Pages),
+		"CSP_RUN_VIOLATIONS="+strconv.Itoa(payload.Violations),
+	)
+	cmd.Stdin = bytes.NewReader(body)
+	// Background children of the shell can hold the output pipe open past
+	// the timeout; stop waiting for them shortly after.
+	cmd.WaitDelay = time.Second
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("timed out after %s", h.timeout)
+		}
+		if len(out) > maxHookOutput {
+			out = out[:maxHookOutput]
+		}
+		log.Printf("post-run command for run %d: %v: %s", payload.RunID, err, bytes.TrimSpace(out))
+	}
+}
+
+// minScheduleMinutes is the shortest allowed schedule interval.
+const minScheduleMinutes = 5
+
+// schedulerTick is how often the scheduler looks for due schedules.
+var schedulerTick = time.Minute
+
+// scheduler triggers runs for due schedules. Due times live in the schedules
+// table, so nothing is lost across restarts; a schedule missed while the
+// server was down runs once on the next tick rather than catching up.
+type scheduler struct {
+	s    *Server
+	now  func() time.Time
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+func newScheduler(s *Server, now func() time.Time) *scheduler {
+	return &scheduler{s: s, now: now}
+}
+
+// Start runs the scheduler loop until ctx is cancelled or Stop is called.
+func (sc *scheduler) Start(ctx context.Context) {
+	ctx, sc.stop = context.WithCancel(ctx)
+	sc.done = make(chan struct{})
+	go func() {
+		defer close(sc.done)
+		ticker := time.NewTicker(schedulerTick)
+		defer ticker.Stop()
+		for {
+			sc.runDue(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop cancels any run in progress and waits for the loop to exit.
+func (sc *scheduler) Stop() {
+	if sc.stop == nil {
+		return
+	}
+	sc.stop()
+	<-sc.done
+}
+
+// runDue runs every due schedule once, one after another, and moves each to
+// its next slot. A failed run is logged and retried at the next interval.
+// Nothing is started while the scheduler is paused.
+func (sc *scheduler) runDue(ctx context.Context) {
+	if sc.s.schedulerPaused.Load() {
+		return
+	}
+	now := sc.now()
+	due, err := sc.s.dueSchedules(ctx, now)
+	if err != nil {
+		log.Printf("scheduler: %v", err)
+		return
+	}
+	for _, sched := range due {
+		if ctx.Err() != nil || sc.s.schedulerPaused.Load() {
+			return
+		}
+		var runID sql.NullInt64
+		profileID, cfg, err := sc.s.resolveConfig(ctx, sched.ProfileID)
+		var id int64
+		if err == nil {
+			id, err = sc.s.executeRun(ctx, profileID, sched.URLsText, cfg)
+		}
+		if err != nil {
+			log.Printf("scheduler: schedule %d: %v", sched.ID, err)
+		} else {
+			runID = sql.NullInt64{Int64: id, Valid: true}
+			sc.s.auditLog(ctx, "run.created", strconv.FormatInt(id, 10), "scheduler")
+		}
+		next := now.Add(time.Duration(sched.IntervalMinutes) * time.Minute)
+		if err := sc.s.markScheduleRun(ctx, sched.ID, runID, next); err != nil {
+			log.Printf("scheduler: schedule %d: %v", sched.ID, err)
+		}
+	}
+}
+
+// maskedHeaders are never stored, in case a customised node script does not
+// mask them itself.
+var maskedHeaders = map[string]bool{
+	"cookie":              true,
+	"set-cookie":          true,
+	"authorization":       true,
+	"proxy-authorization": true,
+	"www-authenticate":    true,
+	"proxy-authenticate":  true,
+}
+
+func maskResponseHeaders(multi MultiReport) {
+	for _, rep := range multi.Browsers {
+		for _, res := range rep.Results {
+			for name := range res.Headers {
+				if maskedHeaders[strings.ToLower(name)] {
+					res.Headers[name] = "[masked]"
+				}
+			}
+		}
+	}
+}
+
+// cspHeaderNames are shown first on the run detail page.
+var cspHeaderNames = []string{"content-security-policy", "content-security-policy-report-only"}
+
+// HeaderLine is one response header for display.
+type HeaderLine struct {
+	Name  string
+	Value string
+	CSP   bool
+}
+
+// sortedHeaders lists headers with the CSP headers first, then by name.
+func sortedHeaders(headers map[string]string) []HeaderLine {
+	out := make([]HeaderLine, 0, len(headers))
+	for name, value := range headers {
+		out = append(out, HeaderLine{Name: name, Value: value, CSP: containsString(cspHeaderNames, strings.ToLower(name))})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CSP != out[j].CSP {
+			return out[i].CSP
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// truncateSamples returns a copy of multi with violation samples longer than
+// max characters cut down and marked with an ellipsis and SampleTruncated.
+// Inline script samples can be large and are rarely needed in full.
+func truncateSamples(multi MultiReport, max int) MultiReport {
+	if max <= 0 {
+		return multi
+	}
+	out := multi
+	out.Browsers = make(map[string]Report, len(multi.Browsers))
+	for name, rep := range multi.Browsers {
+		results := make([]ReportPageResult, len(rep.Results))
+		for i, r := range rep.Results {
+			vs := make([]Violation, len(r.Violations))
+			for j, v := range r.Violations {
+				if runes := []rune(v.Sample); len(runes) > max {
+					v.Sample = string(runes[:max]) + "…"
+					v.SampleTruncated = true
+				}
+				vs[j] = v
+			}
+			r.Violations = vs
+			results[i] = r
+		}
+		rep.Results = results
+		out.Browsers[name] = rep
+	}
+	return out
+}
+
+func runErrorStatus(err error) int {
+	if errors.Is(err, errNoURLs) || errors.Is(err, errUnknownProfile) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, errInvalidConfig) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// Checker runs the browsers over urls with cfg and returns the merged report
+// and the run's exit code.
+type Checker interface {
+	Check(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error)
+}
+
+// CheckerFunc adapts a function to Checker.
+type CheckerFunc func(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error)
+
+func (f CheckerFunc) Check(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
+	return f(ctx, urls, cfg)
+}
+
+// LocalChecker runs csp-check.mjs with a local node; see runCSPCheck.
+type LocalChecker struct {
+	// Pool is how many node processes may run at once (CSP_WORKER_POOL).
+	Pool int
+}
+
+func (c LocalChecker) Check(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
+	return runCSPCheck(ctx, urls, cfg, c.Pool)
+}
+
+// runChecker returns the server's Checker, LocalChecker when none is set.
+func (s *Server) runChecker() Checker {
+	if s.checker == nil {
+		return LocalChecker{Pool: s.workerPool}
+	}
+	return s.checker
+}
+
+// maxCheckResponseBytes bounds the report an HTTPChecker reads back.
+const maxCheckResponseBytes = 64 << 20
+
+// HTTPChecker posts the URLs and config as JSON, {"urls": [...], "config":
+// {...}}, to a remote check service (CSP_CHECK_URL) that answers with a
+// MultiReport, so the server itself needs no node or Playwright. The config
+// is sent in full, basic auth password included, as the service needs it.
+type HTTPChecker struct {
+	URL    string
+	Client *http.Client
+}
+
+func (c *HTTPChecker) Check(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
+	body, err := json.Marshal(map[string]any{"urls": urls, "config": cfg})
+	if err != nil {
+		return MultiReport{}, 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return MultiReport{}, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return MultiReport{}, 0, fmt.Errorf("check service: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return MultiReport{}, 0, fmt.Errorf("check service returned status %d", resp.StatusCode)
+	}
+	var multi MultiReport
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxCheckResponseBytes)).Decode(&multi); err != nil {
+		return MultiReport{}, 0, fmt.Errorf("check service report: %w", err)
+	}
+	if len(multi.Browsers) == 0 {
+		return MultiReport{}, 0, errors.New("check service returned no browser reports")
+	}
+	// Browser errors count like a local node exit of 2: the run is
+	// incomplete, but fails outright only when no browser finished.
+	nodeExit := 0
+	var msgs []string
+	for _, b := range browsers {
+		if rep, ok := multi.Browsers[b]; ok && rep.Error != "" {
+			msgs = append(msgs, rep.Error)
+			nodeExit = 2
+		}
+	}
+	if len(msgs) == len(multi.Browsers) {
+		return MultiReport{}, nodeExit, errors.New(strings.Join(msgs, "; "))
+	}
+	if multi.GeneratedAt == "" {
+		multi.GeneratedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	applyViolationThreshold(&multi, cfg.MaxViolationsPerPage)
+	return multi, computeExitCode(multi, nodeExit, cfg), nil
+}
+
+// runCSPCheck splits urls into pool chunks and checks every browser, device
+// and chunk with at most pool node processes at once.
+func runCSPCheck(ctx context.Context, urls []string, cfg CSPConfig, pool int) (MultiReport, int, error) {
+	nodeBin := envDefault("CSP_NODE_BIN", "node")
+	scriptPath := envDefault("CSP_SCRIPT_PATH", "./csp-check.mjs")
+	browserReports := make(map[string]Report, len(browsers))
+	maxExit := 0
+
+	tmpDir, err := os.MkdirTemp("", "csp-check-")
+	if err != nil {
+		return MultiReport{}, 0, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	preActionsEnv := ""
+	if len(cfg.PreActions) > 0 {
+		b, err := json.Marshal(cfg.PreActions)
+		if err != nil {
+			return MultiReport{}, 0, err
+		}
+		preActionsEnv = string(b)
+	}
+
+	if pool < 1 {
+		pool = 1
+	}
+	chunks := chunkURLs(urls, pool)
+	chunkFiles := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		chunkFiles[i] = filepath.Join(tmpDir, fmt.Sprintf("urls-%d.txt", i))
+		if err := os.WriteFile(chunkFiles[i], []byte(strings.Join(chunk, "\n")), 0644); err != nil {
+			return MultiReport{}, 0, err
+		}
+	}
+
+	devices := cfg.Devices
+	if len(devices) == 0 {
+		devices = []string{""}
+	}
+	// Units are ordered browser, device, chunk; results are read back in
+	// the same order below.
+	var units []checkUnit
+	for _, browser := range browsers {
+		for _, device := range devices {
+			for i, file := range chunkFiles {
+				name := browser
+				if device != "" {
+					name = browser + "-" + device
+				}
+				if len(chunkFiles) > 1 {
+					name = fmt.Sprintf("%s#%d", name, i+1)
+				}
+				units = append(units, checkUnit{
+					name:     name,
+					browser:  browser,
+					device:   device,
+					urlsFile: file,
+					jsonFile: filepath.Join(tmpDir, fmt.Sprintf("report-%d.json", len(units))),
+				})
+			}
+		}
+	}
+
+	results := make([]unitResult, len(units))
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg           sync.WaitGroup
+		errMu        sync.Mutex
+		firstErr     *unitResult
+		thresholdHit atomic.Bool
+	)
+	sem := make(chan struct{}, pool)
+	for i, unit := range units {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, unit checkUnit) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := runCtx.Err(); err != nil {
+				results[i] = unitResult{err: err}
+				return
+			}
+			if thresholdHit.Load() {
+				results[i] = unitResult{skipped: true}
+				return
+			}
+			res := runNodeUnit(runCtx, nodeBin, scriptPath, unit, cfg, preActionsEnv)
+			results[i] = res
+			if res.err == nil && overViolationThreshold(res.report, cfg.MaxViolationsPerPage) {
+				thresholdHit.Store(true)
+			}
+			var incomplete *incompleteReportError
+			if res.err != nil && !errors.As(res.err, &incomplete) {
+				errMu.Lock()
+				if firstErr == nil {
+					firstErr = &res
+					cancel()
+				}
+				errMu.Unlock()
+			}
+		}(i, unit)
+	}
+	wg.Wait()
+
+	for _, res := range results {
+		if res.exitCode > maxExit {
+			maxExit = res.exitCode
+		}
+	}
+	if firstErr != nil {
+		return MultiReport{}, firstErr.exitCode, firstErr.err
+	}
+
+	k := 0
+	for _, browser := range browsers {
+		var parts []Report
+		var incompleteErr error
+		skipped := false
+		for range devices {
+			var chunkParts []Report
+			for range chunkFiles {
+				res := results[k]
+				k++
+				if res.skipped {
+					skipped = true
+					continue
+				}
+				if res.err != nil {
+					log.Printf("%v", res.err)
+					incompleteErr = res.err
+					if maxExit < 2 {
+						maxExit = 2
+					}
+					continue
+				}
+				chunkParts = append(chunkParts, res.report)
+			}
+			if len(chunkParts) > 0 {
+				parts = append(parts, mergeReports(chunkParts, true))
+			}
+		}
+		if len(parts) == 0 {
+			if incompleteErr == nil && skipped {
+				browserReports[browser] = Report{Error: browser + ": skipped, a page went over the violation threshold"}
+				continue
+			}
+			browserReports[browser] = Report{Error: incompleteErr.Error()}
+			continue
+		}
+		browserReports[browser] = mergeReports(parts, false)
+	}
+
+	failed := 0
+	for _, rep := range browserReports {
+		if rep.Error != "" {
+			failed++
+		}
+	}
+	if failed == len(browserReports) {
+		var msgs []string
+		for _, b := range browsers {
+			if rep, ok := browserReports[b]; ok {
+				msgs = append(msgs, rep.Error)
+			}
+		}
+		return MultiReport{}, maxExit, errors.New(strings.Join(msgs, "; "))
+	}
+
+	multi := MultiReport{
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		Config: map[string]any{
+			"waitUntil":      cfg.WaitUntil,
+			"navTimeoutMs":   cfg.NavTimeoutMs,
+			"settleWaitMs":   cfg.SettleWaitMs,
+			"concurrency":    cfg.Concurrency,
+			"betweenUrlMs":   cfg.BetweenURLMs,
+			"userAgent":      cfg.UserAgent,
+			"acceptLanguage": cfg.AcceptLanguage,
+		},
+		Browsers: browserReports,
+	}
+	if cfg.BasicAuthUser != "" {
+		multi.Config["basicAuthUser"] = cfg.BasicAuthUser
+	}
+	if cfg.FailOnReportOnly {
+		multi.Config["failOnReportOnly"] = true
+	}
+	if cfg.TimeoutMultiplier != 0 && cfg.TimeoutMultiplier != 1 {
+		multi.Config["timeoutMultiplier"] = cfg.TimeoutMultiplier
+	}
+	if cfg.MaxViolationsPerPage > 0 {
+		multi.Config["maxViolationsPerPage"] = cfg.MaxViolationsPerPage
+		applyViolationThreshold(&multi, cfg.MaxViolationsPerPage)
+	}
+	if cfg.DisableJS {
+		multi.Config["disableJs"] = true
+	}
+	if cfg.IgnoreTLSErrors {
+		multi.Config["ignoreTlsErrors"] = true
+	}
+	if cfg.CaptureHeaders {
+		multi.Config["captureHeaders"] = true
+	}
+	if len(cfg.ExcludePatterns) > 0 {
+		multi.Config["excludePatterns"] = cfg.ExcludePatterns
+	}
+	if len(cfg.ExtraArgs) > 0 {
+		multi.Config["extraArgs"] = cfg.ExtraArgs
+	}
+	if len(cfg.Devices) > 0 {
+		multi.Config["devices"] = cfg.Devices
+	}
+	if len(cfg.PreActions) > 0 {
+		// Fill values may hold credentials, so only the count is recorded.
+		multi.Config["preActions"] = len(cfg.PreActions)
+	}
+	return multi, computeExitCode(multi, maxExit, cfg), nil
+}
+
+// checkUnit is one node invocation: a browser, optionally emulating a
+// device, over one chunk of the run's URLs.
+type checkUnit struct {
+	name     string
+	browser  string
+	device   string
+	urlsFile string
+	jsonFile string
+}
+
+type unitResult struct {
+	report   Report
+	exitCode int
+	err      error
+	// skipped is set for units never started because an earlier one went
+	// over MaxViolationsPerPage.
+	skipped bool
+}
+
+// runNodeUnit runs the node script for a single unit. An
+// *incompleteReportError means the unit's report was unusable but the run
+// can continue; any other error fails the run.
+func runNodeUnit(ctx context.Context, nodeBin, scriptPath string, unit checkUnit, cfg CSPConfig, preActionsEnv string) unitResult {
+	args := append([]string{scriptPath, unit.urlsFile}, cfg.ExtraArgs...)
+	cmd := exec.CommandContext(ctx, nodeBin, args...)
+	cmd.Env = append(os.Environ(),
+		"CSP_OUTPUT_JSON=1",
+		"CSP_OUTPUT_FILE="+unit.jsonFile,
+		"CSP_VERBOSE=0",
+		"CSP_BROWSER="+unit.browser,
+	)
+	cmd.Env = append(cmd.Env, configEnv(cfg, preActionsEnv)...)
+	cmd.Env = append(cmd.Env, deviceEnv(unit.device)...)
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return unitResult{err: err}
+	}
+	if err := cmd.Start(); err != nil {
+		return unitResult{err: err}
+	}
+
+	stderrData := pumpStderr(unit.name, stderr, nodeLogs)
+	waitErr := cmd.Wait()
+	exitCode := exitCodeFromState(cmd.ProcessState, waitErr)
+	if waitErr != nil {
+		// Still try to parse JSON if it exists.
+		if _, statErr := os.Stat(unit.jsonFile); statErr != nil {
+			return unitResult{exitCode: exitCode, err: fmt.Errorf("node failed (%s): %v: %s", unit.name, waitErr, strings.TrimSpace(string(stderrData)))}
+		}
+	}
+
+	data, err := os.ReadFile(unit.jsonFile)
+	if err != nil {
+		return unitResult{exitCode: exitCode, err: err}
+	}
+	report, err := parseBrowserReport(unit.name, data)
+	if err != nil {
+		return unitResult{exitCode: exitCode, err: err}
+	}
+	for i := range report.Results {
+		report.Results[i].Device = unit.device
+	}
+	return unitResult{report: report, exitCode: exitCode}
+}
+
+// effectiveTimeouts returns NavTimeoutMs and SettleWaitMs scaled by
+// TimeoutMultiplier, the values a run actually uses.
+func (cfg CSPConfig) effectiveTimeouts() (navMs, settleMs int) {
+	m := cfg.TimeoutMultiplier
+	if m == 0 {
+		m = 1
+	}
+	return int(math.Round(float64(cfg.NavTimeoutMs) * m)), int(math.Round(float64(cfg.SettleWaitMs) * m))
+}
+
+// configEnv is the environment csp-check.mjs reads a profile's settings
+// from.
+func configEnv(cfg CSPConfig, preActionsEnv string) []string {
+	navMs, settleMs := cfg.effectiveTimeouts()
+	return []string{
+		"CSP_WAIT_UNTIL=" + cfg.WaitUntil,
+		"CSP_NAV_TIMEOUT_MS=" + strconv.Itoa(navMs),
+		"CSP_WAIT_MS=" + strconv.Itoa(settleMs),
+		"CSP_CONCURRENCY=" + strconv.Itoa(cfg.Concurrency),
+		"CSP_BETWEEN_URL_MS=" + strconv.Itoa(cfg.BetweenURLMs),
+		"CSP_USER_AGENT=" + cfg.UserAgent,
+		"CSP_ACCEPT_LANGUAGE=" + cfg.AcceptLanguage,
+		"CSP_BASIC_AUTH_USER=" + cfg.BasicAuthUser,
+		"CSP_BASIC_AUTH_PASS=" + cfg.BasicAuthPass,
+		"CSP_DISABLE_JS=" + boolEnv(cfg.DisableJS),
+		"CSP_IGNORE_TLS=" + boolEnv(cfg.IgnoreTLSErrors),
+		"CSP_CAPTURE_HEADERS=" + boolEnv(cfg.CaptureHeaders),
+		"CSP_PRE_ACTIONS=" + preActionsEnv,
+	}
+}
+
+// chunkURLs splits urls into at most n chunks of near-equal size, keeping
+// their order, so a pool of n workers can share one browser's pages.
+func chunkURLs(urls []string, n int) [][]string {
+	if n <= 1 || len(urls) <= 1 {
+		return [][]string{urls}
+	}
+	size := (len(urls) + n - 1) / n
+	var chunks [][]string
+	for start := 0; start < len(urls); start += size {
+		end := start + size
+		if end > len(urls) {
+			end = len(urls)
+		}
+		chunks = append(chunks, urls[start:end])
+	}
+	return chunks
+}
+
+// mergeReports joins one browser's reports in order, keeping the first
+// report's other fields. disjoint says the parts checked different URLs, as
+// URL chunks do, so their page counts add up; per-device reports of the same
+// URLs keep Totals.Pages at the number of URLs rather than page loads.
+func mergeReports(parts []Report, disjoint bool) Report {
+	merged := parts[0]
+	merged.Results = append([]ReportPageResult(nil), parts[0].Results...)
+	for _, rep := range parts[1:] {
+		if disjoint {
+			merged.Totals.Pages += rep.Totals.Pages
+		}
+		merged.Totals.Violations += rep.Totals.Violations
+		merged.Results = append(merged.Results, rep.Results...)
+		if rep.Settled != nil && !*rep.Settled {
+			merged.Settled = rep.Settled
 		}
-		profiles = append(profiles, p)
 	}
-	return profiles, rows.Err()
+	return merged
 }
 
-func (s *Server) createProfile(ctx context.Context, name, configJSON string) error {
-	_, err := s.db.ExecContext(ctx,
-		`INSERT INTO profiles (name, config_json, created_at) VALUES (?, ?, ?)`,
-		name, configJSON, time.Now().UTC().Format(time.RFC3339),
-	)
-	return err
+// DeviceProfile is a viewport and user agent to emulate. Mobile also turns
+// on touch and mobile layout where the engine supports it.
+type DeviceProfile struct {
+	Width     int
+	Height    int
+	Mobile    bool
+	UserAgent string
 }
 
-func (s *Server) updateProfile(ctx context.Context, id int64, name, configJSON string) error {
-	_, err := s.db.ExecContext(ctx,
-		`UPDATE profiles SET name = ?, config_json = ? WHERE id = ?`,
-		name, configJSON, id,
-	)
-	return err
+// deviceProfiles are the devices a profile can check pages under. Desktop
+// matches the script's default viewport and keeps the profile's user agent.
+var deviceProfiles = map[string]DeviceProfile{
+	"desktop": {Width: 1280, Height: 720},
+	"mobile": {Width: 393, Height: 851, Mobile: true,
+		UserAgent: "Mozilla/5.0 (Linux; Android 14; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36"},
+	"tablet": {Width: 820, Height: 1180, Mobile: true,
+		UserAgent: "Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1"},
 }
-func (s *Server) getProfile(ctx context.Context, id int64) (Profile, error) {
-	var p Profile
-	row := s.db.QueryRowContext(ctx, `SELECT id, name, config_json, created_at FROM profiles WHERE id = ?`, id)
-	if err := row.Scan(&p.ID, &p.Name, &p.ConfigJSON, &p.CreatedAt); err != nil {
-		return p, err
+
+func deviceNames() []string {
+	names := make([]string, 0, len(deviceProfiles))
+	for name := range deviceProfiles {
+		names = append(names, name)
 	}
-	return p, nil
+	sort.Strings(names)
+	return names
 }
 
-func (s *Server) getProfileByName(ctx context.Context, name string) (Profile, error) {
-	var p Profile
-	row := s.db.QueryRowContext(ctx, `SELECT id, name, config_json, created_at FROM profiles WHERE name = ?`, name)
-	if err := row.Scan(&p.ID, &p.Name, &p.ConfigJSON, &p.CreatedAt); err != nil {
-		return p, err
+// deviceEnv passes a device's emulation settings to the node script; the
+// empty device adds nothing.
+func deviceEnv(device string) []string {
+	d, ok := deviceProfiles[device]
+	if !ok {
+		return nil
 	}
-	return p, nil
+	env := []string{
+		"CSP_DEVICE=" + device,
+		"CSP_VIEWPORT_WIDTH=" + strconv.Itoa(d.Width),
+		"CSP_VIEWPORT_HEIGHT=" + strconv.Itoa(d.Height),
+		"CSP_MOBILE=" + boolEnv(d.Mobile),
+	}
+	if d.UserAgent != "" {
+		env = append(env, "CSP_USER_AGENT="+d.UserAgent)
+	}
+	return env
 }
 
-func (s *Server) listRuns(ctx context.Context) ([]Run, error) {
-	rows, err := s.db.QueryContext(ctx, `SELECT id, profile_id, created_at, urls_text, summary_json, results_json, exit_code, elapsed_ms FROM runs ORDER BY created_at DESC LIMIT 100`)
-	if err != nil {
-		return nil, err
+// LogLine is one line of node stderr, tagged with the browser that wrote it.
+type LogLine struct {
+	Browser string `json:"browser"`
+	Text    string `json:"text"`
+}
+
+// logHub fans node stderr lines out to live subscribers. Publishing never
+// blocks: a subscriber that falls behind loses lines rather than stalling
+// the check.
+type logHub struct {
+	mu   sync.Mutex
+	subs map[chan LogLine]struct{}
+}
+
+var nodeLogs = newLogHub()
+
+func newLogHub() *logHub {
+	return &logHub{subs: make(map[chan LogLine]struct{})}
+}
+
+func (h *logHub) subscribe() (<-chan LogLine, func()) {
+	ch := make(chan LogLine, 64)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.mu.Unlock()
 	}
-	defer rows.Close()
+}
 
-	var runs []Run
-	for rows.Next() {
-		var r Run
-		if err := rows.Scan(&r.ID, &r.ProfileID, &r.CreatedAt, &r.URLsText, &r.SummaryJSON, &r.ResultsJSON, &r.ExitCode, &r.ElapsedMs); err != nil {
-			return nil, err
+func (h *logHub) publish(line LogLine) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- line:
+		default:
 		}
-		runs = append(runs, r)
 	}
-	return runs, rows.Err()
 }
 
-func (s *Server) createRun(ctx context.Context, profileID sql.NullInt64, urlsText, summaryJSON, resultsJSON string, exitCode int, elapsedMs int64) (int64, error) {
-	res, err := s.db.ExecContext(ctx,
-		`INSERT INTO runs (profile_id, created_at, urls_text, summary_json, results_json, exit_code, elapsed_ms)
-		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		profileID, time.Now().UTC().Format(time.RFC3339), urlsText, summaryJSON, resultsJSON, exitCode, elapsedMs,
-	)
+// pumpStderr reads r line by line until EOF, publishing each line as it
+// arrives, and returns everything read for error messages.
+func pumpStderr(browser string, r io.Reader, hub *logHub) []byte {
+	var all bytes.Buffer
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		text := scanner.Text()
+		all.WriteString(text)
+		all.WriteByte('\n')
+		hub.publish(LogLine{Browser: browser, Text: text})
+	}
+	// Drain whatever is left (e.g. an over-long line) so node never blocks
+	// on a full pipe.
+	io.Copy(&all, r)
+	return all.Bytes()
+}
+
+// reportSchemaVersion is the newest report format this build understands.
+const reportSchemaVersion = 2
+
+// reportMigrations upgrade a decoded report document from the version it is
+// keyed by to the next one. Versions without an entry need no changes:
+// version 2 only added the schemaVersion field, so version 1 reports (which
+// have none) already decode as version 2.
+var reportMigrations = map[int]func(doc map[string]json.RawMessage) error{}
+
+// migrateReport decodes a browser report, first upgrading older shapes to
+// reportSchemaVersion. Reports without a schemaVersion are version 1;
+// reports newer than this build are rejected rather than half-read.
+func migrateReport(raw []byte) (Report, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return Report{}, err
+	}
+	version := 1
+	if v, ok := doc["schemaVersion"]; ok {
+		if err := json.Unmarshal(v, &version); err != nil {
+			return Report{}, fmt.Errorf("invalid schemaVersion: %w", err)
+		}
+	}
+	if version < 1 || version > reportSchemaVersion {
+		return Report{}, fmt.Errorf("unsupported report schema version %d (want 1-%d)", version, reportSchemaVersion)
+	}
+	if err := upgradeReportDoc(doc, version, reportSchemaVersion, reportMigrations); err != nil {
+		return Report{}, err
+	}
+
+	upgraded, err := json.Marshal(doc)
 	if err != nil {
-		return 0, err
+		return Report{}, err
 	}
-	return res.LastInsertId()
+	var report Report
+	if err := json.Unmarshal(upgraded, &report); err != nil {
+		return Report{}, err
+	}
+	report.SchemaVersion = reportSchemaVersion
+	return report, nil
 }
 
-func (s *Server) getRun(ctx context.Context, id int64) (Run, error) {
-	var r Run
-	row := s.db.QueryRowContext(ctx, `SELECT id, profile_id, created_at, urls_text, summary_json, results_json, exit_code, elapsed_ms FROM runs WHERE id = ?`, id)
-	if err := row.Scan(&r.ID, &r.ProfileID, &r.CreatedAt, &r.URLsText, &r.SummaryJSON, &r.ResultsJSON, &r.ExitCode, &r.ElapsedMs); err != nil {
-		return r, err
+// upgradeReportDoc applies migrations to doc in order, from version up to
+// latest.
+func upgradeReportDoc(doc map[string]json.RawMessage, version, latest int, migrations map[int]func(doc map[string]json.RawMessage) error) error {
+	for ; version < latest; version++ {
+		if migrate := migrations[version]; migrate != nil {
+			if err := migrate(doc); err != nil {
+				return fmt.Errorf("migrate report from v%d: %w", version, err)
+			}
+		}
 	}
-	return r, nil
+	return nil
 }
 
-func runCSPCheck(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
-	nodeBin := envDefault("CSP_NODE_BIN", "node")
-	scriptPath := envDefault("CSP_SCRIPT_PATH", "./csp-check.mjs")
-	browserReports := make(map[string]Report, len(browsers))
-	maxExit := 0
+// incompleteReportError reports a browser whose report file was cut short.
+type incompleteReportError struct {
+	browser string
+}
 
-	tmpDir, err := os.MkdirTemp("", "csp-check-")
-	if err != nil {
-		return MultiReport{}, 0, err
-	}
-	defer os.RemoveAll(tmpDir)
+func (e *incompleteReportError) Error() string {
+	return fmt.Sprintf("report for %s was incomplete, possibly due to timeout", e.browser)
+}
 
-	urlsFile := filepath.Join(tmpDir, "urls.txt")
-	if err := os.WriteFile(urlsFile, []byte(strings.Join(urls, "\n")), 0644); err != nil {
-		return MultiReport{}, 0, err
+// parseBrowserReport decodes one browser's report file. A file cut short,
+// typically because node was killed while writing it, yields an
+// *incompleteReportError so the run can keep the other browsers.
+func parseBrowserReport(browser string, data []byte) (Report, error) {
+	report, err := migrateReport(data)
+	if err == nil {
+		return report, nil
 	}
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return Report{}, &incompleteReportError{browser: browser}
+	}
+	return Report{}, fmt.Errorf("report for %s: %w", browser, err)
+}
 
-	for _, browser := range browsers {
-		jsonFile := filepath.Join(tmpDir, fmt.Sprintf("report-%s.json", browser))
-		cmd := exec.CommandContext(ctx, nodeBin, scriptPath, urlsFile)
-		cmd.Env = append(os.Environ(),
-			"CSP_OUTPUT_JSON=1",
-			"CSP_OUTPUT_FILE="+jsonFile,
-			"CSP_VERBOSE=0",
-			"CSP_WAIT_UNTIL="+cfg.WaitUntil,
-			"CSP_NAV_TIMEOUT_MS="+strconv.Itoa(cfg.NavTimeoutMs),
-			"CSP_WAIT_MS="+strconv.Itoa(cfg.SettleWaitMs),
-			"CSP_CONCURRENCY="+strconv.Itoa(cfg.Concurrency),
-			"CSP_BETWEEN_URL_MS="+strconv.Itoa(cfg.BetweenURLMs),
-			"CSP_USER_AGENT="+cfg.UserAgent,
-			"CSP_ACCEPT_LANGUAGE="+cfg.AcceptLanguage,
-			"CSP_BROWSER="+browser,
-		)
-
-		stderr, err := cmd.StderrPipe()
-		if err != nil {
-			return MultiReport{}, 0, err
-		}
-		if err := cmd.Start(); err != nil {
-			return MultiReport{}, 0, err
-		}
+func boolEnv(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
 
-		stderrData, _ := io.ReadAll(stderr)
-		waitErr := cmd.Wait()
-		exitCode := exitCodeFromState(cmd.ProcessState, waitErr)
-		if exitCode > maxExit {
-			maxExit = exitCode
-		}
-		if waitErr != nil {
-			// Still try to parse JSON if it exists.
-			if _, statErr := os.Stat(jsonFile); statErr != nil {
-				return MultiReport{}, exitCode, fmt.Errorf("node failed (%s): %v: %s", browser, waitErr, strings.TrimSpace(string(stderrData)))
+// computeExitCode derives a run's exit code from its results: 1 when any
+// enforced violation (or report-only one, with FailOnReportOnly) was found,
+// 0 otherwise. Node exit codes above 1 mean the script itself failed and are
+// kept as-is.
+func computeExitCode(multi MultiReport, nodeExit int, cfg CSPConfig) int {
+	if nodeExit > 1 {
+		return nodeExit
+	}
+	if multi.ThresholdExceeded {
+		return 1
+	}
+	for _, rep := range multi.Browsers {
+		for _, r := range rep.Results {
+			for _, v := range r.Violations {
+				if isDisposition(v.Disposition, "enforce") {
+					return 1
+				}
+				if cfg.FailOnReportOnly && isDisposition(v.Disposition, "report-only") {
+					return 1
+				}
 			}
 		}
+	}
+	return 0
+}
 
-		data, err := os.ReadFile(jsonFile)
-		if err != nil {
-			return MultiReport{}, exitCode, err
-		}
-
-		var report Report
-		if err := json.Unmarshal(data, &report); err != nil {
-			return MultiReport{}, exitCode, err
+// overViolationThreshold reports whether any page of rep has more than max
+// violations. A max of zero or less never trips.
+func overViolationThreshold(rep Report, max int) bool {
+	if max <= 0 {
+		return false
+	}
+	for _, r := range rep.Results {
+		if len(r.Violations) > max {
+			return true
 		}
-		browserReports[browser] = report
 	}
+	return false
+}
 
-	multi := MultiReport{
-		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
-		Config: map[string]any{
-			"waitUntil":      cfg.WaitUntil,
-			"navTimeoutMs":   cfg.NavTimeoutMs,
-			"settleWaitMs":   cfg.SettleWaitMs,
-			"concurrency":    cfg.Concurrency,
-			"betweenUrlMs":   cfg.BetweenURLMs,
-			"userAgent":      cfg.UserAgent,
-			"acceptLanguage": cfg.AcceptLanguage,
-		},
-		Browsers: browserReports,
+// applyViolationThreshold marks every page with more than max violations
+// and sets multi.ThresholdExceeded if there is one. Earlier marks are
+// cleared first, so it can be applied again after results change.
+func applyViolationThreshold(multi *MultiReport, max int) {
+	multi.ThresholdExceeded = false
+	for name, rep := range multi.Browsers {
+		for i := range rep.Results {
+			over := max > 0 && len(rep.Results[i].Violations) > max
+			rep.Results[i].OverThreshold = over
+			if over {
+				multi.ThresholdExceeded = true
+			}
+		}
+		multi.Browsers[name] = rep
 	}
-	return multi, maxExit, nil
 }
 
 func exitCodeFromErr(err error) int {
@@ -985,8 +7623,34 @@ func exitCodeFromState(state *os.ProcessState, err error) int {
 }
 
 func parseURLList(text string) []string {
+	tagged := parseTaggedURLList(text)
+	if len(tagged) == 0 {
+		return nil
+	}
+	urls := make([]string, len(tagged))
+	for i, t := range tagged {
+		urls[i] = t.URL
+	}
+	return urls
+}
+
+// TaggedURL is a URL list entry with the tags and expected violations from
+// its trailing comment, e.g. "https://example.org/login # tag:auth
+// expect:img-src".
+type TaggedURL struct {
+	URL  string
+	Tags []string
+	// Expect lists the lowercased effective directives the page is known
+	// to violate.
+	Expect []string
+}
+
+// parseTaggedURLList parses a URL list like parseURLList, keeping every
+// "tag:<name>" and "expect:<directive>" word of a URL's trailing comment.
+// The rest of the comment is dropped as before.
+func parseTaggedURLList(text string) []TaggedURL {
 	lines := strings.Split(text, "\n")
-	var urls []string
+	var urls []TaggedURL
 	for _, raw := range lines {
 		line := strings.TrimSpace(raw)
 		if line == "" {
@@ -995,7 +7659,19 @@ func parseURLList(text string) []string {
 		if strings.HasPrefix(line, "#") {
 			continue
 		}
+		var tags, expect []string
 		if idx := strings.Index(line, "#"); idx >= 0 {
+			for _, word := range strings.Fields(line[idx+1:]) {
+				if tag, ok := strings.CutPrefix(word, "tag:"); ok && tag != "" && !containsString(tags, tag) {
+					tags = append(tags, tag)
+				}
+				if directive, ok := strings.CutPrefix(word, "expect:"); ok && directive != "" {
+					directive = strings.ToLower(directive)
+					if !containsString(expect, directive) {
+						expect = append(expect, directive)
+					}
+				}
+			}
 			line = strings.TrimSpace(line[:idx])
 		}
 		if line == "" {
@@ -1003,13 +7679,271 @@ func parseURLList(text string) []string {
 		}
 		if strings.HasPrefix(line, "http://") || strings.HasPrefix(line, "https://") {
 			if normalized, ok := normalizeURL(line); ok {
-				urls = append(urls, normalized)
+				urls = append(urls, TaggedURL{URL: normalized, Tags: tags, Expect: expect})
 			}
 		}
 	}
 	return urls
 }
 
+// expectedDirectives maps each URL of the list with "expect:" annotations to
+// the directives expected there; nil when there are none.
+func expectedDirectives(urlsText string) map[string][]string {
+	var expect map[string][]string
+	for _, t := range parseTaggedURLList(urlsText) {
+		for _, directive := range t.Expect {
+			if expect == nil {
+				expect = map[string][]string{}
+			}
+			if !containsString(expect[t.URL], directive) {
+				expect[t.URL] = append(expect[t.URL], directive)
+			}
+		}
+	}
+	return expect
+}
+
+// withoutExpected returns a copy of multi without the violations expect
+// allows on their page, for deciding whether the rest should fail the run.
+func withoutExpected(multi MultiReport, expect map[string][]string) MultiReport {
+	out := multi
+	out.Browsers = make(map[string]Report, len(multi.Browsers))
+	for name, rep := range multi.Browsers {
+		results := make([]ReportPageResult, len(rep.Results))
+		for i, r := range rep.Results {
+			var kept []Violation
+			for _, v := range r.Violations {
+				if !containsString(expect[r.URL], strings.ToLower(v.EffectiveDirective)) {
+					kept = append(kept, v)
+				}
+			}
+			r.Violations = kept
+			results[i] = r
+		}
+		rep.Results = results
+		out.Browsers[name] = rep
+	}
+	return out
+}
+
+// PageExpectation compares a page's "expect:" annotations with the
+// directives its grouped violations were reported under, over all browsers.
+type PageExpectation struct {
+	URL string
+	// Expected lists the annotated directives; Seen those the page did
+	// violate and Missing those it did not.
+	Expected []string
+	Seen     []string
+	Missing  []string
+	// Unexpected counts the page's violations under other directives.
+	Unexpected int
+}
+
+// pageExpectations lists, in URL list order, the annotated pages of the run;
+// nil when the list has no "expect:" annotations.
+func pageExpectations(urlsText string, multi MultiReport) []PageExpectation {
+	results := map[string][]ReportPageResult{}
+	for _, name := range orderedBrowserNames(multi) {
+		for _, r := range multi.Browsers[name].Results {
+			results[r.URL] = append(results[r.URL], r)
+		}
+	}
+	var out []PageExpectation
+	for _, t := range parseTaggedURLList(urlsText) {
+		if len(t.Expect) == 0 {
+			continue
+		}
+		pe := PageExpectation{URL: t.URL, Expected: t.Expect}
+		for _, g := range groupViolations(results[t.URL], false) {
+			directive := strings.ToLower(g.EffectiveDirective)
+			if containsString(t.Expect, directive) {
+				if !containsString(pe.Seen, directive) {
+					pe.Seen = append(pe.Seen, directive)
+				}
+			} else {
+				pe.Unexpected += g.Count
+			}
+		}
+		for _, directive := range t.Expect {
+			if !containsString(pe.Seen, directive) {
+				pe.Missing = append(pe.Missing, directive)
+			}
+		}
+		sort.Strings(pe.Seen)
+		out = append(out, pe)
+	}
+	return out
+}
+
+// TagGroup is the pages of a run sharing a URL list tag.
+type TagGroup struct {
+	Tag        string
+	Pages      []string
+	Violations int
+}
+
+// groupPagesByTag groups the run's pages by the tags in its URL list, sorted
+// by tag. Violations are summed over browsers, like the run totals. Untagged
+// pages are left out; nil when the list has no tags.
+func groupPagesByTag(urlsText string, multi MultiReport) []TagGroup {
+	tags := map[string][]string{}
+	for _, t := range parseTaggedURLList(urlsText) {
+		for _, tag := range t.Tags {
+			if !containsString(tags[t.URL], tag) {
+				tags[t.URL] = append(tags[t.URL], tag)
+			}
+		}
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	byTag := map[string]*TagGroup{}
+	for _, rep := range multi.Browsers {
+		for _, r := range rep.Results {
+			for _, tag := range tags[r.URL] {
+				g := byTag[tag]
+				if g == nil {
+					g = &TagGroup{Tag: tag}
+					byTag[tag] = g
+				}
+				if !containsString(g.Pages, r.URL) {
+					g.Pages = append(g.Pages, r.URL)
+				}
+				g.Violations += len(r.Violations)
+			}
+		}
+	}
+	out := make([]TagGroup, 0, len(byTag))
+	for _, g := range byTag {
+		sort.Strings(g.Pages)
+		out = append(out, *g)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Tag < out[j].Tag })
+	return out
+}
+
+// TreeNode is one level of a violation tree: the root, a directive, a
+// blocked origin or a source file. Value is the number of violations below
+// it, so each node's children sum to its own Value.
+type TreeNode struct {
+	Name     string     `json:"name"`
+	Value    int        `json:"value"`
+	Children []TreeNode `json:"children,omitempty"`
+}
+
+// buildViolationTree nests every violation of every browser under its
+// effective directive, blocked origin and source file, for treemap or
+// flamegraph views. Children are sorted by count, most first, then by name.
+func buildViolationTree(multi MultiReport) TreeNode {
+	type node struct {
+		value    int
+		children map[string]*node
+	}
+	child := func(n *node, name string) *node {
+		if n.children == nil {
+			n.children = map[string]*node{}
+		}
+		c := n.children[name]
+		if c == nil {
+			c = &node{}
+			n.children[name] = c
+		}
+		c.value++
+		return c
+	}
+	root := &node{}
+	for _, rep := range multi.Browsers {
+		for _, r := range rep.Results {
+			for _, v := range r.Violations {
+				root.value++
+				origin := groupOrigin(v)
+				if origin == "" {
+					origin = "(none)"
+				}
+				file := v.SourceFile
+				if file == "" {
+					file = unknownSourceFile
+				}
+				child(child(child(root, v.EffectiveDirective), origin), file)
+			}
+		}
+	}
+	var convert func(name string, n *node) TreeNode
+	convert = func(name string, n *node) TreeNode {
+		t := TreeNode{Name: name, Value: n.value}
+		for childName, c := range n.children {
+			t.Children = append(t.Children, convert(childName, c))
+		}
+		sort.Slice(t.Children, func(i, j int) bool {
+			if t.Children[i].Value != t.Children[j].Value {
+				return t.Children[i].Value > t.Children[j].Value
+			}
+			return t.Children[i].Name < t.Children[j].Name
+		})
+		return t
+	}
+	return convert("violations", root)
+}
+
+// unknownSourceFile buckets violations the browser reported without a source
+// file, typically inline scripts and styles.
+const unknownSourceFile = "inline/unknown"
+
+// SourceFileGroup is the violations of a browser's pages that the browser
+// traced to one source file.
+type SourceFileGroup struct {
+	SourceFile string
+	Count      int
+	Pages      []string
+	// Locations holds the distinct "line:col" positions, in report order.
+	Locations []string
+}
+
+// groupBySourceFile aggregates violations by the file that caused them, so a
+// developer can see which script is responsible for the most blocks. Groups
+// are sorted by count, then file name.
+func groupBySourceFile(results []ReportPageResult) []SourceFileGroup {
+	byFile := map[string]*SourceFileGroup{}
+	for _, r := range results {
+		for _, v := range r.Violations {
+			file := strings.TrimSpace(v.SourceFile)
+			if file == "" {
+				file = unknownSourceFile
+			}
+			g := byFile[file]
+			if g == nil {
+				g = &SourceFileGroup{SourceFile: file}
+				byFile[file] = g
+			}
+			g.Count++
+			if !containsString(g.Pages, r.URL) {
+				g.Pages = append(g.Pages, r.URL)
+			}
+			if v.LineNumber != nil && *v.LineNumber > 0 {
+				loc := strconv.Itoa(*v.LineNumber)
+				if v.ColumnNumber != nil && *v.ColumnNumber > 0 {
+					loc += ":" + strconv.Itoa(*v.ColumnNumber)
+				}
+				if !containsString(g.Locations, loc) {
+					g.Locations = append(g.Locations, loc)
+				}
+			}
+		}
+	}
+	out := make([]SourceFileGroup, 0, len(byFile))
+	for _, g := range byFile {
+		sort.Strings(g.Pages)
+		out = append(out, *g)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].SourceFile < out[j].SourceFile
+	})
+	return out
+}
+
 func normalizeURL(raw string) (string, bool) {
 	u, err := url.Parse(raw)
 	if err != nil || u.Scheme == "" || u.Host == "" {
@@ -1021,28 +7955,95 @@ func normalizeURL(raw string) (string, bool) {
 	return u.String(), true
 }
 
-func groupViolations(results []ReportPageResult) []GroupedViolation {
+// syntheticOrigins maps blocked-URI schemes that have no real origin to the
+// label their violations are grouped under. Browsers report these as "data"
+// or as the whole URI, which leaves BlockedOrigin empty, "null" or the
+// URI itself.
+var syntheticOrigins = map[string]string{
+	"data": "(data: URI)",
+	"blob": "(blob: URI)",
+}
+
+// groupOrigin is the origin v is grouped under: its BlockedOrigin, or a
+// syntheticOrigins label for data: and blob: URIs.
+func groupOrigin(v Violation) string {
+	uri := strings.ToLower(strings.TrimSpace(v.BlockedURI))
+	scheme, _, _ := strings.Cut(uri, ":")
+	if label, ok := syntheticOrigins[scheme]; ok {
+		return label
+	}
+	return v.BlockedOrigin
+}
+
+// groupKey is the key violations are grouped under: the effective directive
+// and groupOrigin, without the origin's scheme when normalizeScheme is set.
+func groupKey(v Violation, normalizeScheme bool) string {
+	origin := groupOrigin(v)
+	if normalizeScheme {
+		_, origin = splitOriginScheme(origin)
+	}
+	return fmt.Sprintf("%s -> %s", v.EffectiveDirective, origin)
+}
+
+// splitOriginScheme splits "https://cdn.example" into "https" and
+// "cdn.example". Origins without a scheme, such as "inline", are returned
+// whole with an empty scheme.
+func splitOriginScheme(origin string) (string, string) {
+	scheme, rest, ok := strings.Cut(origin, "://")
+	if !ok || scheme == "" {
+		return "", origin
+	}
+	return strings.ToLower(scheme), rest
+}
+
+func groupViolations(results []ReportPageResult, normalizeScheme bool) []GroupedViolation {
 	groups := map[string]*GroupedViolation{}
 	for _, r := range results {
 		for _, v := range r.Violations {
-			key := fmt.Sprintf("%s -> %s", v.EffectiveDirective, v.BlockedOrigin)
+			key := groupKey(v, normalizeScheme)
+			origin := groupOrigin(v)
+			scheme, host := splitOriginScheme(origin)
 			g, ok := groups[key]
 			if !ok {
 				g = &GroupedViolation{
-					Key:               key,
+					Key:                key,
 					EffectiveDirective: v.EffectiveDirective,
-					BlockedOrigin:     v.BlockedOrigin,
-					Pages:             map[string][]Violation{},
+					BlockedOrigin:      origin,
+					Pages:              map[string][]Violation{},
+					PageDispositions:   map[string]string{},
+					Meta:               directiveMeta(v.EffectiveDirective),
+				}
+				if normalizeScheme {
+					g.BlockedOrigin = host
+					g.Schemes = map[string]int{}
 				}
 				groups[key] = g
 			}
 			g.Count++
+			if normalizeScheme && scheme != "" {
+				g.Schemes[scheme]++
+			}
+			disposition := "enforce"
+			if isDisposition(v.Disposition, "report-only") {
+				g.ReportOnly++
+				disposition = "report-only"
+			} else {
+				g.Enforce++
+			}
+			if prev, ok := g.PageDispositions[r.URL]; ok && prev != disposition {
+				disposition = "both"
+			}
+			g.PageDispositions[r.URL] = disposition
 			g.Pages[r.URL] = append(g.Pages[r.URL], v)
+			if r.Device != "" && !containsString(g.Devices, r.Device) {
+				g.Devices = append(g.Devices, r.Device)
+			}
 		}
 	}
 
 	ordered := make([]GroupedViolation, 0, len(groups))
 	for _, g := range groups {
+		sort.Strings(g.Devices)
 		ordered = append(ordered, *g)
 	}
 	// simple bubble sort for small lists
@@ -1056,14 +8057,14 @@ func groupViolations(results []ReportPageResult) []GroupedViolation {
 	return ordered
 }
 
-func groupViolationsMulti(browsers []BrowserReport) []MergedGroup {
+func groupViolationsMulti(browsers []BrowserReport, normalizeScheme bool) []MergedGroup {
 	var all []ReportPageResult
 	groupBrowsers := map[string]map[string]struct{}{}
 	for _, b := range browsers {
-		for _, r := range b.Report.Results {
+		for _, r := range b.analyzed() {
 			all = append(all, r)
 			for _, v := range r.Violations {
-				key := fmt.Sprintf("%s -> %s", v.EffectiveDirective, v.BlockedOrigin)
+				key := groupKey(v, normalizeScheme)
 				if _, ok := groupBrowsers[key]; !ok {
 					groupBrowsers[key] = map[string]struct{}{}
 				}
@@ -1071,7 +8072,7 @@ func groupViolationsMulti(browsers []BrowserReport) []MergedGroup {
 			}
 		}
 	}
-	grouped := groupViolations(all)
+	grouped := groupViolations(all, normalizeScheme)
 	out := make([]MergedGroup, 0, len(grouped))
 	for _, g := range grouped {
 		names := make([]string, 0, len(groupBrowsers[g.Key]))
@@ -1091,7 +8092,7 @@ func groupViolationsMulti(browsers []BrowserReport) []MergedGroup {
 	return out
 }
 
-func groupViolationsByDisposition(results []ReportPageResult, disposition string) []GroupedViolation {
+func groupViolationsByDisposition(results []ReportPageResult, disposition string, normalizeScheme bool) []GroupedViolation {
 	filtered := make([]ReportPageResult, 0, len(results))
 	for _, r := range results {
 		nr := r
@@ -1105,14 +8106,25 @@ func groupViolationsByDisposition(results []ReportPageResult, disposition string
 			filtered = append(filtered, nr)
 		}
 	}
-	return groupViolations(filtered)
+	return groupViolations(filtered, normalizeScheme)
+}
+
+// promotionRisk groups the report-only violations of browsers, leaving out
+// excluded pages: what would start being blocked if the report-only policy
+// were enforced as it stands.
+func promotionRisk(browsers []BrowserReport) []GroupedViolation {
+	var results []ReportPageResult
+	for _, b := range browsers {
+		results = append(results, b.analyzed()...)
+	}
+	return groupViolationsByDisposition(results, "report-only", false)
 }
 
-func groupViolationsMultiByDisposition(browsers []BrowserReport, disposition string) []MergedGroup {
+func groupViolationsMultiByDisposition(browsers []BrowserReport, disposition string, normalizeScheme bool) []MergedGroup {
 	var all []ReportPageResult
 	groupBrowsers := map[string]map[string]struct{}{}
 	for _, b := range browsers {
-		for _, r := range b.Report.Results {
+		for _, r := range b.analyzed() {
 			var nr ReportPageResult
 			nr = r
 			nr.Violations = nil
@@ -1121,34 +8133,138 @@ func groupViolationsMultiByDisposition(browsers []BrowserReport, disposition str
 					continue
 				}
 				nr.Violations = append(nr.Violations, v)
-				key := fmt.Sprintf("%s -> %s", v.EffectiveDirective, v.BlockedOrigin)
+				key := groupKey(v, normalizeScheme)
 				if _, ok := groupBrowsers[key]; !ok {
 					groupBrowsers[key] = map[string]struct{}{}
 				}
 				groupBrowsers[key][b.Name] = struct{}{}
 			}
-			if len(nr.Violations) > 0 {
-				all = append(all, nr)
+			if len(nr.Violations) > 0 {
+				all = append(all, nr)
+			}
+		}
+	}
+	grouped := groupViolations(all, normalizeScheme)
+	out := make([]MergedGroup, 0, len(grouped))
+	for _, g := range grouped {
+		names := make([]string, 0, len(groupBrowsers[g.Key]))
+		for name := range groupBrowsers[g.Key] {
+			names = append(names, name)
+		}
+		for i := 0; i < len(names); i++ {
+			for j := i + 1; j < len(names); j++ {
+				if names[j] < names[i] {
+					names[i], names[j] = names[j], names[i]
+				}
+			}
+		}
+		out = append(out, MergedGroup{Group: g, Browsers: names})
+	}
+	return out
+}
+
+// consensusGroups keeps only merged groups reported by every browser in the
+// run, which are the least likely to be engine quirks.
+func consensusGroups(groups []MergedGroup, browsers []BrowserReport) []MergedGroup {
+	var out []MergedGroup
+	for _, g := range groups {
+		if len(g.Browsers) != len(browsers) {
+			continue
+		}
+		seen := make(map[string]bool, len(g.Browsers))
+		for _, name := range g.Browsers {
+			seen[name] = true
+		}
+		all := true
+		for _, b := range browsers {
+			if !seen[b.Name] {
+				all = false
+				break
+			}
+		}
+		if all {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+// reportSettled reports whether every page in rep reached waitUntil. Reports
+// without settle information are assumed settled.
+func reportSettled(rep Report) bool {
+	if rep.Settled != nil {
+		return *rep.Settled
+	}
+	for _, r := range rep.Results {
+		if r.TimedOut() {
+			return false
+		}
+	}
+	return true
+}
+
+// buildPivot returns a pages × directives matrix of violation counts summed
+// across browsers. The first column is the page URL; directive columns are
+// sorted by name and pages keep the order they were first reported in.
+func buildPivot(multi MultiReport) ([]string, [][]string) {
+	counts := map[string]map[string]int{}
+	var pages []string
+	directiveSet := map[string]struct{}{}
+	for _, name := range orderedBrowserNames(multi) {
+		for _, r := range multi.Browsers[name].Results {
+			if _, ok := counts[r.URL]; !ok {
+				counts[r.URL] = map[string]int{}
+				pages = append(pages, r.URL)
+			}
+			for _, v := range r.Violations {
+				d := formatDirective(v.EffectiveDirective)
+				directiveSet[d] = struct{}{}
+				counts[r.URL][d]++
 			}
 		}
 	}
-	grouped := groupViolations(all)
-	out := make([]MergedGroup, 0, len(grouped))
-	for _, g := range grouped {
-		names := make([]string, 0, len(groupBrowsers[g.Key]))
-		for name := range groupBrowsers[g.Key] {
+	directives := make([]string, 0, len(directiveSet))
+	for d := range directiveSet {
+		directives = append(directives, d)
+	}
+	sort.Strings(directives)
+
+	headers := append([]string{"url"}, directives...)
+	rows := make([][]string, 0, len(pages))
+	for _, page := range pages {
+		row := []string{page}
+		for _, d := range directives {
+			row = append(row, strconv.Itoa(counts[page][d]))
+		}
+		rows = append(rows, row)
+	}
+	return headers, rows
+}
+
+// orderedBrowserNames lists the browsers in multi, known engines first in
+// their usual order, then any others by name.
+func orderedBrowserNames(multi MultiReport) []string {
+	var names []string
+	for _, name := range browsers {
+		if _, ok := multi.Browsers[name]; ok {
 			names = append(names, name)
 		}
-		for i := 0; i < len(names); i++ {
-			for j := i + 1; j < len(names); j++ {
-				if names[j] < names[i] {
-					names[i], names[j] = names[j], names[i]
-				}
+	}
+	var extra []string
+	for name := range multi.Browsers {
+		known := false
+		for _, b := range browsers {
+			if b == name {
+				known = true
+				break
 			}
 		}
-		out = append(out, MergedGroup{Group: g, Browsers: names})
+		if !known {
+			extra = append(extra, name)
+		}
 	}
-	return out
+	sort.Strings(extra)
+	return append(names, extra...)
 }
 
 func isDisposition(actual, want string) bool {
@@ -1159,7 +8275,51 @@ func isDisposition(actual, want string) bool {
 	return a == "" || a == "enforce"
 }
 
+// configDefaults replaces the built-in defaults once CSP_DEFAULTS_FILE has
+// been loaded.
+var configDefaults *CSPConfig
+
+// defaultConfig returns the settings new profiles start from and that
+// parseConfig fills missing fields with.
 func defaultConfig() CSPConfig {
+	if configDefaults != nil {
+		return configDefaults.clone()
+	}
+	return builtinDefaultConfig()
+}
+
+// clone returns cfg with its slices copied, so editing the copy's lists, or
+// decoding JSON into them, leaves cfg alone.
+func (cfg CSPConfig) clone() CSPConfig {
+	cfg.PreActions = append([]PageAction(nil), cfg.PreActions...)
+	cfg.ExtraArgs = append([]string(nil), cfg.ExtraArgs...)
+	cfg.ExcludePatterns = append([]string(nil), cfg.ExcludePatterns...)
+	cfg.Devices = append([]string(nil), cfg.Devices...)
+	return cfg
+}
+
+// loadConfigDefaults overlays the JSON config in path on the built-in
+// defaults. A missing or invalid file is logged and the built-in defaults
+// stay in effect.
+func loadConfigDefaults(path string) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("CSP_DEFAULTS_FILE: %v; using built-in defaults", err)
+		return
+	}
+	cfg, err := parseConfig(string(raw))
+	if err == nil {
+		err = validateConfig(cfg)
+	}
+	if err != nil {
+		log.Printf("CSP_DEFAULTS_FILE %s is invalid: %v; using built-in defaults", path, err)
+		return
+	}
+	configDefaults = &cfg
+	log.Printf("config defaults loaded from %s", path)
+}
+
+func builtinDefaultConfig() CSPConfig {
 	return CSPConfig{
 		WaitUntil:      "networkidle",
 		NavTimeoutMs:   45000,
@@ -1178,7 +8338,22 @@ func defaultConfigJSON() string {
 	return string(b)
 }
 
+// defaultMaxConcurrency is Server.maxConcurrency when CSP_MAX_CONCURRENCY
+// is unset.
+const defaultMaxConcurrency = 8
+
+// parseConfig decodes a stored config with decodeConfig and validates it.
 func parseConfig(raw string) (CSPConfig, error) {
+	cfg, err := decodeConfig(raw)
+	if err != nil {
+		return cfg, err
+	}
+	return cfg, validateConfig(cfg)
+}
+
+// decodeConfig overlays the JSON config raw on the defaults and fills in
+// fields left empty, without validating the result.
+func decodeConfig(raw string) (CSPConfig, error) {
 	cfg := defaultConfig()
 	if strings.TrimSpace(raw) == "" {
 		return cfg, nil
@@ -1213,6 +8388,110 @@ func parseConfig(raw string) (CSPConfig, error) {
 	return cfg, nil
 }
 
+// Bounds for CSPConfig.TimeoutMultiplier.
+const (
+	minTimeoutMultiplier float64 = 0.5
+	maxTimeoutMultiplier float64 = 10
+)
+
+// validateConfig rejects combinations parseConfig cannot fix up on its own.
+func validateConfig(cfg CSPConfig) error {
+	if (cfg.BasicAuthUser == "") != (cfg.BasicAuthPass == "") {
+		return errors.New("basic auth requires both a user and a password")
+	}
+	if err := validatePreActions(cfg.PreActions); err != nil {
+		return err
+	}
+	if cfg.MaxViolationsPerPage < 0 {
+		return errors.New("max violations per page cannot be negative")
+	}
+	if cfg.NotifyWebhook != "" {
+		u, err := url.Parse(cfg.NotifyWebhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("notify webhook must be a full http or https URL")
+		}
+	}
+	if cfg.NotifyThreshold < 0 {
+		return errors.New("notify threshold cannot be negative")
+	}
+	if m := cfg.TimeoutMultiplier; m != 0 && (m < minTimeoutMultiplier || m > maxTimeoutMultiplier) {
+		return fmt.Errorf("timeout multiplier must be between %g and %g", minTimeoutMultiplier, maxTimeoutMultiplier)
+	}
+	seen := map[string]bool{}
+	for _, d := range cfg.Devices {
+		if _, ok := deviceProfiles[d]; !ok {
+			return fmt.Errorf("unknown device %q", d)
+		}
+		if seen[d] {
+			return fmt.Errorf("device %q listed twice", d)
+		}
+		seen[d] = true
+	}
+	return validateExtraArgs(cfg.ExtraArgs)
+}
+
+// validateExtraArgs rejects arguments that look like shell syntax. The script
+// is never run through a shell, but such arguments are almost certainly a
+// mistake or an attempt to smuggle one in.
+func validateExtraArgs(args []string) error {
+	if len(args) > maxExtraArgs {
+		return fmt.Errorf("at most %d extra script arguments are allowed", maxExtraArgs)
+	}
+	for _, a := range args {
+		if a == "" || len(a) > maxExtraArgLen {
+			return fmt.Errorf("extra script argument %q must be 1-%d characters", a, maxExtraArgLen)
+		}
+		if strings.ContainsAny(a[:1], ";|&$`<>(){}!*?~'\"\\") || strings.ContainsAny(a, "\x00\n\r") {
+			return fmt.Errorf("extra script argument %q is not allowed", a)
+		}
+	}
+	return nil
+}
+
+func validatePreActions(actions []PageAction) error {
+	if len(actions) > maxPreActions {
+		return fmt.Errorf("at most %d pre-navigation actions are allowed", maxPreActions)
+	}
+	for i, a := range actions {
+		switch a.Action {
+		case "goto":
+			u, err := url.Parse(a.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("action %d: goto requires a full http/https url", i+1)
+			}
+			if a.Selector != "" || a.Value != "" {
+				return fmt.Errorf("action %d: goto takes only a url", i+1)
+			}
+		case "fill", "click":
+			if i == 0 {
+				return fmt.Errorf("action %d: the first action must be goto", i+1)
+			}
+			if strings.TrimSpace(a.Selector) == "" {
+				return fmt.Errorf("action %d: %s requires a selector", i+1, a.Action)
+			}
+			if a.URL != "" || (a.Action == "click" && a.Value != "") {
+				return fmt.Errorf("action %d: unexpected fields for %s", i+1, a.Action)
+			}
+		default:
+			return fmt.Errorf("action %d: unknown action %q", i+1, a.Action)
+		}
+	}
+	return nil
+}
+
+func envInt(key string, def int) int {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("%s: invalid integer %q, using %d", key, v, def)
+		return def
+	}
+	return n
+}
+
 func envDefault(key, def string) string {
 	v := strings.TrimSpace(os.Getenv(key))
 	if v == "" {
@@ -1221,7 +8500,7 @@ func envDefault(key, def string) string {
 	return v
 }
 
-//go:embed web/templates/*.html web/static/*
+//go:embed web/templates/*.html web/static/* web/openapi.json
 var embeddedFS embed.FS
 
 var templateFS = embeddedFS
@@ -1355,6 +8634,14 @@ func queryEscape(s string) string {
 	return url.QueryEscape(s)
 }
 
+// dataURLs renders a data-urls attribute holding newline-separated URLs.
+// html/template treats any attribute named like "*url*" as a single URL and
+// would percent-encode the newlines, so the attribute is built here with
+// plain HTML escaping instead.
+func dataURLs(urlsText string) template.HTMLAttr {
+	return template.HTMLAttr(`data-urls="` + template.HTMLEscapeString(urlsText) + `"`)
+}
+
 func formatDirective(d string) string {
 	if strings.TrimSpace(d) == "" {
 		return "N/A"
@@ -1362,6 +8649,50 @@ func formatDirective(d string) string {
 	return d
 }
 
+// DirectiveMeta is display information for a CSP directive. Category picks
+// the colour (CSS class dir-<category>) and Icon is a short tag shown with it.
+type DirectiveMeta struct {
+	Category    string
+	Severity    string
+	Icon        string
+	Description string
+}
+
+var knownDirectives = map[string]DirectiveMeta{
+	"default-src":               {"fallback", "medium", "ALL", "Fallback for fetch directives that are not set."},
+	"script-src":                {"script", "high", "JS", "Where scripts may be loaded and run from."},
+	"script-src-elem":           {"script", "high", "JS", "Sources for <script> elements."},
+	"script-src-attr":           {"script", "high", "JS", "Inline event handlers such as onclick."},
+	"style-src":                 {"style", "medium", "CSS", "Where stylesheets and inline styles may come from."},
+	"style-src-elem":            {"style", "medium", "CSS", "Sources for <style> and stylesheet <link> elements."},
+	"style-src-attr":            {"style", "medium", "CSS", "Inline style attributes."},
+	"img-src":                   {"media", "low", "IMG", "Images and favicons."},
+	"media-src":                 {"media", "low", "AV", "Audio and video."},
+	"font-src":                  {"media", "low", "FONT", "Web fonts."},
+	"connect-src":               {"network", "medium", "NET", "fetch, XHR, WebSocket and beacon requests."},
+	"frame-src":                 {"frame", "medium", "FRAME", "Pages that may be embedded in frames."},
+	"child-src":                 {"frame", "medium", "FRAME", "Frames and workers when frame-src or worker-src is unset."},
+	"worker-src":                {"script", "medium", "WRK", "Worker, SharedWorker and service worker scripts."},
+	"manifest-src":              {"media", "low", "MAN", "Web app manifests."},
+	"object-src":                {"plugin", "high", "OBJ", "<object> and <embed> plugins."},
+	"base-uri":                  {"document", "high", "BASE", "URLs allowed in the <base> element."},
+	"form-action":               {"navigation", "medium", "FORM", "Where forms may be submitted."},
+	"frame-ancestors":           {"navigation", "high", "ANC", "Who may embed this page in a frame."},
+	"navigate-to":               {"navigation", "medium", "NAV", "Where the page may navigate."},
+	"require-trusted-types-for": {"script", "high", "TT", "Requires Trusted Types for DOM XSS sinks."},
+	"trusted-types":             {"script", "high", "TT", "Allowed Trusted Types policy names."},
+}
+
+// directiveMeta returns display information for directive. Unknown
+// directives get the "other" category rather than an empty value.
+func directiveMeta(directive string) DirectiveMeta {
+	d := strings.ToLower(strings.TrimSpace(directive))
+	if meta, ok := knownDirectives[d]; ok {
+		return meta
+	}
+	return DirectiveMeta{Category: "other", Severity: "low", Icon: "CSP", Description: "No description for this directive."}
+}
+
 func groupHint(g GroupedViolation) string {
 	if strings.HasPrefix(strings.ToLower(g.EffectiveDirective), "style-src-attr") {
 		return "Style attributes are blocked; use CSS classes or add style-src-attr policy."
@@ -1383,6 +8714,322 @@ func extractDirective(policy, directive string) string {
 	return ""
 }
 
+// suggestPolicy lists, per effective directive, the sources that would allow
+// the given violations. Sources are sorted and deduplicated.
+func suggestPolicy(violations []Violation) map[string][]string {
+	sets := map[string]map[string]struct{}{}
+	for _, v := range violations {
+		directive := strings.TrimSpace(v.EffectiveDirective)
+		source := violationSource(v)
+		if directive == "" || source == "" {
+			continue
+		}
+		if sets[directive] == nil {
+			sets[directive] = map[string]struct{}{}
+		}
+		sets[directive][source] = struct{}{}
+	}
+	out := make(map[string][]string, len(sets))
+	for directive, set := range sets {
+		sources := make([]string, 0, len(set))
+		for src := range set {
+			sources = append(sources, src)
+		}
+		sort.Strings(sources)
+		out[directive] = sources
+	}
+	return out
+}
+
+// violationSource maps a blocked URI to the CSP source expression allowing it.
+func violationSource(v Violation) string {
+	switch strings.TrimSpace(v.BlockedURI) {
+	case "inline":
+		return "'unsafe-inline'"
+	case "eval":
+		return "'unsafe-eval'"
+	case "data", "blob":
+		return strings.TrimSpace(v.BlockedURI) + ":"
+	}
+	return strings.TrimSpace(v.BlockedOrigin)
+}
+
+// weakenableDirectives are the fetch directives policyWeaknesses inspects.
+var weakenableDirectives = []string{
+	"default-src", "script-src", "script-src-elem", "script-src-attr",
+	"style-src", "style-src-elem", "style-src-attr", "object-src",
+	"img-src", "connect-src", "font-src", "frame-src", "media-src", "worker-src",
+}
+
+// policyWeaknesses lists sources in policy that weaken it regardless of any
+// violation: 'unsafe-inline', 'unsafe-eval' and wildcard * sources.
+// 'unsafe-inline' next to a nonce or hash is ignored by CSP Level 3
+// browsers and is not reported.
+func policyWeaknesses(policy string) []string {
+	var out []string
+	for _, directive := range weakenableDirectives {
+		fields := strings.Fields(extractDirective(policy, directive))
+		if len(fields) == 0 {
+			continue
+		}
+		sources := fields[1:]
+		hasNonceOrHash := false
+		for _, src := range sources {
+			l := strings.ToLower(src)
+			if strings.HasPrefix(l, "'nonce-") || strings.HasPrefix(l, "'sha256-") || strings.HasPrefix(l, "'sha384-") || strings.HasPrefix(l, "'sha512-") {
+				hasNonceOrHash = true
+			}
+		}
+		for _, src := range sources {
+			switch l := strings.ToLower(src); {
+			case l == "'unsafe-inline'" && !hasNonceOrHash:
+				out = append(out, directive+" allows 'unsafe-inline'")
+			case l == "'unsafe-eval'":
+				out = append(out, directive+" allows 'unsafe-eval'")
+			case l == "*":
+				out = append(out, directive+" allows any origin (*)")
+			}
+		}
+	}
+	return out
+}
+
+// pageWeaknesses runs policyWeaknesses over every policy seen for a page:
+// those reported with its violations and any captured CSP response header.
+func pageWeaknesses(result ReportPageResult) []string {
+	var policies []string
+	for _, v := range result.Violations {
+		if p := strings.TrimSpace(v.OriginalPolicy); p != "" && !containsString(policies, p) {
+			policies = append(policies, p)
+		}
+	}
+	for name, value := range result.Headers {
+		if strings.EqualFold(name, "content-security-policy") || strings.EqualFold(name, "content-security-policy-report-only") {
+			if p := strings.TrimSpace(value); p != "" && !containsString(policies, p) {
+				policies = append(policies, p)
+			}
+		}
+	}
+	var out []string
+	for _, p := range policies {
+		for _, w := range policyWeaknesses(p) {
+			if !containsString(out, w) {
+				out = append(out, w)
+			}
+		}
+	}
+	return out
+}
+
+// mergedPolicyForPage returns the page's original policy with the sources
+// suggested for its violations added. Directives missing from the policy are
+// seeded from default-src so adding them does not narrow what it allowed.
+// Pages without a policy get one built from 'self'.
+func mergedPolicyForPage(result ReportPageResult) string {
+	var policy string
+	for _, v := range result.Violations {
+		if strings.TrimSpace(v.OriginalPolicy) != "" {
+			policy = v.OriginalPolicy
+			break
+		}
+	}
+	if strings.TrimSpace(policy) == "" {
+		policy = "default-src 'self'"
+	}
+
+	var names []string
+	tokens := map[string][]string{}
+	for _, part := range strings.Split(policy, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		name := strings.ToLower(fields[0])
+		if _, ok := tokens[name]; ok {
+			continue
+		}
+		names = append(names, name)
+		tokens[name] = fields[1:]
+	}
+
+	suggested := suggestPolicy(result.Violations)
+	directives := make([]string, 0, len(suggested))
+	for d := range suggested {
+		directives = append(directives, d)
+	}
+	sort.Strings(directives)
+	for _, d := range directives {
+		existing, ok := tokens[d]
+		if !ok {
+			existing = append([]string(nil), tokens["default-src"]...)
+			names = append(names, d)
+		}
+		for _, src := range suggested[d] {
+			if !containsString(existing, src) {
+				existing = append(existing, src)
+			}
+		}
+		tokens[d] = existing
+	}
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, strings.TrimSpace(name+" "+strings.Join(tokens[name], " ")))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// suggestedRunPolicy merges the sources suggested for every violation of a
+// run, across pages and browsers, into one policy; see mergedPolicyForPage.
+// Report-only violations are left out unless includeReportOnly is set. The
+// first original policy found is the base, so runs over pages with different
+// policies get the first page's.
+func suggestedRunPolicy(multi MultiReport, includeReportOnly bool) string {
+	names := append([]string(nil), browsers...)
+	var extra []string
+	for name := range multi.Browsers {
+		if !containsString(browsers, name) {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	var all []Violation
+	for _, name := range append(names, extra...) {
+		for _, r := range multi.Browsers[name].Results {
+			for _, v := range r.Violations {
+				if isDisposition(v.Disposition, "enforce") || (includeReportOnly && isDisposition(v.Disposition, "report-only")) {
+					all = append(all, v)
+				}
+			}
+		}
+	}
+	return mergedPolicyForPage(ReportPageResult{Violations: all})
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// outlierFactor is how many times the median load time a page must take
+// before durationOutliers flags it.
+const outlierFactor = 2
+
+// durationOutliers returns the URLs of pages that took more than
+// outlierFactor times the median duration of the results. Fewer than three
+// timed pages give no meaningful median, so nothing is flagged.
+func durationOutliers(results []ReportPageResult) []string {
+	var durations []int64
+	for _, res := range results {
+		if res.DurationMs > 0 {
+			durations = append(durations, res.DurationMs)
+		}
+	}
+	if len(durations) < 3 {
+		return nil
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	mid := len(durations) / 2
+	median := durations[mid]
+	if len(durations)%2 == 0 {
+		median = (durations[mid-1] + durations[mid]) / 2
+	}
+	var urls []string
+	for _, res := range results {
+		if res.DurationMs > median*outlierFactor {
+			urls = append(urls, res.URL)
+		}
+	}
+	return urls
+}
+
+// applyConfigForm overwrites cfg with the non-empty fields of a submitted
+// profile form.
+func applyConfigForm(cfg *CSPConfig, r *http.Request) error {
+	if v := strings.TrimSpace(r.FormValue("wait_until")); v != "" {
+		cfg.WaitUntil = v
+	}
+	if v := parseIntForm(r.FormValue("nav_timeout_ms")); v > 0 {
+		cfg.NavTimeoutMs = v
+	}
+	if v := parseIntForm(r.FormValue("settle_wait_ms")); v >= 0 {
+		cfg.SettleWaitMs = v
+	}
+	if raw := strings.TrimSpace(r.FormValue("timeout_multiplier")); raw != "" {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid timeout multiplier %q", raw)
+		}
+		cfg.TimeoutMultiplier = v
+	}
+	if v := parseIntForm(r.FormValue("concurrency")); v > 0 {
+		cfg.Concurrency = v
+	}
+	if v := parseIntForm(r.FormValue("between_url_ms")); v >= 0 {
+		cfg.BetweenURLMs = v
+	}
+	if v := strings.TrimSpace(r.FormValue("user_agent")); v != "" {
+		cfg.UserAgent = v
+	}
+	if v := strings.TrimSpace(r.FormValue("accept_language")); v != "" {
+		cfg.AcceptLanguage = v
+	}
+	if v := strings.TrimSpace(r.FormValue("basic_auth_user")); v != "" {
+		cfg.BasicAuthUser = v
+	}
+	if v := r.FormValue("basic_auth_pass"); v != "" {
+		cfg.BasicAuthPass = v
+	}
+	cfg.FailOnReportOnly = r.FormValue("fail_on_report_only") == "1"
+	if v := parseIntForm(r.FormValue("max_violations_per_page")); v >= 0 {
+		cfg.MaxViolationsPerPage = v
+	}
+	cfg.DisableJS = r.FormValue("disable_js") == "1"
+	cfg.IgnoreTLSErrors = r.FormValue("ignore_tls") == "1"
+	cfg.CaptureHeaders = r.FormValue("capture_headers") == "1"
+	cfg.DeFlake = r.FormValue("de_flake") == "1"
+	if r.FormValue("clear_basic_auth") == "1" {
+		cfg.BasicAuthUser = ""
+		cfg.BasicAuthPass = ""
+	}
+	// Like the password, the stored webhook is never echoed back, so a
+	// blank field keeps it.
+	if v := strings.TrimSpace(r.FormValue("notify_webhook")); v != "" {
+		cfg.NotifyWebhook = v
+	}
+	if r.FormValue("clear_notify_webhook") == "1" {
+		cfg.NotifyWebhook = ""
+	}
+	if v := parseIntForm(r.FormValue("notify_threshold")); v >= 0 {
+		cfg.NotifyThreshold = v
+	}
+	cfg.ExtraArgs = strings.Fields(r.FormValue("extra_args"))
+	cfg.DefaultURLs = strings.TrimSpace(r.FormValue("default_urls"))
+	cfg.Devices = nil
+	for _, d := range r.Form["devices"] {
+		if d = strings.TrimSpace(d); d != "" {
+			cfg.Devices = append(cfg.Devices, d)
+		}
+	}
+	cfg.ExcludePatterns = nil
+	for _, line := range strings.Split(r.FormValue("exclude_patterns"), "\n") {
+		if p := strings.TrimSpace(line); p != "" {
+			cfg.ExcludePatterns = append(cfg.ExcludePatterns, p)
+		}
+	}
+	cfg.PreActions = nil
+	if v := strings.TrimSpace(r.FormValue("pre_actions")); v != "" {
+		if err := json.Unmarshal([]byte(v), &cfg.PreActions); err != nil {
+			return fmt.Errorf("pre-navigation actions: %v", err)
+		}
+	}
+	return nil
+}
+
 func parseIntForm(raw string) int {
 	raw = strings.TrimSpace(raw)
 	if raw == "" {
@@ -1407,9 +9054,119 @@ func summarizeMulti(m MultiReport) RunSummary {
 		}
 		summary.Violations += rep.Totals.Violations
 	}
+	summary.PagesWithoutCSP = len(pagesWithoutCSP(m))
+	summary.BlockedOrigins = distinctBlockedOrigins(m)
+	score := computeScore(m)
+	summary.Score = &score
 	return summary
 }
 
+// severityWeights weight violations by their directive's Severity for
+// computeScore.
+var severityWeights = map[string]float64{"high": 5, "medium": 2, "low": 1}
+
+// scorePenaltyPerPoint is how many score points one weight point per page
+// costs: a single high-severity violation on every page scores 75.
+const scorePenaltyPerPoint = 5
+
+// computeScore returns a 0-100 CSP health score for a run: 100 minus the
+// severity-weighted violations per checked page, scaled by
+// scorePenaltyPerPoint. Pages are counted per browser, like violations, so
+// the score does not depend on how many browsers ran.
+func computeScore(multi MultiReport) int {
+	pages := 0
+	weight := 0.0
+	for _, rep := range multi.Browsers {
+		pages += len(rep.Results)
+		for _, r := range rep.Results {
+			for _, v := range r.Violations {
+				weight += severityWeights[directiveMeta(v.EffectiveDirective).Severity]
+			}
+		}
+	}
+	if pages == 0 {
+		return 100
+	}
+	score := 100 - int(math.Round(weight/float64(pages)*scorePenaltyPerPoint))
+	if score < 0 {
+		return 0
+	}
+	return score
+}
+
+// HealthScore is a run's score with the CSS class it is shown in.
+type HealthScore struct {
+	Value int
+	Class string
+}
+
+// jsonScore reads the health score from a stored summary, nil when the run
+// has none.
+func jsonScore(summary string) *HealthScore {
+	var multi RunSummary
+	if err := json.Unmarshal([]byte(summary), &multi); err != nil || multi.Score == nil {
+		return nil
+	}
+	class := "score-poor"
+	switch {
+	case *multi.Score >= 80:
+		class = "score-good"
+	case *multi.Score >= 50:
+		class = "score-fair"
+	}
+	return &HealthScore{Value: *multi.Score, Class: class}
+}
+
+// distinctBlockedOrigins counts the different blocked origins reported by
+// any browser. Inline, eval and other violations without an origin are not
+// counted.
+func distinctBlockedOrigins(multi MultiReport) int {
+	seen := make(map[string]bool)
+	for _, rep := range multi.Browsers {
+		for _, res := range rep.Results {
+			for _, v := range res.Violations {
+				if origin := strings.TrimSpace(v.BlockedOrigin); origin != "" {
+					seen[origin] = true
+				}
+			}
+		}
+	}
+	return len(seen)
+}
+
+// pagesWithoutCSP lists, once each, the pages any browser loaded without a
+// Content-Security-Policy. Pages from reports that do not record HasCSP are
+// never listed.
+func pagesWithoutCSP(multi MultiReport) []string {
+	seen := make(map[string]bool)
+	var urls []string
+	for _, b := range browsers {
+		for _, res := range multi.Browsers[b].Results {
+			if res.HasCSP != nil && !*res.HasCSP && !seen[res.URL] {
+				seen[res.URL] = true
+				urls = append(urls, res.URL)
+			}
+		}
+	}
+	return urls
+}
+
+func jsonPagesWithoutCSP(summary string) int {
+	var multi RunSummary
+	if err := json.Unmarshal([]byte(summary), &multi); err != nil {
+		return 0
+	}
+	return multi.PagesWithoutCSP
+}
+
+func jsonBlockedOrigins(summary string) int {
+	var multi RunSummary
+	if err := json.Unmarshal([]byte(summary), &multi); err != nil {
+		return 0
+	}
+	return multi.BlockedOrigins
+}
+
 func jsonPretty(v any) string {
 	b, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
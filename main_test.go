@@ -1,6 +1,76 @@
 package main
 
-import "testing"
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	db, err := openDB(dbPath)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := initDB(db); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+	if err := ensureDefaultProfile(db); err != nil {
+		t.Fatalf("default profile: %v", err)
+	}
+	tmpl, err := parseTemplates()
+	if err != nil {
+		t.Fatalf("templates: %v", err)
+	}
+	return &Server{db: db, dbPath: dbPath, tmpl: tmpl}
+}
+
+// seedRun stores multi as a run under the default profile and returns its ID.
+func seedRun(t *testing.T, s *Server, urlsText string, multi MultiReport) int64 {
+	t.Helper()
+	resultsJSON, err := json.Marshal(multi)
+	if err != nil {
+		t.Fatalf("marshal results: %v", err)
+	}
+	summaryJSON, err := json.Marshal(summarizeMulti(multi))
+	if err != nil {
+		t.Fatalf("marshal summary: %v", err)
+	}
+	p, err := s.getProfileByName(context.Background(), defaultProfileName)
+	if err != nil {
+		t.Fatalf("default profile: %v", err)
+	}
+	id, err := s.createRun(context.Background(), sql.NullInt64{Int64: p.ID, Valid: true}, urlsText, string(summaryJSON), string(resultsJSON), 0, 10)
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	return id
+}
+
+func get(t *testing.T, h http.Handler, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
 
 func TestNormalizeURL(t *testing.T) {
 	cases := []struct {
@@ -71,3 +141,3659 @@ func TestExtractDirective(t *testing.T) {
 		t.Fatalf("script-src directive=%q, want empty", missing)
 	}
 }
+
+func TestAdminVacuumReportsSizes(t *testing.T) {
+	s := newTestServer(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/vacuum", nil)
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	var got map[string]int64
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got["beforeBytes"] <= 0 || got["afterBytes"] <= 0 {
+		t.Fatalf("sizes=%v, want positive before/after", got)
+	}
+	if _, ok := got["reclaimedBytes"]; !ok {
+		t.Fatalf("missing reclaimedBytes in %v", got)
+	}
+}
+
+func TestAdminVacuumRequiresAuthWhenConfigured(t *testing.T) {
+	s := newTestServer(t)
+	s.authUser, s.authPass = "admin", "secret"
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/vacuum", nil)
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status=%d, want 401", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/vacuum", nil)
+	req.SetBasicAuth("admin", "secret")
+	rec = httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status=%d, want 200", rec.Code)
+	}
+}
+
+func TestAPITokenGuardsOnlyAPI(t *testing.T) {
+	s := newTestServer(t)
+	s.authUser, s.authPass = "admin", "secret"
+	s.apiToken = "ci-token"
+	call := func(path, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		s.routes().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := call("/api/status", "")
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("no token: %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if rec := call("/api/status", "Bearer wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong token: %d", rec.Code)
+	}
+	if rec := call("/api/status", "Bearer ci-token"); rec.Code != http.StatusOK {
+		t.Fatalf("valid token: %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := call("/runs", "Bearer ci-token"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("token opened the UI: %d", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/runs", nil)
+	req.SetBasicAuth("admin", "secret")
+	rec = httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("basic auth UI: %d", rec.Code)
+	}
+}
+
+func TestReadOnlyModeRejectsMutations(t *testing.T) {
+	s := newTestServer(t)
+	s.readOnly = true
+	s.checker = CheckerFunc(func(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
+		t.Fatal("read-only mode started a check")
+		return MultiReport{}, 0, nil
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/runs", strings.NewReader("urls=https://example.org/"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("POST /runs status=%d, want 403", rec.Code)
+	}
+
+	rec = get(t, s.routes(), "/runs")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /runs status=%d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "This instance is read-only") {
+		t.Errorf("missing read-only notice")
+	}
+}
+
+func TestOversizedURLsFieldRejected(t *testing.T) {
+	s := newTestServer(t)
+	s.maxURLsBytes = 100
+	s.checker = CheckerFunc(func(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
+		t.Fatal("oversized urls reached the checker")
+		return MultiReport{}, 0, nil
+	})
+	post := func(urls string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/runs", strings.NewReader("urls="+urls))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		s.routes().ServeHTTP(rec, req)
+		return rec
+	}
+
+	// One long line, over the field limit but within the body slack.
+	line := "https://example.org/" + strings.Repeat("a", 200)
+	if rec := post(line); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "urls exceeds 100 bytes") {
+		t.Fatalf("long line: %d %s", rec.Code, rec.Body)
+	}
+	// A body too large to parse at all.
+	if rec := post(strings.Repeat("a", 100+urlsFormSlack)); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "urls exceeds 100 bytes") {
+		t.Fatalf("huge body: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestCreatingProfileWritesAuditRow(t *testing.T) {
+	s := newTestServer(t)
+	s.audit = true
+	s.authUser, s.authPass = "admin", "secret"
+
+	req := httptest.NewRequest(http.MethodPost, "/profiles", strings.NewReader("name=Audited"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("admin", "secret")
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("create profile: %d %s", rec.Code, rec.Body)
+	}
+	p, err := s.getProfileByName(context.Background(), "Audited")
+	if err != nil {
+		t.Fatalf("profile: %v", err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/audit", nil)
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth("admin", "secret")
+	rec = httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	var entries []AuditEntry
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("decode %s: %v", rec.Body, err)
+	}
+	if len(entries) != 1 || entries[0].Action != "profile.created" || entries[0].TargetID != fmt.Sprint(p.ID) || entries[0].User != "admin" || entries[0].At == "" {
+		t.Fatalf("entries = %+v", entries)
+	}
+	if _, err := s.db.Exec(`DELETE FROM audit_log`); err == nil {
+		t.Errorf("audit rows could be deleted")
+	}
+}
+
+func TestRunDetailWarnsWhenBrowserUnsettled(t *testing.T) {
+	s := newTestServer(t)
+	settled, unsettled := true, false
+	id := seedRun(t, s, "https://example.org/", MultiReport{
+		Browsers: map[string]Report{
+			"chromium": {Settled: &settled, Results: []ReportPageResult{{URL: "https://example.org/", OK: true, Settled: &settled}}},
+			"firefox":  {Settled: &unsettled, Results: []ReportPageResult{{URL: "https://example.org/", Settled: &unsettled, Error: "Timeout 45000ms exceeded."}}},
+		},
+	})
+
+	rec := get(t, s.routes(), fmt.Sprintf("/runs/%d", id))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status=%d", rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "Unsettled — results may be incomplete for firefox.") {
+		t.Fatalf("missing unsettled warning for firefox")
+	}
+	if strings.Contains(body, "incomplete for chromium") {
+		t.Fatalf("chromium should not be flagged as unsettled")
+	}
+}
+
+func TestParseConfigBasicAuthBothOrNeither(t *testing.T) {
+	cases := []struct {
+		raw     string
+		wantErr bool
+	}{
+		{`{}`, false},
+		{`{"basicAuthUser":"staff","basicAuthPass":"hunter2"}`, false},
+		{`{"basicAuthUser":"staff"}`, true},
+		{`{"basicAuthPass":"hunter2"}`, true},
+	}
+	for _, c := range cases {
+		_, err := parseConfig(c.raw)
+		if (err != nil) != c.wantErr {
+			t.Fatalf("parseConfig(%s) err=%v, wantErr %v", c.raw, err, c.wantErr)
+		}
+	}
+}
+
+func TestResolveConfigCapsConcurrency(t *testing.T) {
+	s := newTestServer(t)
+	s.maxConcurrency = 8
+	if err := s.createProfile(context.Background(), "Wide", `{"concurrency":1000}`); err != nil {
+		t.Fatal(err)
+	}
+	p, err := s.getProfileByName(context.Background(), "Wide")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg, _ := parseConfig(p.ConfigJSON); cfg.Concurrency != 1000 {
+		t.Fatalf("parseConfig changed concurrency to %d", cfg.Concurrency)
+	}
+	_, cfg, err := s.resolveConfig(context.Background(), sql.NullInt64{Int64: p.ID, Valid: true})
+	if err != nil || cfg.Concurrency != 8 {
+		t.Fatalf("concurrency = %d, want 8", cfg.Concurrency)
+	}
+}
+
+func TestParseConfigDisableJS(t *testing.T) {
+	cfg, err := parseConfig(`{"waitUntil":"load"}`)
+	if err != nil || cfg.DisableJS {
+		t.Fatalf("default DisableJS=%v err=%v", cfg.DisableJS, err)
+	}
+	cfg.DisableJS = true
+	raw, _ := json.Marshal(cfg)
+	got, err := parseConfig(string(raw))
+	if err != nil || !got.DisableJS {
+		t.Fatalf("round trip DisableJS=%v err=%v (%s)", got.DisableJS, err, raw)
+	}
+}
+
+func TestParseConfigIgnoreTLSErrors(t *testing.T) {
+	cfg, err := parseConfig(`{"waitUntil":"load"}`)
+	if err != nil || cfg.IgnoreTLSErrors {
+		t.Fatalf("default IgnoreTLSErrors=%v err=%v", cfg.IgnoreTLSErrors, err)
+	}
+	cfg.IgnoreTLSErrors = true
+	raw, _ := json.Marshal(cfg)
+	got, err := parseConfig(string(raw))
+	if err != nil || !got.IgnoreTLSErrors {
+		t.Fatalf("round trip IgnoreTLSErrors=%v err=%v (%s)", got.IgnoreTLSErrors, err, raw)
+	}
+}
+
+func TestDefaultConfigDoesNotShareSlices(t *testing.T) {
+	t.Cleanup(func() { configDefaults = nil })
+	defaults := builtinDefaultConfig()
+	defaults.ExtraArgs = make([]string, 1, 4)
+	defaults.ExtraArgs[0] = "--lang=fr"
+	defaults.Devices = []string{"iphone-13"}
+	configDefaults = &defaults
+
+	cfg := defaultConfig()
+	cfg.ExtraArgs[0] = "--mute-audio"
+	cfg.Devices = append(cfg.Devices[:0], "pixel-7")
+	if _, err := decodeConfig(`{"extraArgs":["--disable-gpu"]}`); err != nil {
+		t.Fatal(err)
+	}
+	if configDefaults.ExtraArgs[0] != "--lang=fr" || configDefaults.Devices[0] != "iphone-13" {
+		t.Fatalf("defaults changed through a copy: %+v", configDefaults)
+	}
+}
+
+func TestConfigDefaultsFile(t *testing.T) {
+	t.Cleanup(func() { configDefaults = nil })
+	dir := t.TempDir()
+	path := filepath.Join(dir, "defaults.json")
+	if err := os.WriteFile(path, []byte(`{"navTimeoutMs": 90000, "acceptLanguage": "fr-CA"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loadConfigDefaults(path)
+	cfg, err := parseConfig(`{"waitUntil":"load"}`)
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	if cfg.NavTimeoutMs != 90000 || cfg.AcceptLanguage != "fr-CA" || cfg.SettleWaitMs != 3000 {
+		t.Fatalf("defaults not applied: %+v", cfg)
+	}
+
+	configDefaults = nil
+	bad := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(bad, []byte(`{"navTimeoutMs": `), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loadConfigDefaults(bad)
+	loadConfigDefaults(filepath.Join(dir, "missing.json"))
+	if defaultConfig().NavTimeoutMs != 45000 {
+		t.Fatalf("invalid file changed defaults: %+v", defaultConfig())
+	}
+}
+
+func TestParseConfigExtraArgs(t *testing.T) {
+	cfg, err := parseConfig(`{"extraArgs":["--screenshot","--retries=2"]}`)
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	if fmt.Sprint(cfg.ExtraArgs) != "[--screenshot --retries=2]" {
+		t.Fatalf("ExtraArgs = %q", cfg.ExtraArgs)
+	}
+	for _, raw := range []string{
+		`{"extraArgs":["; rm -rf /"]}`,
+		`{"extraArgs":["$(id)"]}`,
+		`{"extraArgs":["--ok","|cat"]}`,
+		`{"extraArgs":["line\nbreak"]}`,
+	} {
+		if _, err := parseConfig(raw); err == nil {
+			t.Errorf("parseConfig(%s) accepted a dangerous argument", raw)
+		}
+	}
+}
+
+func TestParseConfigRejectsMalformedPreActions(t *testing.T) {
+	valid := `{"preActions":[{"action":"goto","url":"https://example.org/login"},{"action":"fill","selector":"#user","value":"staff"},{"action":"click","selector":"button"}]}`
+	if _, err := parseConfig(valid); err != nil {
+		t.Fatalf("valid actions rejected: %v", err)
+	}
+	for _, raw := range []string{
+		`{"preActions":{"action":"goto"}}`,
+		`{"preActions":[{"action":"eval","selector":"document.cookie"}]}`,
+		`{"preActions":[{"action":"click","selector":"button"}]}`,
+		`{"preActions":[{"action":"goto","url":"javascript:alert(1)"}]}`,
+		`{"preActions":[{"action":"goto","url":"https://example.org/"},{"action":"fill","value":"x"}]}`,
+	} {
+		if _, err := parseConfig(raw); err == nil {
+			t.Errorf("parseConfig(%s) accepted malformed actions", raw)
+		}
+	}
+}
+
+func TestProfilesPageRedactsBasicAuthPassword(t *testing.T) {
+	s := newTestServer(t)
+	cfg := defaultConfig()
+	cfg.BasicAuthUser, cfg.BasicAuthPass = "staff", "hunter2"
+	cfgJSON, _ := json.Marshal(cfg)
+	if err := s.createProfile(context.Background(), "Staging", string(cfgJSON)); err != nil {
+		t.Fatalf("create profile: %v", err)
+	}
+
+	body := get(t, s.routes(), "/profiles").Body.String()
+	if !strings.Contains(body, "staff") {
+		t.Fatalf("expected basic auth user on profiles page")
+	}
+	if strings.Contains(body, "hunter2") {
+		t.Fatalf("basic auth password leaked on profiles page")
+	}
+}
+
+func TestBuildPivot(t *testing.T) {
+	multi := MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{
+			{URL: "https://example.org/a", Violations: []Violation{
+				{EffectiveDirective: "img-src"},
+				{EffectiveDirective: "img-src"},
+				{EffectiveDirective: "script-src"},
+			}},
+			{URL: "https://example.org/b"},
+		}},
+		"firefox": {Results: []ReportPageResult{
+			{URL: "https://example.org/b", Violations: []Violation{{EffectiveDirective: "script-src"}}},
+		}},
+	}}
+
+	headers, rows := buildPivot(multi)
+	wantHeaders := []string{"url", "img-src", "script-src"}
+	if strings.Join(headers, ",") != strings.Join(wantHeaders, ",") {
+		t.Fatalf("headers=%v, want %v", headers, wantHeaders)
+	}
+	want := [][]string{
+		{"https://example.org/a", "2", "1"},
+		{"https://example.org/b", "0", "1"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("rows=%v, want %v", rows, want)
+	}
+	for i := range want {
+		if strings.Join(rows[i], ",") != strings.Join(want[i], ",") {
+			t.Fatalf("row[%d]=%v, want %v", i, rows[i], want[i])
+		}
+	}
+}
+
+func TestIndexProfilesPinsDefaultFirst(t *testing.T) {
+	s := newTestServer(t)
+	for _, name := range []string{"Alpha", "Zulu"} {
+		if err := s.createProfile(context.Background(), name, defaultConfigJSON()); err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+	}
+
+	for _, order := range []string{"created", "name"} {
+		s.profileOrder = order
+		profiles, err := s.indexProfiles(context.Background())
+		if err != nil {
+			t.Fatalf("indexProfiles: %v", err)
+		}
+		if len(profiles) != 3 || profiles[0].Name != defaultProfileName {
+			t.Fatalf("order=%s: profiles=%v, want %q first", order, profiles, defaultProfileName)
+		}
+		if order == "name" && (profiles[1].Name != "Alpha" || profiles[2].Name != "Zulu") {
+			t.Fatalf("order=name: got %q, %q", profiles[1].Name, profiles[2].Name)
+		}
+	}
+
+	body := get(t, s.routes(), "/").Body.String()
+	_, form, _ := strings.Cut(body, `<select name="profile_id" id="profile_id">`)
+	form, _, _ = strings.Cut(form, "</select>")
+	blank := strings.Index(form, ">(default)</option>")
+	def := strings.Index(form, ">"+defaultProfileName+"</option>")
+	alpha := strings.Index(form, ">Alpha</option>")
+	if blank < 0 || def < blank || alpha < def {
+		t.Fatalf("run form options out of order:\n%s", form)
+	}
+}
+
+func TestProfileHistoryRecordsEachUpdate(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	p, err := s.getProfileByName(ctx, defaultProfileName)
+	if err != nil {
+		t.Fatalf("default profile: %v", err)
+	}
+	original := p.ConfigJSON
+
+	first := defaultConfig()
+	first.NavTimeoutMs = 60000
+	firstJSON, _ := json.Marshal(first)
+	if err := s.updateProfile(ctx, p.ID, p.Name, string(firstJSON)); err != nil {
+		t.Fatalf("first update: %v", err)
+	}
+	second := first
+	second.Concurrency = 2
+	secondJSON, _ := json.Marshal(second)
+	if err := s.updateProfile(ctx, p.ID, p.Name, string(secondJSON)); err != nil {
+		t.Fatalf("second update: %v", err)
+	}
+
+	entries, err := s.profileHistory(ctx, p.ID)
+	if err != nil {
+		t.Fatalf("profileHistory: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("history rows=%d, want 2", len(entries))
+	}
+	if entries[0].ConfigJSON != original || entries[1].ConfigJSON != string(firstJSON) {
+		t.Fatalf("history out of order: %+v", entries)
+	}
+
+	body := get(t, s.routes(), fmt.Sprintf("/profiles/%d/history", p.ID)).Body.String()
+	if !strings.Contains(body, "navTimeoutMs") || !strings.Contains(body, "concurrency") {
+		t.Fatalf("history page missing changed fields")
+	}
+}
+
+func TestAPIBrowsersUsesProbe(t *testing.T) {
+	s := newTestServer(t)
+	calls := 0
+	s.browserProbe = func() (map[string]bool, error) {
+		calls++
+		return map[string]bool{"chromium": true, "firefox": true, "webkit": false}, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		rec := get(t, s.routes(), "/api/browsers")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status=%d", rec.Code)
+		}
+		var got struct {
+			Browsers []BrowserInfo `json:"browsers"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if len(got.Browsers) != 3 {
+			t.Fatalf("browsers=%v", got.Browsers)
+		}
+		webkit := got.Browsers[2]
+		if webkit.Name != "webkit" || webkit.Available == nil || *webkit.Available {
+			t.Fatalf("webkit=%+v, want unavailable", webkit)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("probe calls=%d, want 1 (cached)", calls)
+	}
+}
+
+func TestComputeExitCodeReportOnly(t *testing.T) {
+	multi := MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{{
+			URL:        "https://example.org/",
+			Violations: []Violation{{EffectiveDirective: "img-src", Disposition: "report"}},
+		}}},
+	}}
+
+	if got := computeExitCode(multi, 1, CSPConfig{FailOnReportOnly: false}); got != 0 {
+		t.Fatalf("FailOnReportOnly=false: exit=%d, want 0", got)
+	}
+	if got := computeExitCode(multi, 1, CSPConfig{FailOnReportOnly: true}); got != 1 {
+		t.Fatalf("FailOnReportOnly=true: exit=%d, want 1", got)
+	}
+	if got := computeExitCode(multi, 2, CSPConfig{}); got != 2 {
+		t.Fatalf("node failure: exit=%d, want 2", got)
+	}
+}
+
+func TestFetchURLList(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/urls.txt":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprint(w, "# staging\nhttps://example.org/\nhttps://example.org/about\n")
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<html></html>")
+		}
+	}))
+	defer ts.Close()
+
+	text, err := fetchURLList(context.Background(), ts.Client(), ts.URL+"/urls.txt")
+	if err != nil {
+		t.Fatalf("fetchURLList: %v", err)
+	}
+	urls := parseURLList(text)
+	if len(urls) != 2 || urls[1] != "https://example.org/about" {
+		t.Fatalf("urls=%v", urls)
+	}
+
+	if _, err := fetchURLList(context.Background(), ts.Client(), ts.URL+"/page.html"); err == nil {
+		t.Fatalf("expected content-type error for text/html")
+	}
+	if _, err := fetchURLList(context.Background(), newGuardedClient(time.Second), ts.URL+"/urls.txt"); err == nil {
+		t.Fatalf("expected guarded client to refuse loopback address")
+	}
+}
+
+func TestRenderIncludesInstanceName(t *testing.T) {
+	s := newTestServer(t)
+	s.instanceName = "staging-eu"
+
+	body := get(t, s.routes(), "/").Body.String()
+	if !strings.Contains(body, "<title>staging-eu · CSP Web Checker</title>") {
+		t.Fatalf("title missing instance name")
+	}
+	if !strings.Contains(body, `<span class="instance-name">staging-eu</span>`) {
+		t.Fatalf("header missing instance name")
+	}
+}
+
+func TestOpenAPISpecListsPaths(t *testing.T) {
+	s := newTestServer(t)
+	rec := get(t, s.routes(), "/api/openapi.json")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status=%d", rec.Code)
+	}
+	var spec struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("spec is not valid JSON: %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Fatalf("openapi=%q", spec.OpenAPI)
+	}
+	for _, path := range []string{"/runs", "/runs/{id}", "/runs/export", "/api/browsers", "/api/runs/{id}/analysis"} {
+		if _, ok := spec.Paths[path]; !ok {
+			t.Fatalf("spec missing path %s", path)
+		}
+	}
+}
+
+func TestTruncateSamples(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	multi := MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{{
+			URL: "https://example.org/",
+			Violations: []Violation{
+				{EffectiveDirective: "script-src-elem", Sample: long},
+				{EffectiveDirective: "style-src-attr", Sample: "color: red"},
+			},
+		}}},
+	}}
+
+	got := truncateSamples(multi, 256).Browsers["chromium"].Results[0].Violations
+	if !got[0].SampleTruncated || got[0].Sample != strings.Repeat("x", 256)+"…" {
+		t.Fatalf("long sample not truncated: len=%d truncated=%v", len(got[0].Sample), got[0].SampleTruncated)
+	}
+	if got[1].SampleTruncated || got[1].Sample != "color: red" {
+		t.Fatalf("short sample changed: %+v", got[1])
+	}
+	if multi.Browsers["chromium"].Results[0].Violations[0].Sample != long {
+		t.Fatalf("truncateSamples modified its input")
+	}
+}
+
+func TestWithRetryRecoversFromBusy(t *testing.T) {
+	old := retryBaseDelay
+	retryBaseDelay = time.Millisecond
+	defer func() { retryBaseDelay = old }()
+
+	ctx := context.Background()
+	calls := 0
+	err := withRetry(ctx, func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("database is locked (5) (SQLITE_BUSY)")
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("err=%v calls=%d, want nil after 3 calls", err, calls)
+	}
+
+	calls = 0
+	permanent := errors.New("no such table: runs")
+	if err := withRetry(ctx, func() error { calls++; return permanent }); !errors.Is(err, permanent) || calls != 1 {
+		t.Fatalf("err=%v calls=%d, want permanent error without retry", err, calls)
+	}
+
+	calls = 0
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	busy := func() error { calls++; return errors.New("database is locked (5) (SQLITE_BUSY)") }
+	if err := withRetry(canceled, busy); !errors.Is(err, context.Canceled) || calls != 1 {
+		t.Fatalf("err=%v calls=%d, want context.Canceled after one call", err, calls)
+	}
+}
+
+func TestResultsCompressionRoundTrip(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	s.compressResults = true
+	results := `{"browsers":{"chromium":{"results":[]}}}`
+
+	id, err := s.createRun(ctx, sql.NullInt64{}, "https://example.org/", `{}`, results, 0, 1)
+	if err != nil {
+		t.Fatalf("create run: %v", err)
+	}
+	var raw []byte
+	if err := s.db.QueryRow(`SELECT results_json FROM runs WHERE id = ?`, id).Scan(&raw); err != nil {
+		t.Fatalf("raw select: %v", err)
+	}
+	if !bytes.HasPrefix(raw, gzipMagic) {
+		t.Fatalf("stored results not gzipped: %q", raw)
+	}
+	run, err := s.getRun(ctx, id)
+	if err != nil || run.ResultsJSON != results {
+		t.Fatalf("getRun results=%q err=%v", run.ResultsJSON, err)
+	}
+
+	res, err := s.db.Exec(`INSERT INTO runs (profile_id, created_at, urls_text, summary_json, results_json, exit_code, elapsed_ms)
+		VALUES (NULL, '2024-01-01T00:00:00Z', '', '{}', ?, 0, 0)`, results)
+	if err != nil {
+		t.Fatalf("insert legacy row: %v", err)
+	}
+	legacyID, _ := res.LastInsertId()
+	legacy, err := s.getRun(ctx, legacyID)
+	if err != nil || legacy.ResultsJSON != results {
+		t.Fatalf("legacy results=%q err=%v", legacy.ResultsJSON, err)
+	}
+	runs, err := s.listRuns(ctx, "")
+	if err != nil || len(runs) != 2 {
+		t.Fatalf("listRuns=%d err=%v", len(runs), err)
+	}
+}
+
+func TestConsensusGroupsRequiresEveryBrowser(t *testing.T) {
+	browserReports := []BrowserReport{{Name: "chromium"}, {Name: "firefox"}, {Name: "webkit"}}
+	groups := []MergedGroup{
+		{Group: GroupedViolation{Key: "script-src -> https://cdn.example"}, Browsers: []string{"chromium", "firefox", "webkit"}},
+		{Group: GroupedViolation{Key: "img-src -> https://pixel.example"}, Browsers: []string{"chromium", "webkit"}},
+	}
+	got := consensusGroups(groups, browserReports)
+	if len(got) != 1 || got[0].Group.Key != "script-src -> https://cdn.example" {
+		t.Fatalf("consensusGroups = %+v", got)
+	}
+}
+
+func TestMergedPolicyForPage(t *testing.T) {
+	policy := "default-src 'self'; script-src 'self' https://a.example"
+	result := ReportPageResult{Violations: []Violation{
+		{EffectiveDirective: "script-src", BlockedURI: "https://cdn.example/app.js", BlockedOrigin: "https://cdn.example", OriginalPolicy: policy},
+		{EffectiveDirective: "img-src", BlockedURI: "https://pixel.example/p.gif", BlockedOrigin: "https://pixel.example", OriginalPolicy: policy},
+	}}
+	got := mergedPolicyForPage(result)
+	want := "default-src 'self'; script-src 'self' https://a.example https://cdn.example; img-src 'self' https://pixel.example"
+	if got != want {
+		t.Fatalf("merged policy:\n got %q\nwant %q", got, want)
+	}
+
+	scratch := mergedPolicyForPage(ReportPageResult{Violations: []Violation{
+		{EffectiveDirective: "style-src-attr", BlockedURI: "inline"},
+	}})
+	if scratch != "default-src 'self'; style-src-attr 'self' 'unsafe-inline'" {
+		t.Fatalf("scratch policy = %q", scratch)
+	}
+}
+
+func TestURLListRoundTrip(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	text := "https://example.org/\nhttps://example.org/about"
+
+	id, err := s.createURLList(ctx, "Main site", text)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	got, err := s.getURLList(ctx, id)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got.Name != "Main site" || got.URLsText != text {
+		t.Fatalf("got %+v", got)
+	}
+	if _, err := s.createURLList(ctx, "Main site", "https://other.example/"); err == nil {
+		t.Fatal("duplicate name accepted")
+	}
+	if rec := get(t, s.routes(), "/"); !strings.Contains(rec.Body.String(), "Main site") {
+		t.Fatal("run form does not offer the saved list")
+	}
+}
+
+func TestViolationMatrix(t *testing.T) {
+	s := newTestServer(t)
+	shared := Violation{EffectiveDirective: "script-src", BlockedOrigin: "https://cdn.example"}
+	first := MultiReport{Browsers: map[string]Report{"chromium": {Results: []ReportPageResult{
+		{URL: "https://example.org/", Violations: []Violation{shared, {EffectiveDirective: "img-src", BlockedOrigin: "https://pixel.example"}}},
+	}}}}
+	second := MultiReport{Browsers: map[string]Report{"chromium": {Results: []ReportPageResult{
+		{URL: "https://example.org/", Violations: []Violation{shared, {EffectiveDirective: "font-src", BlockedOrigin: "https://fonts.example"}}},
+	}}}}
+	firstID := seedRun(t, s, "https://example.org/", first)
+	secondID := seedRun(t, s, "https://example.org/", second)
+	p, _ := s.getProfileByName(context.Background(), defaultProfileName)
+
+	m, err := s.violationMatrix(context.Background(), p.ID, 10)
+	if err != nil {
+		t.Fatalf("violationMatrix: %v", err)
+	}
+	if len(m.Runs) != 2 || m.Runs[0].ID != firstID || m.Runs[1].ID != secondID {
+		t.Fatalf("runs not oldest first: %+v", m.Runs)
+	}
+	got := map[string][]int{}
+	for _, row := range m.Rows {
+		got[row.Key] = row.Counts
+	}
+	want := map[string][]int{
+		"script-src -> https://cdn.example": {1, 1},
+		"img-src -> https://pixel.example":  {1, 0},
+		"font-src -> https://fonts.example": {0, 1},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("rows = %v, want %v", got, want)
+	}
+
+	rec := get(t, s.routes(), fmt.Sprintf("/profiles/%d/matrix?limit=1", p.ID))
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "img-src") {
+		t.Fatalf("limit=1 matrix status %d includes older run", rec.Code)
+	}
+}
+
+func TestExportFilenameSanitizesLabel(t *testing.T) {
+	run := Run{ID: 42, CreatedAt: "2024-05-06T07:08:09Z", Label: " before deploy/../etc\\prod "}
+	if got, want := exportFilename(run), "csp-before-deploy-etc-prod-2024-05-06-42.json"; got != want {
+		t.Fatalf("exportFilename = %q, want %q", got, want)
+	}
+	if got := exportFilename(Run{ID: 7, CreatedAt: "2024-05-06T07:08:09Z"}); got != "csp-run-7.json" {
+		t.Fatalf("unlabelled exportFilename = %q", got)
+	}
+}
+
+func TestDuplicateProfileNameConflicts(t *testing.T) {
+	s := newTestServer(t)
+	h := s.routes()
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/profiles", strings.NewReader("name=Staging"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := post(); rec.Code != http.StatusSeeOther {
+		t.Fatalf("first create: %d %s", rec.Code, rec.Body)
+	}
+	rec := post()
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("duplicate create: %d %s", rec.Code, rec.Body)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `a profile named "Staging" already exists`) || strings.Contains(body, "constraint") {
+		t.Fatalf("duplicate create body: %q", body)
+	}
+	if err := s.createProfile(context.Background(), "Staging", "{}"); !isUniqueViolation(err) {
+		t.Fatalf("isUniqueViolation(%v) = false", err)
+	}
+}
+
+func TestSchedulerRunsDueSchedule(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	var checked []string
+	s.checker = CheckerFunc(func(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
+		checked = append(checked, urls...)
+		return MultiReport{Browsers: map[string]Report{"chromium": {}}}, 0, nil
+	})
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	id, err := s.createSchedule(ctx, sql.NullInt64{}, "https://example.org/", 60, start)
+	if err != nil {
+		t.Fatalf("create schedule: %v", err)
+	}
+
+	now := start.Add(30 * time.Minute)
+	sched := newScheduler(s, func() time.Time { return now })
+	sched.runDue(ctx)
+	if len(checked) != 0 {
+		t.Fatalf("schedule ran before it was due: %v", checked)
+	}
+
+	now = start.Add(61 * time.Minute)
+	sched.runDue(ctx)
+	sched.runDue(ctx)
+	if len(checked) != 1 || checked[0] != "https://example.org/" {
+		t.Fatalf("checked = %v, want one run", checked)
+	}
+	schedules, err := s.listSchedules(ctx)
+	if err != nil || len(schedules) != 1 {
+		t.Fatalf("listSchedules = %v, %v", schedules, err)
+	}
+	got := schedules[0]
+	if got.ID != id || !got.LastRunID.Valid || got.NextRunAt != now.Add(time.Hour).Format(time.RFC3339) {
+		t.Fatalf("schedule after run = %+v", got)
+	}
+	if _, err := s.getRun(ctx, got.LastRunID.Int64); err != nil {
+		t.Fatalf("scheduled run not stored: %v", err)
+	}
+	if rec := get(t, s.routes(), "/schedules"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Schedule #") {
+		t.Fatalf("schedules page: %d", rec.Code)
+	}
+}
+
+func TestMarkedFalsePositiveRendersStruckThrough(t *testing.T) {
+	s := newTestServer(t)
+	line := 12
+	v := Violation{DocumentURI: "https://example.org/", BlockedURI: "https://cdn.example/a.js", EffectiveDirective: "script-src", Disposition: "enforce", LineNumber: &line}
+	other := Violation{DocumentURI: "https://example.org/", BlockedURI: "https://cdn.example/b.js", EffectiveDirective: "script-src", Disposition: "enforce"}
+	id := seedRun(t, s, "https://example.org/", MultiReport{Browsers: map[string]Report{"chromium": {Results: []ReportPageResult{
+		{URL: "https://example.org/", Violations: []Violation{v, other}},
+	}}}})
+	if violationSignature(v) == violationSignature(other) {
+		t.Fatal("distinct violations share a signature")
+	}
+
+	form := fmt.Sprintf("run_id=%d&signature=%s&reason=browser+extension", id, violationSignature(v))
+	req := httptest.NewRequest(http.MethodPost, "/runs/mark-fp", strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("mark-fp: %d %s", rec.Code, rec.Body)
+	}
+
+	body := get(t, s.routes(), fmt.Sprintf("/runs/%d", id)).Body.String()
+	if n := strings.Count(body, `class="false-positive" title="False positive: browser extension"`); n != 1 {
+		t.Fatalf("found %d false-positive rows, want 1", n)
+	}
+}
+
+func TestAPIRunAnalysis(t *testing.T) {
+	s := newTestServer(t)
+	enforce := Violation{EffectiveDirective: "script-src", BlockedOrigin: "https://cdn.example", Disposition: "enforce"}
+	warn := Violation{EffectiveDirective: "img-src", BlockedOrigin: "https://pixel.example", Disposition: "report"}
+	page := func(vs ...Violation) Report {
+		return Report{Results: []ReportPageResult{{URL: "https://example.org/", Violations: vs}}}
+	}
+	id := seedRun(t, s, "https://example.org/", MultiReport{Browsers: map[string]Report{
+		"chromium": page(enforce, warn),
+		"firefox":  page(enforce),
+	}})
+
+	rec := get(t, s.routes(), fmt.Sprintf("/api/runs/%d/analysis", id))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var got RunAnalysis
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(got.Enforce) != 1 || got.Enforce[0].Key != "script-src -> https://cdn.example" || len(got.Enforce[0].Browsers) != 2 {
+		t.Fatalf("enforce = %+v", got.Enforce)
+	}
+	if len(got.CrossBrowser) != 1 || got.CrossBrowser[0].EffectiveDirective != "img-src" {
+		t.Fatalf("crossBrowser = %+v", got.CrossBrowser)
+	}
+	if rec := get(t, s.routes(), "/api/runs/999/analysis"); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown run status %d", rec.Code)
+	}
+}
+
+func TestAPIStatus(t *testing.T) {
+	s := newTestServer(t)
+	page := func(n int) MultiReport {
+		vs := make([]Violation, n)
+		for i := range vs {
+			vs[i] = Violation{EffectiveDirective: "img-src", BlockedOrigin: fmt.Sprintf("https://%d.example", i)}
+		}
+		return MultiReport{Browsers: map[string]Report{"chromium": {
+			Totals:  ReportTotals{Pages: 1, Violations: n},
+			Results: []ReportPageResult{{URL: "https://example.org/", Violations: vs}},
+		}}}
+	}
+	if _, err := s.db.Exec(`INSERT INTO runs (profile_id, created_at, urls_text, summary_json, results_json, exit_code, elapsed_ms)
+		VALUES (NULL, '2020-01-01T00:00:00Z', '', '{"violations":5}', '{}', 0, 0)`); err != nil {
+		t.Fatalf("insert old run: %v", err)
+	}
+	seedRun(t, s, "https://example.org/", page(2))
+	seedRun(t, s, "https://example.org/", page(3))
+
+	rec := get(t, s.routes(), "/api/status")
+	var got StatusSnapshot
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body, err)
+	}
+	if got.TotalRuns != 3 || got.RunsLast24h != 2 || got.ViolationsLast24h != 5 || got.QueueDepth != 0 {
+		t.Fatalf("status = %+v", got)
+	}
+	if got.LatestRunAt == nil || got.LatestRunViolations == nil || *got.LatestRunViolations != 3 {
+		t.Fatalf("latest run = %v / %v", got.LatestRunAt, got.LatestRunViolations)
+	}
+}
+
+func TestRunDetailShowsCapturedCSPHeader(t *testing.T) {
+	s := newTestServer(t)
+	multi := MultiReport{Browsers: map[string]Report{"chromium": {Results: []ReportPageResult{{
+		URL: "https://example.org/",
+		Headers: map[string]string{
+			"content-security-policy": "default-src 'self'",
+			"set-cookie":              "session=secret",
+		},
+	}}}}}
+	maskResponseHeaders(multi)
+	id := seedRun(t, s, "https://example.org/", multi)
+
+	body := get(t, s.routes(), fmt.Sprintf("/runs/%d", id)).Body.String()
+	if !strings.Contains(body, "content-security-policy") || !strings.Contains(body, "default-src &#39;self&#39;") {
+		t.Fatal("run detail does not show the CSP header")
+	}
+	if strings.Contains(body, "session=secret") {
+		t.Fatal("cookie header value was not masked")
+	}
+}
+
+func TestProfileRunLastUsesNewestRunURLs(t *testing.T) {
+	s := newTestServer(t)
+	var checked []string
+	s.checker = CheckerFunc(func(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
+		checked = urls
+		return MultiReport{Browsers: map[string]Report{"chromium": {}}}, 0, nil
+	})
+	seedRun(t, s, "https://old.example/", MultiReport{})
+	seedRun(t, s, "https://new.example/", MultiReport{})
+	p, _ := s.getProfileByName(context.Background(), defaultProfileName)
+
+	last, err := s.lastRunForProfile(context.Background(), p.ID)
+	if err != nil || last.URLsText != "https://new.example/" {
+		t.Fatalf("lastRunForProfile = %q, %v", last.URLsText, err)
+	}
+
+	post := func(id int64) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/profiles/run-last", strings.NewReader(fmt.Sprintf("profile_id=%d", id)))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		s.routes().ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := post(p.ID); rec.Code != http.StatusSeeOther {
+		t.Fatalf("run-last: %d %s", rec.Code, rec.Body)
+	}
+	if fmt.Sprint(checked) != "[https://new.example/]" {
+		t.Fatalf("checked %v", checked)
+	}
+
+	if err := s.createProfile(context.Background(), "Fresh", "{}"); err != nil {
+		t.Fatal(err)
+	}
+	fresh, _ := s.getProfileByName(context.Background(), "Fresh")
+	if rec := post(fresh.ID); rec.Code != http.StatusBadRequest {
+		t.Fatalf("run-last without runs: %d", rec.Code)
+	}
+}
+
+func TestExcludePatternsFilterGroupedViolations(t *testing.T) {
+	cases := []struct {
+		url  string
+		want bool
+	}{
+		{"https://example.org/embed/video", true},
+		{"https://example.org/about", false},
+		{"https://widgets.example/frame", true},
+		{"https://example.org/legacy/old.html", true},
+		{"https://example.org/legacy", false},
+	}
+	patterns := []string{"/embed/", "https://widgets.example/", "https://example.org/legacy/*.html"}
+	for _, c := range cases {
+		if got := matchesExclude(c.url, patterns); got != c.want {
+			t.Errorf("matchesExclude(%q) = %v, want %v", c.url, got, c.want)
+		}
+	}
+
+	results := []ReportPageResult{
+		{URL: "https://example.org/about", Violations: []Violation{{EffectiveDirective: "img-src", BlockedOrigin: "https://pixel.example"}}},
+		{URL: "https://example.org/embed/video", Violations: []Violation{{EffectiveDirective: "frame-src", BlockedOrigin: "https://video.example"}}},
+	}
+	groups := groupViolations(filterExcluded(results, patterns), false)
+	if len(groups) != 1 || groups[0].EffectiveDirective != "img-src" {
+		t.Fatalf("groups = %+v", groups)
+	}
+
+	multi := MultiReport{
+		Config:   map[string]any{"excludePatterns": []any{"/embed/"}},
+		Browsers: map[string]Report{"chromium": {Results: results}},
+	}
+	b := buildBrowserReports(multi)[0]
+	if len(b.Report.Results) != 2 || b.Excluded != 1 {
+		t.Fatalf("raw results %d, excluded %d", len(b.Report.Results), b.Excluded)
+	}
+}
+
+func TestWebhookRetriesUntilSuccess(t *testing.T) {
+	var hits int
+	var got WebhookPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if hits <= 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	n := &webhookNotifier{url: srv.URL, client: srv.Client(), attempts: 5, baseDelay: time.Millisecond, deadline: time.Second}
+	if err := n.notify(context.Background(), WebhookPayload{Event: "run.completed", RunID: 9}); err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+	if hits != 3 || got.RunID != 9 {
+		t.Fatalf("hits=%d payload=%+v", hits, got)
+	}
+
+	var deadHits int
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadHits++
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer dead.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	slow := &webhookNotifier{url: dead.URL, client: dead.Client(), attempts: 10, baseDelay: 50 * time.Millisecond}
+	if err := slow.notify(ctx, WebhookPayload{}); err == nil {
+		t.Fatal("notify succeeded against a dead endpoint")
+	}
+	if deadHits != 1 {
+		t.Fatalf("attempted %d times within the deadline, want 1", deadHits)
+	}
+}
+
+func TestPostRunCommandReceivesRunID(t *testing.T) {
+	s := newTestServer(t)
+	s.checker = CheckerFunc(func(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
+		return MultiReport{Browsers: map[string]Report{"chromium": {Results: []ReportPageResult{{URL: urls[0], OK: true, Violations: []Violation{}}}}}}, 0, nil
+	})
+	out := filepath.Join(t.TempDir(), "hook")
+	s.postRunHook = &postRunHook{command: fmt.Sprintf(`cat > '%s.json' && echo "$CSP_RUN_ID" > '%s'`, out, out), timeout: 5 * time.Second}
+
+	id, err := s.executeRun(context.Background(), sql.NullInt64{}, "https://example.org/", defaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if got, err = os.ReadFile(out); err == nil && len(got) > 0 {
+			break
+		}
+	}
+	if strings.TrimSpace(string(got)) != fmt.Sprint(id) {
+		t.Fatalf("hook wrote %q, want run ID %d", got, id)
+	}
+	var payload WebhookPayload
+	if data, err := os.ReadFile(out + ".json"); err != nil || json.Unmarshal(data, &payload) != nil || payload.RunID != id || payload.Event != "run.completed" {
+		t.Errorf("stdin payload = %+v (%v)", payload, err)
+	}
+
+	start := time.Now()
+	(&postRunHook{command: "sleep 10", timeout: 50 * time.Millisecond}).run(WebhookPayload{RunID: id})
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("hook ran for %s past its timeout", time.Since(start))
+	}
+}
+
+func TestProfileWebhookNotifiedAboveThreshold(t *testing.T) {
+	got := make(chan WebhookPayload, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/hooks/strict-token" {
+			t.Errorf("notified %s", r.URL.Path)
+		}
+		var p WebhookPayload
+		_ = json.NewDecoder(r.Body).Decode(&p)
+		got <- p
+	}))
+	defer srv.Close()
+
+	s := newTestServer(t)
+	ctx := context.Background()
+	s.checker = CheckerFunc(func(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
+		vs := []Violation{{EffectiveDirective: "img-src", BlockedOrigin: "https://cdn.example", Disposition: "enforce"}}
+		return MultiReport{Browsers: map[string]Report{"chromium": {Totals: ReportTotals{Pages: 1, Violations: 1}, Results: []ReportPageResult{{URL: urls[0], Violations: vs}}}}}, 1, nil
+	})
+	if _, err := parseConfig(`{"notifyWebhook": "ftp://hooks.example/x"}`); err == nil {
+		t.Error("parseConfig accepted a non-http webhook")
+	}
+	if err := s.createProfile(ctx, "Strict", fmt.Sprintf(`{"notifyWebhook": %q}`, srv.URL+"/hooks/strict-token")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.createProfile(ctx, "Lenient", fmt.Sprintf(`{"notifyWebhook": %q, "notifyThreshold": 5}`, srv.URL+"/hooks/lenient")); err != nil {
+		t.Fatal(err)
+	}
+	strict, _ := s.getProfileByName(ctx, "Strict")
+	lenient, _ := s.getProfileByName(ctx, "Lenient")
+
+	if _, err := s.executeRun(ctx, sql.NullInt64{Int64: lenient.ID, Valid: true}, "https://example.org/", defaultConfig()); err != nil {
+		t.Fatal(err)
+	}
+	id, err := s.executeRun(ctx, sql.NullInt64{Int64: strict.ID, Valid: true}, "https://example.org/", defaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case p := <-got:
+		if p.RunID != id || p.Event != "run.over_threshold" || p.ProfileID == nil || *p.ProfileID != strict.ID {
+			t.Errorf("payload = %+v, want run %d of profile %d", p, id, strict.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("profile webhook not notified")
+	}
+	select {
+	case p := <-got:
+		t.Errorf("unexpected notification %+v", p)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cfg, _ := parseConfig(strict.ConfigJSON)
+	if red := cfg.redacted().NotifyWebhook; strings.Contains(red, "strict-token") {
+		t.Errorf("redacted webhook = %q", red)
+	}
+}
+
+func TestViolationTrackingResolvesMissingGroups(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	def, _ := s.getProfileByName(ctx, defaultProfileName)
+	profileID := sql.NullInt64{Int64: def.ID, Valid: true}
+	page := func(vs ...Violation) MultiReport {
+		return MultiReport{Browsers: map[string]Report{"chromium": {Results: []ReportPageResult{{URL: "https://example.org/", OK: true, Violations: vs}}}}}
+	}
+	kept := Violation{EffectiveDirective: "script-src", BlockedOrigin: "https://cdn.example"}
+	fixed := Violation{EffectiveDirective: "font-src", BlockedOrigin: "https://fonts.example"}
+	reports := []MultiReport{page(kept, fixed), page(kept)}
+	s.checker = CheckerFunc(func(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
+		rep := reports[0]
+		reports = reports[1:]
+		return rep, 0, nil
+	})
+	for i := 0; i < 2; i++ {
+		if _, err := s.executeRun(ctx, profileID, "https://example.org/", defaultConfig()); err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+	}
+
+	tracks, err := s.violationTracks(ctx, def.ID)
+	if err != nil || len(tracks) != 2 {
+		t.Fatalf("tracks = %+v, err %v", tracks, err)
+	}
+	byKey := map[string]ViolationTrack{}
+	for _, tr := range tracks {
+		byKey[tr.Key] = tr
+	}
+	if tr := byKey["font-src -> https://fonts.example"]; tr.ResolvedAt == "" {
+		t.Errorf("missing group not resolved: %+v", tr)
+	}
+	if tr := byKey["script-src -> https://cdn.example"]; tr.ResolvedAt != "" || tr.FirstSeen == "" || tr.LastSeen == "" {
+		t.Errorf("seen group: %+v", tr)
+	}
+	if tracks[0].Key != "script-src -> https://cdn.example" {
+		t.Errorf("open group not listed first: %+v", tracks)
+	}
+}
+
+func TestViolationTrackingKeepsUncheckedPagesOpen(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	def, _ := s.getProfileByName(ctx, defaultProfileName)
+	script := Violation{EffectiveDirective: "script-src", BlockedOrigin: "https://cdn.example"}
+	font := Violation{EffectiveDirective: "font-src", BlockedOrigin: "https://fonts.example"}
+	img := Violation{EffectiveDirective: "img-src", BlockedOrigin: "https://ads.example"}
+	track := func(multi MultiReport) map[string]ViolationTrack {
+		t.Helper()
+		seen, checked := violationScopes(multi)
+		if err := s.trackViolations(ctx, def.ID, 1, time.Now().UTC().Format(time.RFC3339), seen, checked); err != nil {
+			t.Fatal(err)
+		}
+		tracks, err := s.violationTracks(ctx, def.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		byKey := map[string]ViolationTrack{}
+		for _, tr := range tracks {
+			byKey[tr.Key] = tr
+		}
+		return byKey
+	}
+
+	track(MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{
+			{URL: "https://example.org/a", OK: true, Violations: []Violation{script}},
+			{URL: "https://example.org/b", OK: true, Violations: []Violation{font, img}},
+		}},
+		"firefox": {Results: []ReportPageResult{
+			{URL: "https://example.org/a", OK: true, Violations: []Violation{script}},
+		}},
+	}})
+
+	// A re-check of page a alone, with firefox failing.
+	byKey := track(MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{{URL: "https://example.org/a", OK: true}}},
+		"firefox":  {Error: "firefox: crashed"},
+	}})
+	if tr := byKey["font-src -> https://fonts.example"]; tr.ResolvedAt != "" {
+		t.Errorf("group on an unchecked page resolved: %+v", tr)
+	}
+	if tr := byKey["script-src -> https://cdn.example"]; tr.ResolvedAt != "" {
+		t.Errorf("group still seen by the failed browser resolved: %+v", tr)
+	}
+
+	// Page b re-checked without the font violation resolves only that group.
+	byKey = track(MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{{URL: "https://example.org/b", OK: true, Violations: []Violation{img}}}},
+	}})
+	if tr := byKey["font-src -> https://fonts.example"]; tr.ResolvedAt == "" {
+		t.Errorf("re-checked group not resolved: %+v", tr)
+	}
+	if tr := byKey["img-src -> https://ads.example"]; tr.ResolvedAt != "" {
+		t.Errorf("seen group resolved: %+v", tr)
+	}
+
+	byKey = track(MultiReport{Browsers: map[string]Report{
+		"firefox": {Results: []ReportPageResult{{URL: "https://example.org/a", OK: true}}},
+	}})
+	if tr := byKey["script-src -> https://cdn.example"]; tr.ResolvedAt == "" {
+		t.Errorf("group gone from every checked page not resolved: %+v", tr)
+	}
+}
+
+func TestRegressionBadgeCountsNewViolations(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	page := func(vs ...Violation) MultiReport {
+		return MultiReport{Browsers: map[string]Report{"chromium": {Results: []ReportPageResult{{URL: "https://example.org/", Violations: vs}}}}}
+	}
+	kept := Violation{EffectiveDirective: "script-src", BlockedOrigin: "https://cdn.example"}
+	fixed := Violation{EffectiveDirective: "font-src", BlockedOrigin: "https://fonts.example"}
+	added := Violation{EffectiveDirective: "img-src", BlockedOrigin: "https://pixel.example"}
+	baseID := seedRun(t, s, "https://example.org/", page(kept, fixed))
+	runID := seedRun(t, s, "https://example.org/", page(kept, added))
+
+	base, _ := s.getRun(ctx, baseID)
+	if err := s.setBaseline(ctx, base.ProfileID.Int64, baseID); err != nil {
+		t.Fatalf("setBaseline: %v", err)
+	}
+	run, _ := s.getRun(ctx, runID)
+	badges := s.regressionBadges(ctx, []Run{base, run})
+	badge := badges[runID]
+	if badge == nil || badge.New != 1 || badge.Resolved != 1 || badge.BaselineRunID != baseID {
+		t.Fatalf("badge = %+v", badge)
+	}
+	if _, ok := badges[baseID]; ok {
+		t.Fatal("baseline run has a badge against itself")
+	}
+
+	body := get(t, s.routes(), "/runs").Body.String()
+	if strings.Count(body, "+1 new, -1 resolved") != 1 {
+		t.Fatal("runs list does not show exactly one badge")
+	}
+}
+
+func TestAPIQuickCheckOverrides(t *testing.T) {
+	s := newTestServer(t)
+	var used CSPConfig
+	s.checker = CheckerFunc(func(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
+		used = cfg
+		return MultiReport{Browsers: map[string]Report{"chromium": {Totals: ReportTotals{Pages: len(urls)}}}}, 0, nil
+	})
+
+	rec := get(t, s.routes(), "/api/quick-check?url=https%3A%2F%2Fexample.org%2F&waitUntil=load&navTimeoutMs=60000")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if used.WaitUntil != "load" || used.NavTimeoutMs != 60000 {
+		t.Fatalf("check ran with waitUntil=%q nav=%d", used.WaitUntil, used.NavTimeoutMs)
+	}
+	var res QuickCheckResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if res.EffectiveConfig.WaitUntil != "load" || res.EffectiveConfig.SettleWaitMs != defaultConfig().SettleWaitMs || res.Summary.Pages != 1 {
+		t.Fatalf("result = %+v", res)
+	}
+
+	for _, bad := range []string{"waitUntil=soon", "navTimeoutMs=0", "disableJs=maybe", "waitUntl=load", "devices=watch"} {
+		if rec := get(t, s.routes(), "/api/quick-check?url=https%3A%2F%2Fexample.org%2F&"+bad); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", bad, rec.Code)
+		}
+	}
+}
+
+func TestAPIQuickCheckDeFlakes(t *testing.T) {
+	s := newTestServer(t)
+	calls := 0
+	s.checker = CheckerFunc(func(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
+		calls++
+		return MultiReport{Browsers: map[string]Report{"chromium": {Results: []ReportPageResult{{URL: urls[0]}}}}}, 0, nil
+	})
+	cfg := defaultConfig()
+	cfg.DeFlake = true
+	cfgJSON, _ := json.Marshal(cfg)
+	if err := s.createProfile(context.Background(), "Steady", string(cfgJSON)); err != nil {
+		t.Fatal(err)
+	}
+	p, err := s.getProfileByName(context.Background(), "Steady")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := get(t, s.routes(), fmt.Sprintf("/api/quick-check?url=https%%3A%%2F%%2Fexample.org%%2F&profile_id=%d", p.ID))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var res QuickCheckResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if calls != 2 || res.Report.Config["deFlake"] != true {
+		t.Fatalf("calls = %d, config = %v; want a de-flaked check", calls, res.Report.Config)
+	}
+}
+
+func TestAPIURLHistory(t *testing.T) {
+	s := newTestServer(t)
+	page := func(url string, n int) ReportPageResult {
+		return ReportPageResult{URL: url, Violations: make([]Violation, n)}
+	}
+	first := seedRun(t, s, "https://example.org/", MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{page("https://example.org/", 2)}},
+		"firefox":  {Results: []ReportPageResult{page("https://example.org/", 1)}},
+	}})
+	seedRun(t, s, "https://example.org/other", MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{page("https://example.org/other", 4)}},
+	}})
+	second := seedRun(t, s, "https://example.org", MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{page("https://example.org", 0)}},
+	}})
+
+	rec := get(t, s.routes(), "/api/url-history?url=https%3A%2F%2Fexample.org")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var points []URLHistoryPoint
+	if err := json.Unmarshal(rec.Body.Bytes(), &points); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(points) != 2 || points[0].RunID != first || points[0].Violations != 3 || points[1].RunID != second || points[1].Violations != 0 {
+		t.Fatalf("points = %+v", points)
+	}
+	if rec := get(t, s.routes(), "/api/url-history?url=example.org"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("relative url: %d", rec.Code)
+	}
+}
+
+func TestAPILatestForURL(t *testing.T) {
+	s := newTestServer(t)
+	seedRun(t, s, "https://example.org/", MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{{URL: "https://example.org/", Violations: make([]Violation, 5)}}},
+	}})
+	newest := seedRun(t, s, "https://example.org/\nhttps://example.org/about", MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{
+			{URL: "https://example.org/", Violations: make([]Violation, 1)},
+			{URL: "https://example.org/about", Violations: make([]Violation, 3)},
+		}},
+		"firefox": {Results: []ReportPageResult{{URL: "https://example.org/", Violations: make([]Violation, 1)}}},
+	}})
+
+	rec := get(t, s.routes(), "/api/latest-for-url?url=https%3A%2F%2Fexample.org")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var got URLHistoryPoint
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.RunID != newest || got.Violations != 2 || got.CreatedAt == "" {
+		t.Fatalf("latest = %+v, want run %d with 2 violations", got, newest)
+	}
+	if rec := get(t, s.routes(), "/api/latest-for-url?url=https%3A%2F%2Fexample.org%2Fnever"); rec.Code != http.StatusNotFound {
+		t.Fatalf("unchecked url: %d", rec.Code)
+	}
+}
+
+func TestDurationOutliers(t *testing.T) {
+	var results []ReportPageResult
+	for i, ms := range []int64{900, 1100, 1000, 1200, 5000, 0} {
+		results = append(results, ReportPageResult{URL: fmt.Sprintf("https://example.org/%d", i), DurationMs: ms})
+	}
+	if got := durationOutliers(results); fmt.Sprint(got) != "[https://example.org/4]" {
+		t.Fatalf("durationOutliers = %v", got)
+	}
+	if got := durationOutliers(results[:2]); got != nil {
+		t.Fatalf("two pages flagged %v", got)
+	}
+}
+
+func TestAPIRunRawReport(t *testing.T) {
+	s := newTestServer(t)
+	// nodeVersion and debug are not modeled by Report and must survive.
+	chromium := `{"generatedAt":"2024-01-01T00:00:00Z","nodeVersion":"v20.11.0","totals":{"pages":1,"violations":1},` +
+		`"results":[{"url":"https://example.org/","ok":true,"debug":{"frames":2},"violations":[{"effectiveDirective":"script-src","blockedOrigin":"https://cdn.example"}]}]}`
+	id, err := s.insertRun(context.Background(), Run{
+		CreatedAt:   "2024-01-01T00:00:00Z",
+		URLsText:    "https://example.org/",
+		SummaryJSON: "{}",
+		ResultsJSON: `{"generatedAt":"2024-01-01T00:00:00Z","browsers":{"chromium":` + chromium + `}}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := get(t, s.routes(), fmt.Sprintf("/api/runs/%d/raw?browser=chromium", id))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	want := chromium
+	if got := strings.TrimSpace(rec.Body.String()); got != want {
+		t.Fatalf("raw report = %s\nwant %s", got, want)
+	}
+	if rec := get(t, s.routes(), fmt.Sprintf("/api/runs/%d/raw?browser=webkit", id)); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown browser: %d", rec.Code)
+	}
+}
+
+func TestAPIRunVsBaseline(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	page := func(vs ...Violation) MultiReport {
+		return MultiReport{Browsers: map[string]Report{"chromium": {Results: []ReportPageResult{{URL: "https://example.org/", Violations: vs}}}}}
+	}
+	kept := Violation{EffectiveDirective: "script-src", BlockedOrigin: "https://cdn.example"}
+	added := Violation{EffectiveDirective: "img-src", BlockedOrigin: "https://pixel.example"}
+	baseID := seedRun(t, s, "https://example.org/", page(kept))
+	runID := seedRun(t, s, "https://example.org/", page(kept, added))
+
+	path := fmt.Sprintf("/api/runs/%d/vs-baseline", runID)
+	if rec := get(t, s.routes(), path); rec.Code != http.StatusConflict {
+		t.Fatalf("without baseline: %d", rec.Code)
+	}
+	base, _ := s.getRun(ctx, baseID)
+	if err := s.setBaseline(ctx, base.ProfileID.Int64, baseID); err != nil {
+		t.Fatalf("setBaseline: %v", err)
+	}
+	rec := get(t, s.routes(), path)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var d RunDiff
+	if err := json.Unmarshal(rec.Body.Bytes(), &d); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if fmt.Sprint(d.New) != "[img-src -> https://pixel.example]" || len(d.Resolved) != 0 {
+		t.Fatalf("diff = %+v", d)
+	}
+}
+
+func TestRunCSPCheckKeepsPartialResultsOnTruncatedReport(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "fake-check.sh")
+	body := `if [ "$CSP_BROWSER" = firefox ]; then
+  printf '{"totals":{"pages":1' > "$CSP_OUTPUT_FILE"
+else
+  printf '{"totals":{"pages":1,"violations":0},"results":[]}' > "$CSP_OUTPUT_FILE"
+fi
+`
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CSP_NODE_BIN", "/bin/sh")
+	t.Setenv("CSP_SCRIPT_PATH", script)
+
+	multi, exit, err := runCSPCheck(context.Background(), []string{"https://example.org/"}, defaultConfig(), 1)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if exit < 2 {
+		t.Errorf("exit = %d, want at least 2", exit)
+	}
+	if got := multi.Browsers["firefox"].Error; !strings.Contains(got, "incomplete") {
+		t.Errorf("firefox error = %q", got)
+	}
+	if multi.Browsers["chromium"].Error != "" || multi.Browsers["chromium"].Totals.Pages != 1 {
+		t.Errorf("chromium report lost: %+v", multi.Browsers["chromium"])
+	}
+}
+
+func TestRunCSPCheckStreamsStderrLines(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "fake-check.sh")
+	body := `echo "checking $CSP_BROWSER" >&2
+printf '{"totals":{"pages":1},"results":[]}' > "$CSP_OUTPUT_FILE"
+`
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CSP_NODE_BIN", "/bin/sh")
+	t.Setenv("CSP_SCRIPT_PATH", script)
+
+	lines, cancel := nodeLogs.subscribe()
+	defer cancel()
+	if _, _, err := runCSPCheck(context.Background(), []string{"https://example.org/"}, defaultConfig(), 1); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	for _, browser := range browsers {
+		select {
+		case line := <-lines:
+			if line.Browser != browser || line.Text != "checking "+browser {
+				t.Errorf("line = %+v, want %s", line, browser)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no stderr line for %s", browser)
+		}
+	}
+}
+
+func TestIndexPrefillsSelectedProfileDefaultURLs(t *testing.T) {
+	s := newTestServer(t)
+	cfg := defaultConfig()
+	cfg.DefaultURLs = "https://staging.example/\nhttps://staging.example/about"
+	cfgJSON, _ := json.Marshal(cfg)
+	if err := s.createProfile(context.Background(), "Staging", string(cfgJSON)); err != nil {
+		t.Fatal(err)
+	}
+	p, err := s.getProfileByName(context.Background(), "Staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := get(t, s.routes(), fmt.Sprintf("/?profile_id=%d", p.ID)).Body.String()
+	if !strings.Contains(body, ">https://staging.example/\nhttps://staging.example/about</textarea>") {
+		t.Fatal("run form not prefilled with the profile's default URLs")
+	}
+	if !strings.Contains(body, fmt.Sprintf("<option value=\"%d\" data-urls=\"https://staging.example/\nhttps://staging.example/about\" selected>", p.ID)) {
+		t.Fatal("profile option not selected with its default URLs")
+	}
+	if body := get(t, s.routes(), "/").Body.String(); strings.Contains(body, "staging.example/about</textarea>") {
+		t.Fatal("default profile picked up another profile's URLs")
+	}
+
+	var checked []string
+	s.checker = CheckerFunc(func(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
+		checked = urls
+		return MultiReport{Browsers: map[string]Report{"chromium": {}}}, 0, nil
+	})
+	req := httptest.NewRequest(http.MethodPost, "/runs", strings.NewReader(fmt.Sprintf("profile_id=%d&urls=", p.ID)))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther || len(checked) != 2 {
+		t.Fatalf("run with empty urls: %d, checked %v", rec.Code, checked)
+	}
+}
+
+func TestMigrateReportUpgradesV1(t *testing.T) {
+	v1 := []byte(`{
+		"generatedAt": "2024-01-02T03:04:05Z",
+		"totals": {"pages": 1, "violations": 1},
+		"results": [{"url": "https://example.org/", "ok": true, "durationMs": 1234,
+			"violations": [{"effectiveDirective": "script-src", "blockedURI": "inline"}]}]
+	}`)
+	rep, err := migrateReport(v1)
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if rep.SchemaVersion != reportSchemaVersion {
+		t.Errorf("schema version = %d", rep.SchemaVersion)
+	}
+	if len(rep.Results) != 1 || rep.Results[0].DurationMs != 1234 {
+		t.Fatalf("results = %+v", rep.Results)
+	}
+	if rep.Results[0].Violations[0].EffectiveDirective != "script-src" || rep.Totals.Violations != 1 {
+		t.Errorf("report fields lost in migration: %+v", rep)
+	}
+
+	if _, err := migrateReport([]byte(`{"schemaVersion": 99}`)); err == nil {
+		t.Error("newer schema version accepted")
+	}
+}
+
+func TestUpgradeReportDocRunsMigrationsInOrder(t *testing.T) {
+	var ran []int
+	step := func(v int) func(map[string]json.RawMessage) error {
+		return func(doc map[string]json.RawMessage) error {
+			ran = append(ran, v)
+			doc["step"] = json.RawMessage(fmt.Sprint(v))
+			return nil
+		}
+	}
+	migrations := map[int]func(map[string]json.RawMessage) error{1: step(1), 3: step(3), 4: step(4)}
+
+	doc := map[string]json.RawMessage{}
+	if err := upgradeReportDoc(doc, 2, 4, migrations); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ran) != "[3]" || string(doc["step"]) != "3" {
+		t.Errorf("ran %v, doc %s; want only the v3 migration", ran, doc["step"])
+	}
+
+	migrations[2] = func(map[string]json.RawMessage) error { return errors.New("bad shape") }
+	if err := upgradeReportDoc(doc, 1, 4, migrations); err == nil || !strings.Contains(err.Error(), "from v2") {
+		t.Errorf("failing migration err = %v", err)
+	}
+}
+
+func TestRecheckTimeoutsDoublesTimeouts(t *testing.T) {
+	s := newTestServer(t)
+	unsettled := false
+	multi := MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{
+			{URL: "https://example.org/slow", Settled: &unsettled},
+			{URL: "https://example.org/fast", OK: true},
+		}},
+		"firefox": {Results: []ReportPageResult{{URL: "https://example.org/slow", Settled: &unsettled}}},
+	}}
+	id := seedRun(t, s, "https://example.org/slow\nhttps://example.org/fast", multi)
+
+	var checked []string
+	var used CSPConfig
+	s.checker = CheckerFunc(func(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
+		checked, used = urls, cfg
+		return MultiReport{Browsers: map[string]Report{"chromium": {}}}, 0, nil
+	})
+	req := httptest.NewRequest(http.MethodPost, "/runs/recheck-timeouts", strings.NewReader(fmt.Sprintf("id=%d", id)))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("recheck: %d %s", rec.Code, rec.Body)
+	}
+	if fmt.Sprint(checked) != "[https://example.org/slow]" {
+		t.Errorf("checked %v", checked)
+	}
+	base := defaultConfig()
+	if used.NavTimeoutMs != 2*base.NavTimeoutMs || used.SettleWaitMs != 2*base.SettleWaitMs {
+		t.Errorf("timeouts = %d/%d, want doubled %d/%d", used.NavTimeoutMs, used.SettleWaitMs, base.NavTimeoutMs, base.SettleWaitMs)
+	}
+}
+
+func TestTemplateDirOverridesEmbeddedTemplates(t *testing.T) {
+	dir := t.TempDir()
+	custom := `{{template "header" .}}<p>Custom branding</p>{{template "footer"}}`
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte(custom), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CSP_TEMPLATE_DIR", dir)
+	tmpl, err := parseTemplates()
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	s := newTestServer(t)
+	s.tmpl = tmpl
+
+	if body := get(t, s.routes(), "/").Body.String(); !strings.Contains(body, "<p>Custom branding</p>") {
+		t.Fatal("override index.html not used")
+	}
+	if rec := get(t, s.routes(), "/runs"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Run History") {
+		t.Fatalf("embedded runs.html not used as fallback: %d", rec.Code)
+	}
+
+	t.Setenv("CSP_TEMPLATE_DIR", filepath.Join(dir, "missing"))
+	if _, err := parseTemplates(); err == nil {
+		t.Error("missing template dir accepted")
+	}
+}
+
+func TestPagesWithoutCSP(t *testing.T) {
+	yes, no := true, false
+	multi := MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{
+			{URL: "https://example.org/bare", OK: true, HasCSP: &no},
+			{URL: "https://example.org/covered", OK: true, HasCSP: &yes},
+			{URL: "https://example.org/old-script", OK: true},
+		}},
+		"firefox": {Results: []ReportPageResult{{URL: "https://example.org/bare", OK: true, HasCSP: &no}}},
+	}}
+	if got := pagesWithoutCSP(multi); fmt.Sprint(got) != "[https://example.org/bare]" {
+		t.Fatalf("pagesWithoutCSP = %v", got)
+	}
+	if n := summarizeMulti(multi).PagesWithoutCSP; n != 1 {
+		t.Errorf("summary PagesWithoutCSP = %d", n)
+	}
+
+	s := newTestServer(t)
+	id := seedRun(t, s, "https://example.org/bare", multi)
+	if body := get(t, s.routes(), fmt.Sprintf("/runs/%d", id)).Body.String(); !strings.Contains(body, "No CSP policy on 1 page(s): https://example.org/bare.") {
+		t.Error("run page does not warn about the page without CSP")
+	}
+	if body := get(t, s.routes(), "/runs").Body.String(); !strings.Contains(body, "1 without CSP") {
+		t.Error("run history does not count pages without CSP")
+	}
+}
+
+func TestDistinctBlockedOrigins(t *testing.T) {
+	v := func(origin string) Violation {
+		return Violation{EffectiveDirective: "script-src", BlockedOrigin: origin}
+	}
+	multi := MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{
+			{URL: "https://example.org/", Violations: []Violation{v("https://cdn.example"), v("https://ads.example"), v("")}},
+			{URL: "https://example.org/about", Violations: []Violation{v("https://cdn.example"), v("  ")}},
+		}},
+		"firefox": {Results: []ReportPageResult{
+			{URL: "https://example.org/", Violations: []Violation{v("https://fonts.example"), v("https://ads.example")}},
+		}},
+	}}
+	if n := distinctBlockedOrigins(multi); n != 3 {
+		t.Fatalf("distinctBlockedOrigins = %d, want 3", n)
+	}
+	if n := summarizeMulti(multi).BlockedOrigins; n != 3 {
+		t.Errorf("summary BlockedOrigins = %d", n)
+	}
+	if n := distinctBlockedOrigins(MultiReport{}); n != 0 {
+		t.Errorf("empty report = %d", n)
+	}
+}
+
+func TestMixedEnforcementAcrossPages(t *testing.T) {
+	v := func(d string) Violation {
+		return Violation{EffectiveDirective: "script-src", BlockedOrigin: "https://cdn.example", Disposition: d}
+	}
+	other := Violation{EffectiveDirective: "img-src", BlockedOrigin: "https://pixel.example", Disposition: "enforce"}
+	results := []ReportPageResult{
+		{URL: "https://example.org/", Violations: []Violation{v("enforce"), other}},
+		{URL: "https://example.org/beta", Violations: []Violation{v("report"), other}},
+	}
+	for _, g := range groupViolations(results, false) {
+		want := g.EffectiveDirective == "script-src"
+		if g.MixedEnforcement() != want {
+			t.Errorf("%s: MixedEnforcement = %v, want %v (%v)", g.Key, !want, want, g.PageDispositions)
+		}
+		if want && g.PageDispositions["https://example.org/beta"] != "report-only" {
+			t.Errorf("beta disposition = %q", g.PageDispositions["https://example.org/beta"])
+		}
+	}
+
+	s := newTestServer(t)
+	id := seedRun(t, s, "https://example.org/", MultiReport{Browsers: map[string]Report{"chromium": {Results: results}}})
+	body := get(t, s.routes(), fmt.Sprintf("/runs/%d", id)).Body.String()
+	if !strings.Contains(body, "script-src → https://cdn.example <span class=\"warning\" title=\"Enforced on some pages but only reported on others\">mixed enforcement</span>") {
+		t.Error("run page does not label the mixed group")
+	}
+	if strings.Contains(body, "https://pixel.example <span class=\"warning\"") {
+		t.Error("enforce-only group labelled mixed")
+	}
+}
+
+func TestAllDispositionsGroupsReportSplit(t *testing.T) {
+	v := func(d string) Violation {
+		return Violation{EffectiveDirective: "script-src", BlockedOrigin: "https://cdn.example", Disposition: d}
+	}
+	multi := MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{
+			{URL: "https://example.org/a", Violations: []Violation{v("enforce"), v("enforce"), v("report")}},
+			{URL: "https://example.org/b", Violations: []Violation{v(""), v("report")}},
+		}},
+	}}
+	groups := groupViolationsMulti(buildBrowserReports(multi), false)
+	if len(groups) != 1 {
+		t.Fatalf("groups = %d, want 1", len(groups))
+	}
+	g := groups[0].Group
+	if g.Count != 5 || g.Enforce != 3 || g.ReportOnly != 2 || g.DispositionMix() != "3 enforce, 2 report-only" {
+		t.Fatalf("group split = %d/%d/%d %q", g.Count, g.Enforce, g.ReportOnly, g.DispositionMix())
+	}
+
+	s := newTestServer(t)
+	id := seedRun(t, s, "https://example.org/a", multi)
+	body := get(t, s.routes(), fmt.Sprintf("/runs/%d?disposition=all", id)).Body.String()
+	if !strings.Contains(body, "Grouped Issues (All)") || !strings.Contains(body, "3 enforce, 2 report-only") {
+		t.Error("combined view does not show the disposition split")
+	}
+	if strings.Contains(body, "Grouped Issues (Warnings)") {
+		t.Error("combined view still shows a separate warnings card")
+	}
+}
+
+func TestProfileCapRejectsAndDeleteFreesSlot(t *testing.T) {
+	s := newTestServer(t)
+	s.maxProfiles = 2
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		s.routes().ServeHTTP(rec, req)
+		return rec
+	}
+	for _, name := range []string{"One", "Two"} {
+		if rec := post("/profiles", "name="+name); rec.Code != http.StatusSeeOther {
+			t.Fatalf("create %s: %d %s", name, rec.Code, rec.Body)
+		}
+	}
+	rec := post("/profiles", "name=Three")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "profile limit reached") {
+		t.Fatalf("create past cap: %d %s", rec.Code, rec.Body)
+	}
+
+	def, _ := s.getProfileByName(context.Background(), defaultProfileName)
+	if rec := post("/profiles/delete", fmt.Sprintf("id=%d", def.ID)); rec.Code != http.StatusBadRequest {
+		t.Fatalf("delete default: %d", rec.Code)
+	}
+	one, _ := s.getProfileByName(context.Background(), "One")
+	if rec := post("/profiles/delete", fmt.Sprintf("id=%d", one.ID)); rec.Code != http.StatusSeeOther {
+		t.Fatalf("delete: %d %s", rec.Code, rec.Body)
+	}
+	if rec := post("/profiles", "name=Three"); rec.Code != http.StatusSeeOther {
+		t.Fatalf("create after delete: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestArchivedProfileHiddenFromRunForm(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	if err := s.createProfile(ctx, "Legacy", `{}`); err != nil {
+		t.Fatalf("createProfile: %v", err)
+	}
+	legacy, _ := s.getProfileByName(ctx, "Legacy")
+	def, _ := s.getProfileByName(ctx, defaultProfileName)
+	post := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/profiles/archive", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		s.routes().ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := post(fmt.Sprintf("id=%d", def.ID)); code != http.StatusBadRequest {
+		t.Fatalf("archive default: %d", code)
+	}
+	if code := post(fmt.Sprintf("id=%d", legacy.ID)); code != http.StatusSeeOther {
+		t.Fatalf("archive: %d", code)
+	}
+
+	hasLegacy := func(profiles []Profile) bool {
+		for _, p := range profiles {
+			if p.Name == "Legacy" {
+				return true
+			}
+		}
+		return false
+	}
+	form, err := s.indexProfiles(ctx)
+	if err != nil || hasLegacy(form) {
+		t.Fatalf("run form profiles include archived profile (err %v)", err)
+	}
+	all, err := s.listProfiles(ctx, true)
+	if err != nil || !hasLegacy(all) {
+		t.Fatalf("full list misses archived profile (err %v)", err)
+	}
+	if body := get(t, s.routes(), "/").Body.String(); strings.Contains(body, ">Legacy<") {
+		t.Error("run form lists archived profile")
+	}
+	if body := get(t, s.routes(), "/profiles?archived=1").Body.String(); !strings.Contains(body, "Legacy (archived)") {
+		t.Error("profiles page does not show archived profile with the filter")
+	}
+}
+
+func TestProfileUpdateKeepsBlankFields(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	cfg := defaultConfig()
+	cfg.UserAgent = "csp-bot/1.0"
+	cfg.BasicAuthUser, cfg.BasicAuthPass = "staging", "hunter2"
+	raw, _ := json.Marshal(cfg)
+	if err := s.createProfile(ctx, "Staging", string(raw)); err != nil {
+		t.Fatalf("createProfile: %v", err)
+	}
+	p, _ := s.getProfileByName(ctx, "Staging")
+
+	body := fmt.Sprintf("id=%d&name=Staging&user_agent=&nav_timeout_ms=60000&basic_auth_user=staging", p.ID)
+	req := httptest.NewRequest(http.MethodPost, "/profiles/update", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("update: %d %s", rec.Code, rec.Body)
+	}
+	p, _ = s.getProfile(ctx, p.ID)
+	got, err := parseConfig(p.ConfigJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UserAgent != "csp-bot/1.0" || got.NavTimeoutMs != 60000 || got.BasicAuthPass != "hunter2" {
+		t.Fatalf("after update UA=%q nav=%d pass kept=%v", got.UserAgent, got.NavTimeoutMs, got.BasicAuthPass == "hunter2")
+	}
+}
+
+func TestFindDuplicateProfiles(t *testing.T) {
+	profiles := []Profile{
+		{ID: 1, Name: "Default", ConfigJSON: `{"waitUntil":"networkidle","navTimeoutMs":45000}`},
+		{ID: 2, Name: "Slow", ConfigJSON: `{"navTimeoutMs":90000}`},
+		{ID: 3, Name: "Copy", ConfigJSON: `{ "navTimeoutMs": 45000, "waitUntil": "networkidle" }`},
+		{ID: 4, Name: "Broken", ConfigJSON: `{`},
+		{ID: 5, Name: "Broken too", ConfigJSON: `{`},
+	}
+	if got := findDuplicateProfiles(profiles); fmt.Sprint(got) != "[[1 3]]" {
+		t.Fatalf("findDuplicateProfiles = %v, want [[1 3]]", got)
+	}
+
+	s := newTestServer(t)
+	if err := s.createProfile(context.Background(), "Copy", defaultConfigJSON()); err != nil {
+		t.Fatal(err)
+	}
+	if body := get(t, s.routes(), "/profiles").Body.String(); !strings.Contains(body, "Copy is a duplicate of Default.") {
+		t.Error("profiles page does not flag the duplicate")
+	}
+}
+
+func TestAdminExportAllZip(t *testing.T) {
+	s := newTestServer(t)
+	for i := 0; i < 3; i++ {
+		if i == 2 {
+			s.compressResults = true
+		}
+		seedRun(t, s, fmt.Sprintf("https://example.org/%d", i), MultiReport{GeneratedAt: "2024-01-01T00:00:00Z"})
+	}
+
+	rec := get(t, s.routes(), "/admin/export-all.zip")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("export: %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("read zip: %v", err)
+	}
+	if len(zr.File) != 4 {
+		t.Fatalf("entries = %d, want 3 runs + manifest", len(zr.File))
+	}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc map[string]any
+		if err := json.NewDecoder(rc).Decode(&doc); err != nil {
+			t.Errorf("%s is not JSON: %v", f.Name, err)
+		}
+		rc.Close()
+		if f.Name == "manifest.json" {
+			if runs, _ := doc["runs"].([]any); len(runs) != 3 {
+				t.Errorf("manifest lists %d runs", len(runs))
+			}
+		}
+	}
+}
+
+func TestAdminImportAllRoundTripsExport(t *testing.T) {
+	src := newTestServer(t)
+	multi := func(u string) MultiReport {
+		return MultiReport{GeneratedAt: "2024-01-01T00:00:00Z", Browsers: map[string]Report{
+			"chromium": {Totals: ReportTotals{Pages: 1, Violations: 1}, Results: []ReportPageResult{
+				{URL: u, OK: true, Violations: []Violation{{EffectiveDirective: "img-src", BlockedOrigin: "https://cdn.example"}}},
+			}},
+		}}
+	}
+	first := seedRun(t, src, "https://example.org/a", multi("https://example.org/a"))
+	seedRun(t, src, "https://example.org/b", multi("https://example.org/b"))
+	if err := src.setRunLabel(context.Background(), first, "before deploy"); err != nil {
+		t.Fatal(err)
+	}
+	exported := get(t, src.routes(), "/admin/export-all.zip").Body.Bytes()
+
+	// Re-pack the export with one invalid run entry added.
+	zr, err := zip.NewReader(bytes.NewReader(exported), int64(len(exported)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for _, f := range zr.File {
+		if err := zw.Copy(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bad, _ := zw.Create("run-999.json")
+	bad.Write([]byte(`{"browsers": {"chromium": {"results": [{"url": "not a url"}]}}}`))
+	zw.Close()
+
+	dst := newTestServer(t)
+	req := httptest.NewRequest(http.MethodPost, "/admin/import-all.zip", &archive)
+	req.Header.Set("Content-Type", "application/zip")
+	rec := httptest.NewRecorder()
+	dst.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("import: %d %s", rec.Code, rec.Body)
+	}
+	var res ImportResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Imported != 2 || res.Skipped != 1 {
+		t.Fatalf("imported %d, skipped %d: %v", res.Imported, res.Skipped, res.Errors)
+	}
+
+	orig, _ := src.getRun(context.Background(), first)
+	got, err := dst.getRun(context.Background(), res.RunIDs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Label != "before deploy" || got.URLsText != orig.URLsText || got.CreatedAt != orig.CreatedAt || got.ResultsJSON != orig.ResultsJSON {
+		t.Errorf("imported run = %+v, want copy of %+v", got, orig)
+	}
+	if jsonViolations(got.SummaryJSON) != 1 {
+		t.Errorf("imported summary = %s", got.SummaryJSON)
+	}
+}
+
+func TestGobExportRoundTripsThroughImport(t *testing.T) {
+	src := newTestServer(t)
+	f, zero, status := false, 0, 200
+	multi := MultiReport{
+		GeneratedAt: "2024-01-01T00:00:00Z",
+		Config:      map[string]any{"waitUntil": "load", "timeoutMs": 30000.0, "headers": map[string]any{"X-Test": "1"}, "browsers": []any{"chromium"}},
+		Browsers: map[string]Report{
+			"chromium": {Settled: &f, Totals: ReportTotals{Pages: 2, Violations: 1}, Results: []ReportPageResult{
+				{URL: "https://example.org/a", Status: &status, OK: true, Settled: &f, HasCSP: &f, Violations: []Violation{
+					{EffectiveDirective: "img-src", BlockedOrigin: "https://cdn.example", LineNumber: &zero, Disposition: "enforce"},
+				}},
+				{URL: "https://example.org/b", OK: true, Violations: []Violation{}},
+			}},
+		},
+		Flaky: []Violation{{EffectiveDirective: "script-src", StatusCode: &zero}},
+	}
+	id := seedRun(t, src, "https://example.org/a\nhttps://example.org/b", multi)
+
+	rec := get(t, src.routes(), fmt.Sprintf("/runs/export?id=%d&format=gob", id))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-gob" {
+		t.Fatalf("export: %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	dst := newTestServer(t)
+	req := httptest.NewRequest(http.MethodPost, "/admin/import-run.gob?label=copied", bytes.NewReader(rec.Body.Bytes()))
+	req.Header.Set("Content-Type", "application/x-gob")
+	imp := httptest.NewRecorder()
+	dst.routes().ServeHTTP(imp, req)
+	if imp.Code != http.StatusOK {
+		t.Fatalf("import: %d %s", imp.Code, imp.Body)
+	}
+	var res ImportResult
+	if err := json.Unmarshal(imp.Body.Bytes(), &res); err != nil || len(res.RunIDs) != 1 {
+		t.Fatalf("import result %s: %v", imp.Body, err)
+	}
+	orig, _ := src.getRun(context.Background(), id)
+	got, err := dst.getRun(context.Background(), res.RunIDs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ResultsJSON != orig.ResultsJSON {
+		t.Errorf("imported results = %s\nwant %s", got.ResultsJSON, orig.ResultsJSON)
+	}
+	if got.Label != "copied" || got.URLsText != orig.URLsText {
+		t.Errorf("imported run = %+v", got)
+	}
+
+	bad := httptest.NewRequest(http.MethodPost, "/admin/import-run.gob", strings.NewReader("{}"))
+	rec = httptest.NewRecorder()
+	dst.routes().ServeHTTP(rec, bad)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("non-gob body: %d", rec.Code)
+	}
+}
+
+// TestVisitOptionalFieldsCoversEveryPointer fails when a *bool or *int field
+// is added to the report types without being added to visitOptionalFields,
+// which would otherwise come back from a gob export as nil when zero.
+func TestVisitOptionalFieldsCoversEveryPointer(t *testing.T) {
+	boolPtr, intPtr := reflect.TypeOf((*bool)(nil)), reflect.TypeOf((*int)(nil))
+	// fill sets every optional pointer and gives every list and map of
+	// report types one entry, so the visitor has something to reach.
+	var fill func(v reflect.Value)
+	fill = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				if v.Type().Field(i).IsExported() {
+					fill(v.Field(i))
+				}
+			}
+		case reflect.Ptr:
+			if v.Type() == boolPtr || v.Type() == intPtr {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+		case reflect.Slice:
+			if v.Type().Elem().Kind() == reflect.Struct {
+				v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+				fill(v.Index(0))
+			}
+		case reflect.Map:
+			if elem := v.Type().Elem(); elem.Kind() == reflect.Struct && v.Type().Key().Kind() == reflect.String {
+				item := reflect.New(elem).Elem()
+				fill(item)
+				v.Set(reflect.MakeMap(v.Type()))
+				v.SetMapIndex(reflect.ValueOf("chromium"), item)
+			}
+		}
+	}
+	var multi MultiReport
+	fill(reflect.ValueOf(&multi).Elem())
+	visitOptionalFields(&multi, func(p **bool) { *p = nil }, func(p **int) { *p = nil })
+
+	var missed []string
+	var check func(v reflect.Value, path string)
+	check = func(v reflect.Value, path string) {
+		switch v.Kind() {
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				if f := v.Type().Field(i); f.IsExported() {
+					check(v.Field(i), path+"."+f.Name)
+				}
+			}
+		case reflect.Ptr:
+			if (v.Type() == boolPtr || v.Type() == intPtr) && !v.IsNil() {
+				missed = append(missed, path)
+			}
+		case reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				check(v.Index(i), path+"[]")
+			}
+		case reflect.Map:
+			iter := v.MapRange()
+			for iter.Next() {
+				check(iter.Value(), path+"[]")
+			}
+		}
+	}
+	check(reflect.ValueOf(multi), "MultiReport")
+	if len(missed) > 0 {
+		t.Errorf("visitOptionalFields skips %v", missed)
+	}
+}
+
+func TestGroupViolationsNormalizeSchemeMergesOrigins(t *testing.T) {
+	results := []ReportPageResult{
+		{URL: "https://example.org/a", Violations: []Violation{
+			{EffectiveDirective: "img-src", BlockedOrigin: "http://cdn.example.com"},
+			{EffectiveDirective: "img-src", BlockedOrigin: "https://cdn.example.com"},
+		}},
+		{URL: "https://example.org/b", Violations: []Violation{
+			{EffectiveDirective: "img-src", BlockedOrigin: "https://cdn.example.com"},
+			{EffectiveDirective: "script-src", BlockedOrigin: "inline"},
+		}},
+	}
+	if groups := groupViolations(results, false); len(groups) != 3 {
+		t.Fatalf("without the option got %d groups, want 3", len(groups))
+	}
+	groups := groupViolations(results, true)
+	if len(groups) != 2 {
+		t.Fatalf("got %d groups, want 2: %+v", len(groups), groups)
+	}
+	g := groups[0]
+	if g.Key != "img-src -> cdn.example.com" || g.BlockedOrigin != "cdn.example.com" || g.Count != 3 || len(g.Pages) != 2 {
+		t.Errorf("merged group = %+v", g)
+	}
+	if g.SchemeMix() != "1 http, 2 https" {
+		t.Errorf("scheme mix = %q", g.SchemeMix())
+	}
+	if groups[1].BlockedOrigin != "inline" || groups[1].SchemeMix() != "" {
+		t.Errorf("schemeless group = %+v", groups[1])
+	}
+
+	s := newTestServer(t)
+	id := seedRun(t, s, "https://example.org/a\nhttps://example.org/b", MultiReport{Browsers: map[string]Report{"chromium": {Results: results}}})
+	rec := get(t, s.routes(), fmt.Sprintf("/runs/%d?normalizeScheme=1", id))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "1 http, 2 https") {
+		t.Errorf("run page: %d, want the scheme mix shown", rec.Code)
+	}
+}
+
+func TestExpectedViolationsDoNotFailRun(t *testing.T) {
+	s := newTestServer(t)
+	var perPage map[string][]Violation
+	s.checker = CheckerFunc(func(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
+		var results []ReportPageResult
+		for _, u := range urls {
+			results = append(results, ReportPageResult{URL: u, OK: true, Violations: perPage[u]})
+		}
+		multi := MultiReport{Browsers: map[string]Report{"chromium": {Results: results}}}
+		return multi, computeExitCode(multi, 0, cfg), nil
+	})
+	img := Violation{EffectiveDirective: "img-src", BlockedOrigin: "https://cdn.example", Disposition: "enforce"}
+	script := Violation{EffectiveDirective: "script-src", BlockedOrigin: "inline", Disposition: "enforce"}
+	list := "https://example.org/a # tag:home expect:IMG-SRC\nhttps://example.org/b # expect:font-src"
+	if got := parseTaggedURLList(list); len(got) != 2 || len(got[0].Expect) != 1 || got[0].Expect[0] != "img-src" || got[0].Tags[0] != "home" {
+		t.Fatalf("parsed %+v", got)
+	}
+
+	ctx := context.Background()
+	perPage = map[string][]Violation{"https://example.org/a": {img}}
+	id, err := s.executeRun(ctx, sql.NullInt64{}, list, defaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run, _ := s.getRun(ctx, id); run.ExitCode != 0 {
+		t.Errorf("expected violation only: exit code %d, want 0", run.ExitCode)
+	}
+
+	perPage = map[string][]Violation{"https://example.org/a": {img, script}, "https://example.org/b": {img}}
+	id, err = s.executeRun(ctx, sql.NullInt64{}, list, defaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, _ := s.getRun(ctx, id)
+	if run.ExitCode != 1 {
+		t.Errorf("unexpected violations: exit code %d, want 1", run.ExitCode)
+	}
+	multi, _ := loadMultiReport(run)
+	got := pageExpectations(run.URLsText, multi)
+	if len(got) != 2 || strings.Join(got[0].Seen, ",") != "img-src" || got[0].Unexpected != 1 || strings.Join(got[1].Missing, ",") != "font-src" || got[1].Unexpected != 1 {
+		t.Errorf("expectations = %+v", got)
+	}
+}
+
+func TestRunDetailOffersSameProfileRunsToCompare(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	multi := func(origin string) MultiReport {
+		return MultiReport{Browsers: map[string]Report{"chromium": {Results: []ReportPageResult{
+			{URL: "https://example.org/", OK: true, Violations: []Violation{{EffectiveDirective: "img-src", BlockedOrigin: origin, Disposition: "enforce"}}},
+		}}}}
+	}
+	older := seedRun(t, s, "https://example.org/", multi("https://old.example"))
+	if err := s.setRunLabel(ctx, older, "before deploy"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.createProfile(ctx, "Other", `{}`); err != nil {
+		t.Fatal(err)
+	}
+	other, _ := s.getProfileByName(ctx, "Other")
+	foreign, err := s.createRun(ctx, sql.NullInt64{Int64: other.ID, Valid: true}, "https://example.org/", "{}", "{}", 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := seedRun(t, s, "https://example.org/", multi("https://new.example"))
+	run, _ := s.getRun(ctx, current)
+	if _, err := s.createFailedRun(ctx, run.ProfileID, "https://example.org/", errors.New("node crashed"), 1); err != nil {
+		t.Fatal(err)
+	}
+	// Newer runs of another profile must not crowd out this profile's.
+	for i := 0; i < 101; i++ {
+		if _, err := s.insertRun(ctx, Run{ProfileID: sql.NullInt64{Int64: other.ID, Valid: true}, CreatedAt: "2999-01-01T00:00:00Z", URLsText: "https://example.org/", SummaryJSON: "{}", ResultsJSON: "{}"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := s.compareCandidates(ctx, run); len(got) != 1 || got[0].ID != older {
+		t.Fatalf("candidates = %+v, want only run %d", got, older)
+	}
+	body := get(t, s.routes(), fmt.Sprintf("/runs/%d", current)).Body.String()
+	if !strings.Contains(body, fmt.Sprintf(`<option value="%d">#%d`, older, older)) || !strings.Contains(body, "before deploy") {
+		t.Errorf("run page lacks the same-profile run %d", older)
+	}
+	if strings.Contains(body, fmt.Sprintf(`<option value="%d">#%d`, foreign, foreign)) {
+		t.Errorf("run page offers run %d of another profile", foreign)
+	}
+
+	rec := get(t, s.routes(), fmt.Sprintf("/runs/diff?base=%d&other=%d", older, current))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "img-src -&gt; https://new.example") || !strings.Contains(rec.Body.String(), "img-src -&gt; https://old.example") {
+		t.Errorf("diff page: %d %s", rec.Code, rec.Body)
+	}
+	if rec := get(t, s.routes(), fmt.Sprintf("/runs/diff?base=x&other=%d", current)); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid base: %d", rec.Code)
+	}
+}
+
+func TestRunsPerProfileRotatesOldestUnpinnedRun(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	def, _ := s.getProfileByName(ctx, defaultProfileName)
+	profileID := sql.NullInt64{Int64: def.ID, Valid: true}
+	s.runsPerProfile = 2
+	s.audit = true
+
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		id, err := s.createRun(ctx, profileID, "https://example.org/", "{}", "{}", 0, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	unrelated, err := s.createRun(ctx, sql.NullInt64{}, "https://example.org/", "{}", "{}", 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.getRun(ctx, ids[0]); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("oldest run still stored (err %v)", err)
+	}
+	var audited string
+	if err := s.db.QueryRow(`SELECT target_id FROM audit_log WHERE action = 'run.deleted'`).Scan(&audited); err != nil || audited != fmt.Sprint(ids[0]) {
+		t.Errorf("rotation audit = %q, %v; want run %d", audited, err, ids[0])
+	}
+
+	// Pinned and baseline runs outlive the limit.
+	if err := s.setRunPinned(ctx, ids[1], true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setBaseline(ctx, def.ID, ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		id, err := s.createRun(ctx, profileID, "https://example.org/", "{}", "{}", 0, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	for _, id := range []int64{ids[1], ids[2], ids[3], ids[4], unrelated} {
+		if _, err := s.getRun(ctx, id); err != nil {
+			t.Errorf("run %d removed: %v", id, err)
+		}
+	}
+	if runs, _ := s.listRunsForProfile(ctx, def.ID, 10); len(runs) != 4 {
+		t.Errorf("profile keeps %d runs, want 2 newest plus pinned and baseline", len(runs))
+	}
+	if run, _ := s.getRun(ctx, ids[1]); !run.Pinned {
+		t.Errorf("run %d not pinned", ids[1])
+	}
+}
+
+func TestViolationTreeCountsSumToTotal(t *testing.T) {
+	v := func(directive, origin, file string) Violation {
+		return Violation{EffectiveDirective: directive, BlockedOrigin: origin, SourceFile: file}
+	}
+	multi := MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{
+			{URL: "https://example.org/a", Violations: []Violation{
+				v("script-src", "https://cdn.example", "https://example.org/app.js"),
+				v("script-src", "https://cdn.example", "https://example.org/app.js"),
+				v("script-src", "inline", ""),
+			}},
+			{URL: "https://example.org/b", Violations: []Violation{v("img-src", "https://img.example", "")}},
+		}},
+		"firefox": {Results: []ReportPageResult{
+			{URL: "https://example.org/a", Violations: []Violation{v("script-src", "https://cdn.example", "https://example.org/vendor.js")}},
+		}},
+	}}
+	s := newTestServer(t)
+	id := seedRun(t, s, "https://example.org/a\nhttps://example.org/b", multi)
+	rec := get(t, s.routes(), fmt.Sprintf("/api/runs/%d/tree", id))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("tree: %d %s", rec.Code, rec.Body)
+	}
+	var root TreeNode
+	if err := json.Unmarshal(rec.Body.Bytes(), &root); err != nil {
+		t.Fatal(err)
+	}
+	if root.Value != 5 {
+		t.Fatalf("root value = %d, want 5", root.Value)
+	}
+	var check func(n TreeNode, depth int)
+	check = func(n TreeNode, depth int) {
+		if len(n.Children) == 0 {
+			if depth != 3 {
+				t.Errorf("leaf %q at depth %d, want 3", n.Name, depth)
+			}
+			return
+		}
+		sum := 0
+		for _, c := range n.Children {
+			sum += c.Value
+			check(c, depth+1)
+		}
+		if sum != n.Value {
+			t.Errorf("%q: children sum to %d, want %d", n.Name, sum, n.Value)
+		}
+	}
+	check(root, 0)
+	script := root.Children[0]
+	if script.Name != "script-src" || script.Value != 4 || script.Children[0].Name != "https://cdn.example" || script.Children[0].Value != 3 {
+		t.Errorf("script-src branch = %+v", script)
+	}
+	if inline := script.Children[1]; inline.Name != "inline" || inline.Children[0].Name != unknownSourceFile {
+		t.Errorf("inline branch = %+v", inline)
+	}
+}
+
+func TestDataAndBlobURIsGroupUnderSyntheticOrigins(t *testing.T) {
+	results := []ReportPageResult{{URL: "https://example.org/", Violations: []Violation{
+		{EffectiveDirective: "img-src", BlockedURI: "data"},
+		{EffectiveDirective: "img-src", BlockedURI: "data:image/png;base64,iVBORw0KGgo=", BlockedOrigin: "null"},
+		{EffectiveDirective: "worker-src", BlockedURI: "blob:https://example.org/0b8e", BlockedOrigin: "https://example.org"},
+		{EffectiveDirective: "img-src", BlockedURI: "https://cdn.example/a.png", BlockedOrigin: "https://cdn.example"},
+	}}}
+	groups := groupViolations(results, false)
+	byKey := map[string]GroupedViolation{}
+	for _, g := range groups {
+		byKey[g.Key] = g
+	}
+	if g, ok := byKey["img-src -> (data: URI)"]; !ok || g.Count != 2 || g.BlockedOrigin != "(data: URI)" {
+		t.Errorf("data: group = %+v, groups %+v", g, groups)
+	}
+	if _, ok := byKey["worker-src -> (blob: URI)"]; !ok {
+		t.Errorf("no blob: group in %+v", groups)
+	}
+	if _, ok := byKey["img-src -> https://cdn.example"]; !ok || len(groups) != 3 {
+		t.Errorf("groups = %+v, want the real origin kept and 3 groups", groups)
+	}
+	if _, ok := byKey["img-src -> "]; ok {
+		t.Error("data: violations grouped under an empty origin")
+	}
+	multi := MultiReport{Browsers: map[string]Report{"chromium": {Results: results}}}
+	if got := violationsByOrigin(multi, "(data: URI)"); len(got) != 1 || got[0].Count != 2 {
+		t.Errorf("by-origin (data: URI) = %+v, want the data: group", got)
+	}
+}
+
+func TestMigrateSyntheticOriginKeys(t *testing.T) {
+	s := newTestServer(t)
+	def, _ := s.getProfileByName(context.Background(), defaultProfileName)
+	for _, row := range [][]any{
+		{"img-src -> data", "2024-01-02T00:00:00Z", "2024-01-05T00:00:00Z", 5, "2024-01-06T00:00:00Z"},
+		{"img-src -> data:image/png;base64,iVBORw0KGgo=", "2024-01-01T00:00:00Z", "2024-01-03T00:00:00Z", 3, nil},
+		{"worker-src -> blob:https://example.org/0b8e", "2024-01-01T00:00:00Z", "2024-01-01T00:00:00Z", 1, nil},
+		{"img-src -> https://data.example", "2024-01-01T00:00:00Z", "2024-01-01T00:00:00Z", 1, nil},
+	} {
+		if _, err := s.db.Exec(`INSERT INTO violation_tracking (profile_id, group_key, first_seen, last_seen, last_run_id, resolved_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			append([]any{def.ID}, row...)...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := migrateSyntheticOriginKeys(s.db); err != nil {
+		t.Fatal(err)
+	}
+	tracks, err := s.violationTracks(context.Background(), def.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byKey := map[string]ViolationTrack{}
+	for _, tr := range tracks {
+		byKey[tr.Key] = tr
+	}
+	if len(tracks) != 3 {
+		t.Fatalf("tracks = %+v, want data: rows merged", tracks)
+	}
+	if tr := byKey["img-src -> (data: URI)"]; tr.FirstSeen != "2024-01-01T00:00:00Z" || tr.LastSeen != "2024-01-05T00:00:00Z" || tr.ResolvedAt != "" {
+		t.Errorf("merged data: track = %+v", tr)
+	}
+	if _, ok := byKey["worker-src -> (blob: URI)"]; !ok {
+		t.Errorf("blob: key not renamed: %+v", tracks)
+	}
+	if _, ok := byKey["img-src -> https://data.example"]; !ok {
+		t.Errorf("real origin renamed: %+v", tracks)
+	}
+}
+
+func TestDirectiveMeta(t *testing.T) {
+	for _, d := range []string{"script-src", "Script-Src-Elem", "img-src", "frame-ancestors"} {
+		m := directiveMeta(d)
+		if m.Category == "" || m.Category == "other" || m.Severity == "" || m.Icon == "" || m.Description == "" {
+			t.Errorf("directiveMeta(%q) = %+v, want populated", d, m)
+		}
+	}
+	if m := directiveMeta("script-src"); m.Category != "script" || m.Severity != "high" {
+		t.Errorf("script-src = %+v", m)
+	}
+	if m := directiveMeta("made-up-src"); m.Category != "other" || m.Icon == "" || m.Description == "" {
+		t.Errorf("unknown directive = %+v, want the default", m)
+	}
+	groups := groupViolations([]ReportPageResult{{URL: "https://example.org/", Violations: []Violation{{EffectiveDirective: "style-src-attr"}}}}, false)
+	if len(groups) != 1 || groups[0].Meta.Category != "style" {
+		t.Errorf("group meta = %+v", groups)
+	}
+}
+
+func TestPausedSchedulerDoesNotTrigger(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	runs := 0
+	s.checker = CheckerFunc(func(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
+		runs++
+		return MultiReport{Browsers: map[string]Report{"chromium": {}}}, 0, nil
+	})
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	if _, err := s.createSchedule(ctx, sql.NullInt64{}, "https://example.org/", 60, start); err != nil {
+		t.Fatal(err)
+	}
+	sched := newScheduler(s, func() time.Time { return start.Add(2 * time.Hour) })
+
+	post := func(path string) {
+		rec := httptest.NewRecorder()
+		s.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: %d", path, rec.Code)
+		}
+	}
+	post("/admin/scheduler/pause")
+	sched.runDue(ctx)
+	if runs != 0 {
+		t.Fatalf("paused scheduler started %d run(s)", runs)
+	}
+	var snap StatusSnapshot
+	json.Unmarshal(get(t, s.routes(), "/api/status").Body.Bytes(), &snap)
+	if !snap.SchedulerPaused {
+		t.Error("status does not report the pause")
+	}
+
+	post("/admin/scheduler/resume")
+	sched.runDue(ctx)
+	if runs != 1 {
+		t.Fatalf("resumed scheduler ran %d time(s), want 1", runs)
+	}
+}
+
+func TestPolicyWeaknesses(t *testing.T) {
+	got := policyWeaknesses("default-src 'self'; script-src 'self' 'unsafe-inline' 'unsafe-eval'; img-src *")
+	want := "[script-src allows 'unsafe-inline' script-src allows 'unsafe-eval' img-src allows any origin (*)]"
+	if fmt.Sprint(got) != want {
+		t.Fatalf("policyWeaknesses = %v", got)
+	}
+	if got := policyWeaknesses("script-src 'nonce-abc' 'unsafe-inline'"); len(got) != 0 {
+		t.Errorf("'unsafe-inline' with a nonce reported: %v", got)
+	}
+	if got := policyWeaknesses("default-src 'self'; script-src-elem https://cdn.example"); len(got) != 0 {
+		t.Errorf("strict policy reported: %v", got)
+	}
+
+	page := ReportPageResult{URL: "https://example.org/", Violations: []Violation{
+		{OriginalPolicy: "script-src 'unsafe-inline'"},
+		{OriginalPolicy: "script-src 'unsafe-inline'"},
+	}}
+	if got := pageWeaknesses(page); fmt.Sprint(got) != "[script-src allows 'unsafe-inline']" {
+		t.Errorf("pageWeaknesses = %v", got)
+	}
+}
+
+func TestDeviceProfilesTagMobileOnlyViolation(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "fake-check.sh")
+	body := `if [ "$CSP_DEVICE" = mobile ]; then
+  printf '{"totals":{"pages":1,"violations":1},"results":[{"url":"https://example.org/","ok":true,"violations":[{"effectiveDirective":"img-src","blockedOrigin":"https://m.cdn.example","disposition":"enforce"}]}]}' > "$CSP_OUTPUT_FILE"
+else
+  printf '{"totals":{"pages":1,"violations":0},"results":[{"url":"https://example.org/","ok":true,"violations":[]}]}' > "$CSP_OUTPUT_FILE"
+fi
+`
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CSP_NODE_BIN", "/bin/sh")
+	t.Setenv("CSP_SCRIPT_PATH", script)
+
+	cfg := defaultConfig()
+	cfg.Devices = []string{"desktop", "mobile"}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	multi, _, err := runCSPCheck(context.Background(), []string{"https://example.org/"}, cfg, 1)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if n := len(multi.Browsers["chromium"].Results); n != 2 {
+		t.Fatalf("chromium results = %d, want one per device", n)
+	}
+	groups := groupViolationsMulti(buildBrowserReports(multi), false)
+	if len(groups) != 1 || fmt.Sprint(groups[0].Group.Devices) != "[mobile]" {
+		t.Fatalf("groups = %+v, want one tagged mobile", groups)
+	}
+
+	cfg.Devices = []string{"watch"}
+	if err := validateConfig(cfg); err == nil {
+		t.Error("unknown device accepted")
+	}
+}
+
+func TestBuildReproScript(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.NavTimeoutMs = 60000
+	cfg.BasicAuthUser, cfg.BasicAuthPass = "staging", "hunter2"
+	cfg.ExtraArgs = []string{"--retries=2"}
+	run := Run{ID: 7, CreatedAt: "2024-01-01T00:00:00Z", URLsText: "# home\nhttps://example.org/\nhttps://example.org/it's"}
+	script := buildReproScript(run, cfg)
+	for _, want := range []string{
+		"cat > urls.txt <<'CSP_URLS'\nhttps://example.org/\nhttps://example.org/it's\nCSP_URLS\n",
+		"export CSP_NAV_TIMEOUT_MS='60000'\n",
+		"export CSP_WAIT_UNTIL='networkidle'\n",
+		"export CSP_BASIC_AUTH_USER='staging'\n",
+		"export CSP_BASIC_AUTH_PASS='<basic auth password>'\n",
+		"for browser in chromium firefox webkit; do",
+		"node csp-check.mjs urls.txt '--retries=2'",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script missing %q:\n%s", want, script)
+		}
+	}
+	if strings.Contains(script, "hunter2") || strings.Contains(script, "# home") {
+		t.Errorf("script leaks the password or keeps comments:\n%s", script)
+	}
+
+	multi := MultiReport{Config: map[string]any{"navTimeoutMs": 90000.0, "preActions": 2}}
+	if got := recordedConfig(multi, cfg); got.NavTimeoutMs != 90000 || got.UserAgent != cfg.UserAgent {
+		t.Errorf("recordedConfig = %+v", got)
+	}
+}
+
+func TestTimeoutMultiplierScalesSubprocessTimeouts(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "fake-check.sh")
+	body := `printf '{"config":{"nav":"%s","settle":"%s"},"totals":{"pages":0,"violations":0},"results":[]}' "$CSP_NAV_TIMEOUT_MS" "$CSP_WAIT_MS" > "$CSP_OUTPUT_FILE"
+`
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CSP_NODE_BIN", "/bin/sh")
+	t.Setenv("CSP_SCRIPT_PATH", script)
+
+	cfg, err := parseConfig(`{"navTimeoutMs": 30000, "settleWaitMs": 1500, "timeoutMultiplier": 2}`)
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	multi, _, err := runCSPCheck(context.Background(), []string{"https://example.org/"}, cfg, 1)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	got := multi.Browsers["chromium"].Config
+	if got["nav"] != "60000" || got["settle"] != "3000" {
+		t.Errorf("subprocess saw nav=%v settle=%v, want 60000 and 3000", got["nav"], got["settle"])
+	}
+	if cfg.NavTimeoutMs != 30000 {
+		t.Errorf("base NavTimeoutMs changed to %d", cfg.NavTimeoutMs)
+	}
+	for _, bad := range []string{`{"timeoutMultiplier": 0.2}`, `{"timeoutMultiplier": 20}`} {
+		if _, err := parseConfig(bad); err == nil {
+			t.Errorf("parseConfig(%s) accepted an out-of-range multiplier", bad)
+		}
+	}
+}
+
+func TestRunDetailContentNegotiation(t *testing.T) {
+	s := newTestServer(t)
+	id := seedRun(t, s, "https://example.org/", MultiReport{Browsers: map[string]Report{"chromium": {Results: []ReportPageResult{{URL: "https://example.org/", Violations: []Violation{}}}}}})
+	fetch := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/runs/%d", id), nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		s.routes().ServeHTTP(rec, req)
+		return rec
+	}
+	rec := fetch("application/json")
+	var multi MultiReport
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Fatalf("content type = %q", ct)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &multi); err != nil || multi.Browsers["chromium"].Results[0].URL != "https://example.org/" {
+		t.Fatalf("body is not the run's report: %v", err)
+	}
+	for _, accept := range []string{"", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "application/json;q=0.5, text/html"} {
+		if ct := fetch(accept).Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			t.Errorf("Accept %q: content type = %q, want HTML", accept, ct)
+		}
+	}
+	if !prefersJSON("text/html;q=0.5, application/json") || prefersJSON("*/*") {
+		t.Error("prefersJSON ignores q-values")
+	}
+}
+
+func TestAPIRunByOrigin(t *testing.T) {
+	s := newTestServer(t)
+	id := seedRun(t, s, "https://example.org/", MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{{URL: "https://example.org/", Violations: []Violation{
+			{EffectiveDirective: "script-src", BlockedOrigin: "https://cdn.example.com", Disposition: "enforce"},
+			{EffectiveDirective: "img-src", BlockedOrigin: "https://ads.example.net", Disposition: "enforce"},
+			{EffectiveDirective: "img-src", BlockedOrigin: "https://notcdn.example.com", Disposition: "enforce"},
+		}}}},
+		"firefox": {Results: []ReportPageResult{{URL: "https://example.org/", Violations: []Violation{
+			{EffectiveDirective: "font-src", BlockedOrigin: "https://eu.cdn.example.com", Disposition: "report"},
+		}}}},
+	}})
+	rec := get(t, s.routes(), fmt.Sprintf("/api/runs/%d/by-origin?origin=cdn.example.com", id))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	var got OriginViolations
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, g := range got.Groups {
+		keys = append(keys, g.Disposition+" "+g.Key+" "+strings.Join(g.Browsers, ","))
+	}
+	want := "[enforce script-src -> https://cdn.example.com chromium report-only font-src -> https://eu.cdn.example.com firefox]"
+	if fmt.Sprint(keys) != want {
+		t.Errorf("groups = %v, want %s", keys, want)
+	}
+	if rec := get(t, s.routes(), fmt.Sprintf("/api/runs/%d/by-origin", id)); rec.Code != http.StatusBadRequest {
+		t.Errorf("missing origin status = %d", rec.Code)
+	}
+}
+
+func TestOversizedResultsSpillToFile(t *testing.T) {
+	s := newTestServer(t)
+	s.maxResultsBytes = 512
+	s.spillDir = filepath.Join(t.TempDir(), "spill")
+	var results []ReportPageResult
+	for i := 0; i < 200; i++ {
+		results = append(results, ReportPageResult{URL: fmt.Sprintf("https://example.org/page/%d/%s", i, violationSignature(Violation{BlockedURI: fmt.Sprint(i)})), Violations: []Violation{}})
+	}
+	id := seedRun(t, s, "https://example.org/", MultiReport{Browsers: map[string]Report{"chromium": {Results: results}}})
+
+	var column []byte
+	var path string
+	if err := s.db.QueryRow(`SELECT results_json, results_path FROM runs WHERE id = ?`, id).Scan(&column, &path); err != nil {
+		t.Fatal(err)
+	}
+	if len(column) != 0 || path == "" || filepath.IsAbs(path) {
+		t.Fatalf("results_json = %d bytes, results_path = %q; want spilled under a relative name", len(column), path)
+	}
+	if _, err := os.Stat(filepath.Join(s.spillDir, path)); err != nil {
+		t.Fatalf("spill file: %v", err)
+	}
+	run, err := s.getRun(context.Background(), id)
+	if err != nil {
+		t.Fatalf("getRun: %v", err)
+	}
+	multi, err := loadMultiReport(run)
+	if err != nil || len(multi.Browsers["chromium"].Results) != 200 || multi.Browsers["chromium"].Results[199].URL != results[199].URL {
+		t.Fatalf("results did not round-trip: %v", err)
+	}
+
+	// Runs spilled with an absolute path still load.
+	abs := filepath.Join(s.spillDir, path)
+	if _, err := s.db.Exec(`UPDATE runs SET results_path = ? WHERE id = ?`, abs, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.getRun(context.Background(), id); err != nil {
+		t.Fatalf("getRun with absolute results_path: %v", err)
+	}
+
+	// Listing runs does not read spill files, so a lost one fails only its
+	// own run.
+	if err := os.Remove(abs); err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range []string{"/runs", "/api/runs"} {
+		if rec := get(t, s.routes(), target); rec.Code != http.StatusOK {
+			t.Errorf("%s with a missing spill file: %d", target, rec.Code)
+		}
+	}
+	if _, err := s.getRun(context.Background(), id); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("getRun with a missing spill file: %v", err)
+	}
+
+	small := seedRun(t, s, "https://example.org/", MultiReport{Browsers: map[string]Report{"chromium": {}}})
+	if err := s.db.QueryRow(`SELECT results_path FROM runs WHERE id = ?`, small).Scan(&path); err != nil || path != "" {
+		t.Errorf("small run results_path = %q, err %v", path, err)
+	}
+}
+
+func TestParseTaggedURLList(t *testing.T) {
+	got := parseTaggedURLList("# staging pages\nhttps://example.org/login # tag:auth\nhttps://example.org/about # checked by hand\nhttps://example.org/admin # tag:auth tag:admin")
+	if len(got) != 3 {
+		t.Fatalf("entries = %+v", got)
+	}
+	if got[0].URL != "https://example.org/login" || fmt.Sprint(got[0].Tags) != "[auth]" {
+		t.Errorf("tagged URL = %+v", got[0])
+	}
+	if got[1].URL != "https://example.org/about" || len(got[1].Tags) != 0 {
+		t.Errorf("plain comment kept: %+v", got[1])
+	}
+	if fmt.Sprint(got[2].Tags) != "[auth admin]" {
+		t.Errorf("tags = %v", got[2].Tags)
+	}
+	if urls := parseURLList("https://example.org/login # tag:auth"); fmt.Sprint(urls) != "[https://example.org/login]" {
+		t.Errorf("parseURLList = %v", urls)
+	}
+
+	multi := MultiReport{Browsers: map[string]Report{"chromium": {Results: []ReportPageResult{
+		{URL: "https://example.org/login", Violations: []Violation{{}}},
+		{URL: "https://example.org/admin", Violations: []Violation{{}, {}}},
+	}}}}
+	groups := groupPagesByTag("https://example.org/login # tag:auth\nhttps://example.org/admin # tag:auth tag:admin", multi)
+	if len(groups) != 2 || groups[1].Tag != "auth" || len(groups[1].Pages) != 2 || groups[1].Violations != 3 {
+		t.Errorf("groups = %+v", groups)
+	}
+}
+
+func TestGroupBySourceFile(t *testing.T) {
+	line, col := 12, 5
+	results := []ReportPageResult{
+		{URL: "https://example.org/", Violations: []Violation{
+			{SourceFile: "https://example.org/app.js", LineNumber: &line, ColumnNumber: &col},
+			{SourceFile: "https://example.org/app.js", LineNumber: &line, ColumnNumber: &col},
+			{SourceFile: "https://example.org/vendor.js"},
+			{},
+		}},
+		{URL: "https://example.org/about", Violations: []Violation{
+			{SourceFile: "https://example.org/app.js"},
+		}},
+	}
+	groups := groupBySourceFile(results)
+	if len(groups) != 3 {
+		t.Fatalf("groups = %+v", groups)
+	}
+	app := groups[0]
+	if app.SourceFile != "https://example.org/app.js" || app.Count != 3 || len(app.Pages) != 2 || fmt.Sprint(app.Locations) != "[12:5]" {
+		t.Errorf("app.js group = %+v", app)
+	}
+	if groups[1].SourceFile != "https://example.org/vendor.js" || groups[1].Count != 1 || len(groups[1].Locations) != 0 {
+		t.Errorf("vendor.js group = %+v", groups[1])
+	}
+	if groups[2].SourceFile != unknownSourceFile || groups[2].Count != 1 {
+		t.Errorf("inline group = %+v", groups[2])
+	}
+
+	s := newTestServer(t)
+	blamed := seedRun(t, s, "https://example.org/", MultiReport{Browsers: map[string]Report{"chromium": {Results: results}}})
+	clean := seedRun(t, s, "https://example.org/", MultiReport{Browsers: map[string]Report{"chromium": {Results: []ReportPageResult{{URL: "https://example.org/"}}}}})
+	if body := get(t, s.routes(), fmt.Sprintf("/runs/%d", blamed)).Body.String(); !strings.Contains(body, "Blame by Source File") {
+		t.Error("run with violations has no blame card")
+	}
+	if body := get(t, s.routes(), fmt.Sprintf("/runs/%d", clean)).Body.String(); strings.Contains(body, "Blame by Source File") {
+		t.Error("run without violations shows an empty blame card")
+	}
+}
+
+func TestPromotionRiskListsOnlyReportOnlyGroups(t *testing.T) {
+	multi := MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{{URL: "https://example.org/", Violations: []Violation{
+			{EffectiveDirective: "script-src", BlockedOrigin: "https://cdn.example", Disposition: "enforce"},
+			{EffectiveDirective: "img-src", BlockedOrigin: "https://ads.example", Disposition: "report"},
+		}}}},
+		"firefox": {Results: []ReportPageResult{{URL: "https://example.org/", Violations: []Violation{
+			{EffectiveDirective: "font-src", BlockedOrigin: "https://fonts.example", Disposition: "report"},
+		}}}},
+	}}
+	var got []string
+	for _, g := range promotionRisk(buildBrowserReports(multi)) {
+		if g.Enforce != 0 {
+			t.Errorf("group %s has enforced violations", g.Key)
+		}
+		got = append(got, g.EffectiveDirective)
+	}
+	if len(got) != 2 || !containsString(got, "img-src") || !containsString(got, "font-src") {
+		t.Errorf("promotion risk = %v, want report-only groups only", got)
+	}
+
+	s := newTestServer(t)
+	id := seedRun(t, s, "https://example.org/", multi)
+	body := get(t, s.routes(), fmt.Sprintf("/runs/%d?browser=firefox", id)).Body.String()
+	card := body[strings.Index(body, "Before You Enforce"):]
+	card = card[:strings.Index(card, "</table>")]
+	if !strings.Contains(card, "fonts.example") || strings.Contains(card, "ads.example") {
+		t.Error("promotion risk ignores the browser filter")
+	}
+}
+
+func TestRunExportSortsResults(t *testing.T) {
+	s := newTestServer(t)
+	v := Violation{EffectiveDirective: "img-src"}
+	id := seedRun(t, s, "https://example.org/a\nhttps://example.org/b\nhttps://example.org/c", MultiReport{Browsers: map[string]Report{"chromium": {Results: []ReportPageResult{
+		{URL: "https://example.org/c", Violations: []Violation{v}},
+		{URL: "https://example.org/a", Violations: []Violation{}},
+		{URL: "https://example.org/b", Violations: []Violation{v, v, v}},
+	}}}})
+	order := func(query string) string {
+		var multi MultiReport
+		body := get(t, s.routes(), fmt.Sprintf("/runs/export?id=%d%s", id, query)).Body.Bytes()
+		if err := json.Unmarshal(body, &multi); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		var urls []string
+		for _, r := range multi.Browsers["chromium"].Results {
+			urls = append(urls, strings.TrimPrefix(r.URL, "https://example.org/"))
+		}
+		return strings.Join(urls, ",")
+	}
+	for query, want := range map[string]string{"": "c,a,b", "&sort=url": "a,b,c", "&sort=violations": "b,c,a"} {
+		if got := order(query); got != want {
+			t.Errorf("export%s order = %s, want %s", query, got, want)
+		}
+	}
+}
+
+func TestRunDetailBrowserFilter(t *testing.T) {
+	s := newTestServer(t)
+	page := func(directive string) Report {
+		return Report{Results: []ReportPageResult{{URL: "https://example.org/", Violations: []Violation{{EffectiveDirective: directive, BlockedOrigin: "https://cdn.example", Disposition: "enforce"}}}}}
+	}
+	id := seedRun(t, s, "https://example.org/", MultiReport{Browsers: map[string]Report{
+		"chromium": page("connect-src"),
+		"firefox":  page("img-src"),
+	}})
+	h := s.routes()
+	body := get(t, h, fmt.Sprintf("/runs/%d", id)).Body.String()
+	if !strings.Contains(body, `<h3 class="browser-title">chromium</h3>`) || !strings.Contains(body, `<h3 class="browser-title">firefox</h3>`) {
+		t.Fatal("default view does not show both browsers")
+	}
+	body = get(t, h, fmt.Sprintf("/runs/%d?browser=firefox", id)).Body.String()
+	if strings.Contains(body, `<h3 class="browser-title">chromium</h3>`) || strings.Contains(body, "connect-src → https://cdn.example") {
+		t.Error("browser=firefox still renders chromium results")
+	}
+	if !strings.Contains(body, `<h3 class="browser-title">firefox</h3>`) || !strings.Contains(body, "img-src") {
+		t.Error("browser=firefox does not render firefox results")
+	}
+	if rec := get(t, h, fmt.Sprintf("/runs/%d?browser=webkit", id)); rec.Code != http.StatusNotFound {
+		t.Errorf("browser=webkit status = %d", rec.Code)
+	}
+}
+
+func TestCheckErrorRecordsFailedRun(t *testing.T) {
+	s := newTestServer(t)
+	s.checker = CheckerFunc(func(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
+		return MultiReport{}, 1, errors.New("node: executable file not found")
+	})
+	form := "urls=https%3A%2F%2Fexample.org%2F"
+	req := httptest.NewRequest(http.MethodPost, "/runs", strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "recorded as run #") {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	runs, err := s.listRuns(context.Background(), "")
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("runs = %d, err %v", len(runs), err)
+	}
+	run := runs[0]
+	if run.ExitCode != failedRunExitCode || !strings.Contains(runError(run), "executable file not found") {
+		t.Errorf("run = exit %d, error %q", run.ExitCode, runError(run))
+	}
+	body := get(t, s.routes(), "/runs").Body.String()
+	if !strings.Contains(body, "Recent Errors") || !strings.Contains(body, ">failed</span>") {
+		t.Error("runs page does not show the failed run")
+	}
+	body = get(t, s.routes(), fmt.Sprintf("/runs/%d", run.ID)).Body.String()
+	if !strings.Contains(body, "Run failed before any browser produced results") {
+		t.Error("run page does not explain the failure")
+	}
+}
+
+func TestRunExportPolicyFormat(t *testing.T) {
+	s := newTestServer(t)
+	policy := "default-src 'self'; script-src 'self'"
+	id := seedRun(t, s, "https://example.org/a\nhttps://example.org/b", MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{
+			{URL: "https://example.org/a", Violations: []Violation{{EffectiveDirective: "script-src", BlockedURI: "https://cdn.example/a.js", BlockedOrigin: "https://cdn.example", OriginalPolicy: policy, Disposition: "enforce"}}},
+			{URL: "https://example.org/b", Violations: []Violation{{EffectiveDirective: "img-src", BlockedURI: "https://img.example/p.png", BlockedOrigin: "https://img.example", OriginalPolicy: policy, Disposition: "report"}}},
+		}},
+		"firefox": {Results: []ReportPageResult{
+			{URL: "https://example.org/b", Violations: []Violation{{EffectiveDirective: "script-src", BlockedURI: "inline", OriginalPolicy: policy, Disposition: "enforce"}}},
+		}},
+	}})
+	h := s.routes()
+	rec := get(t, h, fmt.Sprintf("/runs/export?id=%d&format=policy", id))
+	body := rec.Body.String()
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("content type = %q", ct)
+	}
+	want := "Content-Security-Policy: default-src 'self'; script-src 'self' 'unsafe-inline' https://cdn.example\n"
+	if body != want {
+		t.Errorf("policy = %q, want %q", body, want)
+	}
+	body = get(t, h, fmt.Sprintf("/runs/export?id=%d&format=policy&disposition=all", id)).Body.String()
+	if strings.Count(body, "\n") != 1 || !strings.Contains(body, "; img-src 'self' https://img.example") {
+		t.Errorf("disposition=all policy = %q", body)
+	}
+}
+
+func TestValidateNodeCommand(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "csp-check.mjs")
+	if err := os.WriteFile(script, []byte("// check"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateNodeCommand("/bin/sh", script); err != nil {
+		t.Fatalf("valid setup rejected: %v", err)
+	}
+	if err := validateNodeCommand("/bin/sh", dir); err == nil || !strings.Contains(err.Error(), "not a regular file") {
+		t.Errorf("directory as script: %v", err)
+	}
+	if err := validateNodeCommand("/bin/sh", filepath.Join(dir, "missing.mjs")); err == nil {
+		t.Errorf("missing script accepted")
+	}
+	if err := validateNodeCommand("no-such-node-binary", script); err == nil || !strings.Contains(err.Error(), "CSP_NODE_BIN") {
+		t.Errorf("missing node binary: %v", err)
+	}
+}
+
+func TestHTTPCheckerPostsURLsAndConfig(t *testing.T) {
+	var got struct {
+		URLs   []string  `json:"urls"`
+		Config CSPConfig `json:"config"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"generatedAt":"2026-01-02T03:04:05Z","config":{},"browsers":{
+			"chromium":{"totals":{"pages":1,"violations":1},"results":[{"url":"https://example.org/","ok":true,"violations":[{"effectiveDirective":"script-src","disposition":"enforce"}]}]},
+			"firefox":{"error":"firefox: not installed"}}}`))
+	}))
+	defer srv.Close()
+
+	cfg := defaultConfig()
+	cfg.UserAgent = "remote-test"
+	multi, exit, err := (&HTTPChecker{URL: srv.URL, Client: srv.Client()}).Check(context.Background(), []string{"https://example.org/"}, cfg)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if fmt.Sprint(got.URLs) != "[https://example.org/]" || got.Config.UserAgent != "remote-test" {
+		t.Errorf("service received %+v", got)
+	}
+	if multi.Browsers["chromium"].Totals.Violations != 1 || multi.Browsers["firefox"].Error == "" {
+		t.Errorf("report = %+v", multi.Browsers)
+	}
+	if exit != 2 {
+		t.Errorf("exit = %d, want 2 for an incomplete run", exit)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	if _, _, err := (&HTTPChecker{URL: failing.URL}).Check(context.Background(), []string{"https://example.org/"}, cfg); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("err = %v, want status 503", err)
+	}
+}
+
+func TestComputeScoreWeightsBySeverity(t *testing.T) {
+	run := func(directive string) MultiReport {
+		vs := []Violation{{EffectiveDirective: directive}, {EffectiveDirective: directive}}
+		return MultiReport{Browsers: map[string]Report{"chromium": {Results: []ReportPageResult{
+			{URL: "https://example.org/a", Violations: vs},
+			{URL: "https://example.org/b", Violations: []Violation{}},
+		}}}}
+	}
+	low, high := computeScore(run("img-src")), computeScore(run("script-src"))
+	if low <= high {
+		t.Errorf("low-severity score %d not above high-severity score %d", low, high)
+	}
+	if low != 95 || high != 75 {
+		t.Errorf("scores = %d, %d; want 95, 75", low, high)
+	}
+	if got := computeScore(MultiReport{}); got != 100 {
+		t.Errorf("empty run score = %d", got)
+	}
+	if s := summarizeMulti(run("script-src")); s.Score == nil || *s.Score != high {
+		t.Errorf("summary score = %v", s.Score)
+	}
+}
+
+func TestRunAllProfilesFindsFewestEnforced(t *testing.T) {
+	s := newTestServer(t)
+	strict := defaultConfig()
+	strict.UserAgent = "strict"
+	cfgJSON, _ := json.Marshal(strict)
+	if err := s.createProfile(context.Background(), "Strict", string(cfgJSON)); err != nil {
+		t.Fatalf("create profile: %v", err)
+	}
+	s.checker = CheckerFunc(func(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
+		vs := []Violation{{EffectiveDirective: "img-src", Disposition: "enforce"}}
+		if cfg.UserAgent != "strict" {
+			vs = append(vs, Violation{EffectiveDirective: "script-src", Disposition: "enforce"})
+		}
+		return MultiReport{Browsers: map[string]Report{"chromium": {Results: []ReportPageResult{{URL: urls[0], Violations: vs}}}}}, 1, nil
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/runs/all-profiles", strings.NewReader("urls=https%3A%2F%2Fexample.org%2F"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	runs, err := s.listRuns(context.Background(), "")
+	if err != nil || len(runs) != 2 {
+		t.Fatalf("runs = %d, err %v", len(runs), err)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `Strict <span class="badge">fewest enforced`) || strings.Count(body, "fewest enforced") != 1 {
+		t.Errorf("comparison does not single out Strict:\n%s", body)
+	}
+}
+
+func TestRerunHistoryReexecutesRunsAndCountsChanges(t *testing.T) {
+	s := newTestServer(t)
+	script := Violation{EffectiveDirective: "script-src", BlockedOrigin: "https://cdn.example", Disposition: "enforce"}
+	first := seedRun(t, s, "https://example.org/a", MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{{URL: "https://example.org/a", Violations: []Violation{script}}}},
+	}})
+	second := seedRun(t, s, "https://example.org/b", MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{{URL: "https://example.org/b"}}},
+	}})
+	var checked []string
+	var mu sync.Mutex
+	s.checker = CheckerFunc(func(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
+		mu.Lock()
+		checked = append(checked, urls...)
+		mu.Unlock()
+		// Page a is unchanged; page b now has a violation it did not have.
+		v := script
+		if urls[0] == "https://example.org/b" {
+			v = Violation{EffectiveDirective: "img-src", BlockedOrigin: "https://ads.example", Disposition: "enforce"}
+		}
+		return MultiReport{Browsers: map[string]Report{"chromium": {Results: []ReportPageResult{{URL: urls[0], Violations: []Violation{v}}}}}}, 1, nil
+	})
+	p, err := s.getProfileByName(context.Background(), defaultProfileName)
+	if err != nil {
+		t.Fatalf("default profile: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/profiles/rerun-history", strings.NewReader(fmt.Sprintf("profile_id=%d", p.ID)))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/rerun-jobs/1" {
+		t.Fatalf("start: %d %q %s", rec.Code, rec.Header().Get("Location"), rec.Body)
+	}
+	got := s.rerunJob(1).wait()
+	if len(checked) != 2 {
+		t.Fatalf("checked %v, want both historical runs", checked)
+	}
+	if got.Total != 2 || got.Done != 2 || got.Changed != 1 || got.Unchanged != 1 || got.Failed != 0 {
+		t.Fatalf("progress = %+v", got)
+	}
+	if got.Outcomes[0].SourceRunID != first || got.Outcomes[0].Changed() ||
+		got.Outcomes[1].SourceRunID != second || got.Outcomes[1].New != 1 || got.Outcomes[1].Resolved != 0 {
+		t.Errorf("outcomes = %+v", got.Outcomes)
+	}
+
+	events := get(t, s.routes(), "/rerun-jobs/1/events")
+	if !strings.HasPrefix(events.Body.String(), "event: done\n") || !strings.Contains(events.Body.String(), `"changed":1`) {
+		t.Errorf("events = %s", events.Body)
+	}
+	if page := get(t, s.routes(), "/rerun-jobs/1"); page.Code != http.StatusOK || !strings.Contains(page.Body.String(), "Finished.") {
+		t.Errorf("progress page: %d", page.Code)
+	}
+
+	s.jobsMu.Lock()
+	s.pruneRerunJobs(time.Now().Add(rerunJobTTL + time.Minute))
+	s.jobsMu.Unlock()
+	if page := get(t, s.routes(), "/rerun-jobs/1"); page.Code != http.StatusNotFound {
+		t.Errorf("expired job page: %d, want 404", page.Code)
+	}
+}
+
+func TestRunCSVChecksEachURLUnderItsProfile(t *testing.T) {
+	s := newTestServer(t)
+	strict := defaultConfig()
+	strict.UserAgent = "strict"
+	cfgJSON, _ := json.Marshal(strict)
+	if err := s.createProfile(context.Background(), "Strict Mode", string(cfgJSON)); err != nil {
+		t.Fatalf("create profile: %v", err)
+	}
+	checked := map[string]string{}
+	s.checker = CheckerFunc(func(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
+		var results []ReportPageResult
+		for _, u := range urls {
+			checked[u] = cfg.UserAgent
+			results = append(results, ReportPageResult{URL: u, OK: true})
+		}
+		return MultiReport{Browsers: map[string]Report{"chromium": {Totals: ReportTotals{Pages: len(urls)}, Results: results}}}, 0, nil
+	})
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "urls.csv")
+	fw.Write([]byte("url,profile\nhttps://example.org/a,Default\nhttps://example.org/b,Strict Mode\n# skipped\nhttps://example.org/c,Strict Mode\nhttps://example.org/d,\n"))
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/runs/csv", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	def := defaultConfig().UserAgent
+	want := map[string]string{"https://example.org/a": def, "https://example.org/b": "strict", "https://example.org/c": "strict", "https://example.org/d": def}
+	if fmt.Sprint(checked) != fmt.Sprint(want) {
+		t.Fatalf("checked = %v, want %v", checked, want)
+	}
+	runs, err := s.listRuns(context.Background(), "")
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("runs = %d, err %v", len(runs), err)
+	}
+	multi, err := loadMultiReport(runs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(multi.Browsers["chromium"].Results); n != 4 || multi.Browsers["chromium"].Totals.Pages != 4 {
+		t.Errorf("merged results = %d", n)
+	}
+	tags := groupPagesByTag(runs[0].URLsText, multi)
+	if len(tags) != 2 || tags[1].Tag != "Strict-Mode" || len(tags[1].Pages) != 2 {
+		t.Errorf("tags = %+v", tags)
+	}
+
+	if _, err := parseProfileCSV(strings.NewReader("example.org,Default\n")); err == nil {
+		t.Errorf("relative url accepted")
+	}
+	body.Reset()
+	mw = multipart.NewWriter(&body)
+	fw, _ = mw.CreateFormFile("file", "urls.csv")
+	fw.Write([]byte("https://example.org/,Missing\n"))
+	mw.Close()
+	req = httptest.NewRequest(http.MethodPost, "/runs/csv", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec = httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `unknown profile: "Missing"`) {
+		t.Errorf("unknown profile: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestViolationThresholdFailsRun(t *testing.T) {
+	v := Violation{EffectiveDirective: "img-src", Disposition: "report"}
+	multi := MultiReport{Browsers: map[string]Report{"chromium": {Results: []ReportPageResult{
+		{URL: "https://example.org/a", Violations: []Violation{v}},
+		{URL: "https://example.org/b", Violations: []Violation{v, v, v}},
+	}}}}
+	cfg := defaultConfig()
+	if got := computeExitCode(multi, 0, cfg); got != 0 {
+		t.Fatalf("report-only violations without a limit: exit %d", got)
+	}
+	cfg.MaxViolationsPerPage = 2
+	applyViolationThreshold(&multi, cfg.MaxViolationsPerPage)
+	if !multi.ThresholdExceeded {
+		t.Fatal("ThresholdExceeded not set")
+	}
+	pages := multi.Browsers["chromium"].Results
+	if pages[0].OverThreshold || !pages[1].OverThreshold {
+		t.Errorf("OverThreshold = %v, %v", pages[0].OverThreshold, pages[1].OverThreshold)
+	}
+	if got := computeExitCode(multi, 0, cfg); got != 1 {
+		t.Errorf("exit code = %d, want 1", got)
+	}
+	applyViolationThreshold(&multi, 3)
+	if multi.ThresholdExceeded || multi.Browsers["chromium"].Results[1].OverThreshold {
+		t.Error("a page at the limit should not trip it")
+	}
+
+	script := filepath.Join(t.TempDir(), "fake-check.sh")
+	body := `v='{"effectiveDirective":"img-src","disposition":"report"}'
+printf '{"totals":{"pages":1,"violations":2},"results":[{"url":"https://example.org/","ok":true,"violations":[%s,%s]}]}' "$v" "$v" > "$CSP_OUTPUT_FILE"
+`
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CSP_NODE_BIN", "/bin/sh")
+	t.Setenv("CSP_SCRIPT_PATH", script)
+	cfg.MaxViolationsPerPage = 1
+	got, exit, err := runCSPCheck(context.Background(), []string{"https://example.org/"}, cfg, 1)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if !got.ThresholdExceeded || exit != 1 {
+		t.Errorf("ThresholdExceeded = %v, exit = %d", got.ThresholdExceeded, exit)
+	}
+	if got.Browsers[browsers[0]].Error != "" || !strings.Contains(got.Browsers[browsers[1]].Error, "skipped") {
+		t.Errorf("later browsers were not skipped: %+v", got.Browsers)
+	}
+}
+
+func TestRunExportSnakeCaseNaming(t *testing.T) {
+	s := newTestServer(t)
+	id := seedRun(t, s, "https://example.org/", MultiReport{Browsers: map[string]Report{"chromium": {
+		Results: []ReportPageResult{{URL: "https://example.org/", Violations: []Violation{{EffectiveDirective: "script-src", BlockedURI: "https://cdn.example/a.js"}}}},
+	}}})
+	h := s.routes()
+	body := get(t, h, fmt.Sprintf("/runs/export?id=%d&naming=snake", id)).Body.String()
+	if !strings.Contains(body, `"effective_directive":"script-src"`) || !strings.Contains(body, `"blocked_uri"`) || strings.Contains(body, "effectiveDirective") {
+		t.Errorf("snake export = %s", body)
+	}
+	body = get(t, h, fmt.Sprintf("/runs/export?id=%d", id)).Body.String()
+	if !strings.Contains(body, `"effectiveDirective"`) {
+		t.Errorf("default export lost camelCase keys: %s", body)
+	}
+	if rec := get(t, h, fmt.Sprintf("/runs/export?id=%d&naming=kebab", id)); rec.Code != http.StatusBadRequest {
+		t.Errorf("naming=kebab status = %d", rec.Code)
+	}
+	if got := snakeCase("Content-Security-Policy"); got != "Content-Security-Policy" {
+		t.Errorf("snakeCase touched a header name: %q", got)
+	}
+}
+
+func TestIntersectReportsSeparatesFlakyViolations(t *testing.T) {
+	stable := Violation{DocumentURI: "https://example.org/", EffectiveDirective: "script-src", BlockedURI: "https://cdn.example/a.js", BlockedOrigin: "https://cdn.example"}
+	once := Violation{DocumentURI: "https://example.org/", EffectiveDirective: "img-src", BlockedURI: "https://ads.example/p.gif", BlockedOrigin: "https://ads.example"}
+	pass := func(vs ...Violation) MultiReport {
+		return MultiReport{Browsers: map[string]Report{"chromium": {
+			Totals:  ReportTotals{Pages: 1, Violations: len(vs)},
+			Results: []ReportPageResult{{URL: "https://example.org/", Violations: vs}},
+		}}}
+	}
+	got, flaky := intersectReports(pass(stable, once), pass(stable))
+	rep := got.Browsers["chromium"]
+	if len(rep.Results[0].Violations) != 1 || rep.Results[0].Violations[0].EffectiveDirective != "script-src" || rep.Totals.Violations != 1 {
+		t.Fatalf("stable = %+v", rep)
+	}
+	if len(flaky) != 1 || flaky[0].EffectiveDirective != "img-src" {
+		t.Fatalf("flaky = %+v", flaky)
+	}
+
+	s := newTestServer(t)
+	passes := []MultiReport{pass(stable), pass(stable, once)}
+	s.checker = CheckerFunc(func(ctx context.Context, urls []string, cfg CSPConfig) (MultiReport, int, error) {
+		rep := passes[0]
+		passes = passes[1:]
+		return rep, 1, nil
+	})
+	cfg := defaultConfig()
+	cfg.DeFlake = true
+	id, err := s.executeRun(context.Background(), sql.NullInt64{}, "https://example.org/", cfg)
+	if err != nil {
+		t.Fatalf("executeRun: %v", err)
+	}
+	body := get(t, s.routes(), fmt.Sprintf("/runs/%d", id)).Body.String()
+	if !strings.Contains(body, "Flaky Violations") || !strings.Contains(body, "img-src → https://ads.example") {
+		t.Error("run page does not list the flaky violation")
+	}
+}
+
+func TestMergeReportsOfChunks(t *testing.T) {
+	settled, unsettled := true, false
+	a := Report{GeneratedAt: "2024-01-01T00:00:00Z", Settled: &settled, Totals: ReportTotals{Pages: 2, Violations: 1}, Results: []ReportPageResult{
+		{URL: "https://example.org/a"}, {URL: "https://example.org/b", Violations: []Violation{{EffectiveDirective: "img-src"}}},
+	}}
+	b := Report{Settled: &unsettled, Totals: ReportTotals{Pages: 1, Violations: 2}, Results: []ReportPageResult{
+		{URL: "https://example.org/c", Violations: make([]Violation, 2)},
+	}}
+	merged := mergeReports([]Report{a, b}, true)
+	if merged.Totals != (ReportTotals{Pages: 3, Violations: 3}) {
+		t.Fatalf("totals = %+v", merged.Totals)
+	}
+	var urls []string
+	for _, r := range merged.Results {
+		urls = append(urls, r.URL)
+	}
+	if fmt.Sprint(urls) != "[https://example.org/a https://example.org/b https://example.org/c]" {
+		t.Fatalf("results = %v", urls)
+	}
+	if merged.Settled == nil || *merged.Settled || merged.GeneratedAt != a.GeneratedAt {
+		t.Fatalf("settled/generatedAt not carried over: %+v", merged)
+	}
+	if len(a.Results) != 2 {
+		t.Fatal("first chunk's results were modified")
+	}
+}
+
+func TestWorkerPoolSplitsURLsIntoChunks(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "fake-check.sh")
+	body := `results=""
+n=0
+while IFS= read -r u || [ -n "$u" ]; do
+  [ -n "$results" ] && results="$results,"
+  results="$results{\"url\":\"$u\",\"ok\":true,\"violations\":[]}"
+  n=$((n+1))
+done < "$1"
+printf '{"totals":{"pages":%d,"violations":0},"results":[%s]}' "$n" "$results" > "$CSP_OUTPUT_FILE"
+`
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CSP_NODE_BIN", "/bin/sh")
+	t.Setenv("CSP_SCRIPT_PATH", script)
+
+	urls := []string{"https://example.org/1", "https://example.org/2", "https://example.org/3"}
+	multi, _, err := runCSPCheck(context.Background(), urls, defaultConfig(), 2)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	for _, b := range browsers {
+		rep := multi.Browsers[b]
+		var got []string
+		for _, r := range rep.Results {
+			got = append(got, r.URL)
+		}
+		if rep.Totals.Pages != 3 || fmt.Sprint(got) != fmt.Sprint(urls) {
+			t.Fatalf("%s: pages=%d results=%v", b, rep.Totals.Pages, got)
+		}
+	}
+}
+
+func TestRenderTruncatesOutputPastLimit(t *testing.T) {
+	s := newTestServer(t)
+	s.maxRenderBytes = 200
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	body := get(t, s.routes(), "/runs").Body.String()
+	page, note, ok := strings.Cut(body, "\n<p class=\"warning\">Page truncated")
+	if !ok || len(page) != 200 || strings.Contains(note, "Run History") {
+		t.Fatalf("body not cut at 200 bytes: %d bytes, note found %v", len(page), ok)
+	}
+	if !strings.Contains(logs.String(), "render runs.html: output truncated at 200 bytes") {
+		t.Errorf("truncation not logged: %q", logs.String())
+	}
+}
+
+func TestRunsDateRangeFiltersByCreatedAt(t *testing.T) {
+	s := newTestServer(t)
+	ids := map[string]int64{}
+	for _, created := range []string{"2023-12-31T23:59:59Z", "2024-01-15T08:00:00Z", "2024-02-01T23:59:59.5Z", "2024-02-02T00:00:00Z"} {
+		id, err := s.insertRun(context.Background(), Run{CreatedAt: created, URLsText: "https://example.org/", SummaryJSON: "{}", ResultsJSON: "{}"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[created] = id
+	}
+	h := s.routes()
+
+	listed := func(target string) []int64 {
+		t.Helper()
+		rec := get(t, h, target)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", target, rec.Code, rec.Body.String())
+		}
+		var entries []RunListEntry
+		if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+			t.Fatal(err)
+		}
+		var got []int64
+		for _, e := range entries {
+			got = append(got, e.ID)
+		}
+		return got
+	}
+
+	got := listed("/api/runs?from=2024-01-01&to=2024-02-01")
+	want := []int64{ids["2024-02-01T23:59:59.5Z"], ids["2024-01-15T08:00:00Z"]}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("from/to: got %v, want %v", got, want)
+	}
+	if got := listed("/api/runs?from=2024-02-01"); len(got) != 2 {
+		t.Errorf("from only: got %v", got)
+	}
+	if got := listed("/api/runs?to=2023-12-31"); fmt.Sprint(got) != fmt.Sprint([]int64{ids["2023-12-31T23:59:59Z"]}) {
+		t.Errorf("to only: got %v", got)
+	}
+
+	page := get(t, h, "/runs?from=2024-01-01&to=2024-01-31").Body.String()
+	if !strings.Contains(page, fmt.Sprintf(`href="/runs/%d"`, ids["2024-01-15T08:00:00Z"])) ||
+		strings.Contains(page, fmt.Sprintf(`href="/runs/%d"`, ids["2024-02-01T23:59:59.5Z"])) {
+		t.Errorf("run history not filtered:\n%s", page)
+	}
+	if rec := get(t, h, "/runs?from=last-week"); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid from: status %d", rec.Code)
+	}
+}
+
+func TestAllOriginsAggregatesAcrossRuns(t *testing.T) {
+	v := func(origin string) Violation {
+		return Violation{EffectiveDirective: "img-src", BlockedOrigin: origin}
+	}
+	s := newTestServer(t)
+	seedRun(t, s, "https://example.org/", MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{{URL: "https://example.org/", Violations: []Violation{
+			v("https://cdn.example"), v("https://cdn.example"), v("https://img.example"), v(""),
+		}}}},
+	}})
+	seedRun(t, s, "https://example.org/", MultiReport{Browsers: map[string]Report{
+		"firefox": {Results: []ReportPageResult{{URL: "https://example.org/", Violations: []Violation{
+			v("https://cdn.example"), v("https://fonts.example"),
+			{EffectiveDirective: "img-src", BlockedURI: "data", BlockedOrigin: "null"},
+		}}}},
+	}})
+	if _, err := s.insertRun(context.Background(), Run{
+		CreatedAt:   time.Now().AddDate(0, 0, -200).UTC().Format(time.RFC3339),
+		URLsText:    "https://example.org/",
+		SummaryJSON: "{}",
+		ResultsJSON: `{"browsers":{"chromium":{"results":[{"url":"https://example.org/","violations":[{"blockedOrigin":"https://old.example"}]}]}}}`,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	h := s.routes()
+	fetch := func() []OriginCount {
+		t.Helper()
+		rec := get(t, h, "/api/all-origins?days=90")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("all origins: %d %s", rec.Code, rec.Body)
+		}
+		var got []OriginCount
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	want := []OriginCount{
+		{Origin: "https://cdn.example", Count: 3, Runs: 2},
+		{Origin: "(data: URI)", Count: 1, Runs: 1},
+		{Origin: "https://fonts.example", Count: 1, Runs: 1},
+		{Origin: "https://img.example", Count: 1, Runs: 1},
+	}
+	if got := fetch(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("origins = %v, want %v", got, want)
+	}
+
+	seedRun(t, s, "https://example.org/", MultiReport{Browsers: map[string]Report{
+		"chromium": {Results: []ReportPageResult{{URL: "https://example.org/", Violations: []Violation{v("https://new.example")}}}},
+	}})
+	if got := fetch(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("cached answer changed: %v", got)
+	}
+	if rec := get(t, h, "/api/all-origins?days=30"); !strings.Contains(rec.Body.String(), "https://new.example") {
+		t.Errorf("non-default window served from cache: %s", rec.Body)
+	}
+	if rec := get(t, h, "/api/all-origins?days=0"); rec.Code != http.StatusBadRequest {
+		t.Errorf("days=0: status %d", rec.Code)
+	}
+}
+
+func TestFailedRunsDoNotCountAsResults(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	def, _ := s.getProfileByName(ctx, defaultProfileName)
+	profileID := sql.NullInt64{Int64: def.ID, Valid: true}
+	s.runsPerProfile = 1
+
+	checked, err := s.createRun(ctx, profileID, "https://example.org/", `{"violations":2}`, "{}", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed, err := s.createFailedRun(ctx, profileID, "https://example.org/", errors.New("node crashed"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.getRun(ctx, checked); err != nil {
+		t.Fatalf("failed run rotated out the checked run: %v", err)
+	}
+	if last, err := s.lastRunForProfile(ctx, def.ID); err != nil || last.ID != checked {
+		t.Errorf("last run = %d (%v), want %d", last.ID, err, checked)
+	}
+	snap, err := s.statusSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.LatestRunViolations == nil || *snap.LatestRunViolations != 2 {
+		t.Errorf("status latest run violations = %v, want 2", snap.LatestRunViolations)
+	}
+	if snap.RunsLast24h != 1 || snap.ViolationsLast24h != 2 {
+		t.Errorf("status last 24h = %d runs, %d violations; want the checked run only", snap.RunsLast24h, snap.ViolationsLast24h)
+	}
+	if _, err := s.getRun(ctx, failed); err != nil {
+		t.Errorf("failed run removed early: %v", err)
+	}
+
+	canceled := func(context.Context, []string) (MultiReport, int, error) { return MultiReport{}, 0, context.Canceled }
+	if id, err := s.storeCheckedRun(ctx, profileID, "https://example.org/", canceled); id != 0 || !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled check stored as run %d (err %v)", id, err)
+	}
+}
+
+func TestInvalidStoredConfigIsReported(t *testing.T) {
+	s := newTestServer(t)
+	// Saved before basic auth required both fields.
+	if err := s.createProfile(context.Background(), "Half auth", `{"basicAuthUser":"ci"}`); err != nil {
+		t.Fatal(err)
+	}
+	p, err := s.getProfileByName(context.Background(), "Half auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ran := false
+	s.checker = CheckerFunc(func(context.Context, []string, CSPConfig) (MultiReport, int, error) {
+		ran = true
+		return MultiReport{}, 0, nil
+	})
+	if _, _, err := s.resolveConfig(context.Background(), sql.NullInt64{Int64: p.ID, Valid: true}); !errors.Is(err, errInvalidConfig) {
+		t.Fatalf("resolveConfig err = %v, want errInvalidConfig", err)
+	}
+
+	form := strings.NewReader(fmt.Sprintf("profile_id=%d&urls=https://example.org/", p.ID))
+	req := httptest.NewRequest(http.MethodPost, "/runs", form)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusConflict || ran || !strings.Contains(rec.Body.String(), "basic auth") {
+		t.Fatalf("run under invalid profile: status %d, ran %v: %s", rec.Code, ran, rec.Body)
+	}
+	if page := get(t, s.routes(), "/profiles").Body.String(); !strings.Contains(page, "Half auth has an invalid stored config") {
+		t.Errorf("profiles page does not report the invalid config")
+	}
+}
+
+func TestWithLongerTimeoutsIsCapped(t *testing.T) {
+	cases := []struct {
+		nav, settle       int
+		multiplier        float64
+		wantNav, wantWait int
+	}{
+		{30000, 1000, 0, 60000, 2000},
+		{30000, 1000, 4, 240000, 8000},
+		{200000, 40000, 0, maxRetryNavTimeoutMs, maxRetrySettleWaitMs},
+		{600000, 0, 0, 600000, 0},
+	}
+	for _, c := range cases {
+		cfg := withLongerTimeouts(CSPConfig{NavTimeoutMs: c.nav, SettleWaitMs: c.settle, TimeoutMultiplier: c.multiplier})
+		if nav, wait := cfg.effectiveTimeouts(); nav != c.wantNav || wait != c.wantWait {
+			t.Errorf("%+v: got nav %d, settle %d; want %d, %d", c, nav, wait, c.wantNav, c.wantWait)
+		}
+	}
+}
+
+func TestUpdateScheduleRestartsShorterInterval(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	id, err := s.createSchedule(ctx, sql.NullInt64{}, "https://example.org/", 24*60, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited := start.Add(time.Hour)
+	if err := s.updateSchedule(ctx, id, sql.NullInt64{}, "https://example.org/a", 24*60, edited); err != nil {
+		t.Fatal(err)
+	}
+	if due, _ := s.dueSchedules(ctx, start.Add(23*time.Hour)); len(due) != 0 {
+		t.Fatalf("URL edit moved the schedule: %+v", due)
+	}
+	if err := s.updateSchedule(ctx, id, sql.NullInt64{}, "https://example.org/a", 5, edited); err != nil {
+		t.Fatal(err)
+	}
+	if due, _ := s.dueSchedules(ctx, edited.Add(5*time.Minute)); len(due) != 1 {
+		t.Fatalf("shortened schedule not due after 5 minutes: %+v", due)
+	}
+}